@@ -1,79 +1,157 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
-	corev1 "k8s.io/api/core/v1"
+	"go.uber.org/zap"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/scheuk/opnsense-lb-controller/internal/config"
 	"github.com/scheuk/opnsense-lb-controller/internal/controller"
+	"github.com/scheuk/opnsense-lb-controller/internal/credentials"
 	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+	"github.com/scheuk/opnsense-lb-controller/internal/tracing"
 )
 
+// startupTimeout bounds the API calls and the credentials command run before the manager starts,
+// so an unreachable API server or firewall, or a hanging exec plugin, does not block startup.
+const startupTimeout = 30 * time.Second
+
 func main() {
-	kubeconfig := flag.String("kubeconfig", "", "Path to kubeconfig; empty for in-cluster")
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(runRender(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	metricsAddr := flag.String("metrics-bind-address", "0",
+		"Address the metrics endpoint binds to, e.g. :8443 for HTTPS or :8080 for HTTP; 0 disables it, "+
+			"and with it /debug/plan in a dry run")
+	secureMetrics := flag.Bool("metrics-secure", true,
+		"Serve metrics over HTTPS and require an authorized bearer token")
+	metricsCertPath := flag.String("metrics-cert-path", "",
+		"Directory with tls.crt and tls.key for the metrics server; empty for a self-signed certificate")
+	probeAddr := flag.String("health-probe-bind-address", ":8081", "Address the /healthz and /readyz endpoints bind to")
+	leaderElect := flag.Bool("leader-elect", true, "Enable leader election, so only one replica reconciles")
+	enableHTTP2 := flag.Bool("enable-http2", false, "Enable HTTP/2 for the metrics server")
+	cfgFlags := config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	cfg := config.LoadFromEnv()
-	restCfg, err := loadKubeconfig(*kubeconfig)
+	// --kubeconfig (registered by controller-runtime) or KUBECONFIG, else in-cluster.
+	restCfg, err := ctrl.GetConfig()
 	if err != nil {
 		panic(err)
 	}
-
 	clientset, err := kubernetes.NewForConfig(restCfg)
 	if err != nil {
 		panic(err)
 	}
 
-	apiKey := os.Getenv("OPNSENSE_API_KEY")
-	apiSecret := os.Getenv("OPNSENSE_API_SECRET")
-	if cfg.OPNsenseSecretName != "" {
-		sec, err := clientset.CoreV1().Secrets(cfg.OPNsenseSecretNamespace).
-			Get(context.Background(), cfg.OPNsenseSecretName, metav1.GetOptions{})
-		if err != nil {
-			panic(err)
-		}
-		if k := sec.Data["apiKey"]; len(k) > 0 {
-			apiKey = string(k)
-		}
-		if s := sec.Data["apiSecret"]; len(s) > 0 {
-			apiSecret = string(s)
+	var cfg *config.Config
+	var configMap types.NamespacedName
+	if ref := cfgFlags.ConfigMap(); ref != "" {
+		namespace, name, ok := strings.Cut(ref, "/")
+		if !ok || namespace == "" || name == "" || cfgFlags.ConfigFile() != "" {
+			_, _ = fmt.Fprintln(os.Stderr,
+				"opnsense-lb-controller: --config-map must be <namespace>/<name> and cannot be combined with --config")
+			os.Exit(1)
 		}
-		if apiKey == "" {
-			apiKey = string(sec.Data["key"])
-			apiSecret = string(sec.Data["secret"])
+		configMap = types.NamespacedName{Namespace: namespace, Name: name}
+		getCtx, cancelGet := context.WithTimeout(context.Background(), startupTimeout)
+		cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(getCtx, name, metav1.GetOptions{})
+		cancelGet()
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "opnsense-lb-controller: could not read the config ConfigMap: %v\n", err)
+			os.Exit(1)
 		}
+		cfg, err = cfgFlags.LoadData("configmap "+ref, []byte(cm.Data[controller.ConfigMapKey]))
+	} else {
+		cfg, err = cfgFlags.Load()
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "opnsense-lb-controller: invalid configuration:\n%v\n", err)
+		os.Exit(1)
 	}
+	logLevel := zap.NewAtomicLevel()
+	setLogLevel := func(level string) { _ = logLevel.UnmarshalText([]byte(level)) }
+	setLogLevel(cfg.LogLevel)
+	ctrl.SetLogger(crzap.New(crzap.Level(&logLevel)))
+	setupLog := ctrl.Log.WithName("setup")
 
+	var provider credentials.Provider
+	switch cfg.CredentialsSource {
+	case credentials.SourceEnv:
+		provider = credentials.Env{Values: credentials.Values{
+			URL:         cfg.OPNsenseURL,
+			Credentials: opnsense.Credentials{Key: cfg.APIKey, Secret: cfg.APISecret},
+		}}
+	case credentials.SourceSecret:
+		provider = credentials.NewSecret(clientset, cfg.OPNsenseSecretNamespace, cfg.OPNsenseSecretName)
+	case credentials.SourceFile:
+		provider = credentials.NewFile(cfg.CredentialsFile)
+	case credentials.SourceExec:
+		provider = credentials.NewExec(cfg.CredentialsCommand[0], cfg.CredentialsCommand[1:]...)
+	}
+	// A source that cannot be read yet is not fatal: API calls fail with AuthenticationFailed
+	// until it is fixed, and the Syncer then picks the credentials up.
+	loadCtx, cancelLoad := context.WithTimeout(context.Background(), startupTimeout)
+	values, err := provider.Load(loadCtx)
+	cancelLoad()
+	if err != nil {
+		setupLog.Error(err, "Could not load OPNsense credentials")
+	}
+	baseURL := cmp.Or(cfg.OPNsenseURL, values.URL)
+	if baseURL == "" {
+		setupLog.Error(errors.New("no OPNsense URL"), "Set opnsense.url, or url in the credentials source")
+		os.Exit(1)
+	}
 	oc := opnsense.NewClient(opnsense.Config{
-		BaseURL:   cfg.OPNsenseURL,
-		APIKey:    apiKey,
-		APISecret: apiSecret,
+		BaseURL:   baseURL,
+		APIKey:    values.Key,
+		APISecret: values.Secret,
 	})
+	creds := credentials.NewSyncer(provider, oc, credentials.Values{URL: baseURL, Credentials: values.Credentials})
+	creds.URLPinned = cfg.OPNsenseURL != ""
 
-	if cfg.SingleVIP == "" && len(cfg.VIPPool) == 0 {
-		// Default for local/dev only; production should set VIP or VIP_POOL explicitly.
-		cfg.SingleVIP = "192.0.2.1"
-		_, _ = os.Stderr.WriteString(
-			"opnsense-lb-controller: neither VIP nor VIP_POOL set; using default 192.0.2.1 (dev only). " +
-				"Set VIP or VIP_POOL in production.\n")
-	}
 	vipAlloc := config.NewVIPAllocator(cfg)
 
+	// Warn when another cluster sharing the firewall tags VIPs from our pool; not fatal, since the
+	// firewall may be unreachable at startup and the overlap may be intentional during migration.
+	identity := opnsense.Identity{
+		ManagedBy:        "opnsense-lb-controller",
+		ClusterID:        cfg.ClusterID,
+		AdoptUnclustered: cfg.AdoptUnclustered,
+	}
+	ourVIPs := append([]string{cfg.SingleVIP}, cfg.VIPPool...)
+	for _, pool := range cfg.VIPPools {
+		ourVIPs = append(ourVIPs, pool...)
+	}
+	checkCtx, cancelCheck := context.WithTimeout(context.Background(), startupTimeout)
+	if warnings, err := controller.ForeignVIPOwners(checkCtx, oc, identity, ourVIPs); err != nil {
+		setupLog.Error(err, "Could not check VIP ownership on OPNsense")
+	} else {
+		for _, w := range warnings {
+			setupLog.Info("VIP from our pool is owned by another controller", "warning", w)
+		}
+	}
+	cancelCheck()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go func() {
@@ -83,72 +161,143 @@ func main() {
 		cancel()
 	}()
 
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracesExporter)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		// Flush the spans of the last reconciles; the manager context is already cancelled.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(shutdownCtx)
+	}()
+
+	// HTTP/2 is disabled by default to avoid the HTTP/2 Stream Cancellation and Rapid Reset CVEs.
+	var tlsOpts []func(*tls.Config)
+	if !*enableHTTP2 {
+		tlsOpts = append(tlsOpts, func(c *tls.Config) { c.NextProtos = []string{"http/1.1"} })
+	}
+	metricsOpts := metricsserver.Options{
+		BindAddress:   *metricsAddr,
+		SecureServing: *secureMetrics,
+		CertDir:       *metricsCertPath,
+		TLSOpts:       tlsOpts,
+	}
+	if *secureMetrics {
+		// Scrapers authenticate with a bearer token authorized for GET /metrics (see
+		// config/rbac/metrics_reader_role.yaml).
+		metricsOpts.FilterProvider = filters.WithAuthenticationAndAuthorization
+	}
+
 	mgr, err := ctrl.NewManager(restCfg, ctrl.Options{
 		Scheme:                  scheme.Scheme,
-		LeaderElection:          true,
+		Metrics:                 metricsOpts,
+		HealthProbeBindAddress:  *probeAddr,
+		LeaderElection:          *leaderElect,
 		LeaderElectionNamespace: cfg.LeaseNamespace,
 		LeaderElectionID:        cfg.LeaseName,
+		Cache:                   controller.CacheOptions(cfg.WatchNamespaces),
 	})
 	if err != nil {
 		panic(err)
 	}
 
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		panic(err)
+	}
+	if err := mgr.AddReadyzCheck("informers", controller.CacheSyncCheck(mgr.GetCache())); err != nil {
+		panic(err)
+	}
+	if err := mgr.Add(creds); err != nil {
+		panic(err)
+	}
+	if cfg.FirewallProbeInterval > 0 {
+		probe := controller.NewFirewallProbe(oc, cfg.FirewallProbeInterval, 3*cfg.FirewallProbeInterval)
+		if err := mgr.Add(probe); err != nil {
+			panic(err)
+		}
+		if err := mgr.AddReadyzCheck("opnsense", probe.Check); err != nil {
+			panic(err)
+		}
+	}
+
+	// In a dry run the Reconciler plans its changes on a wrapper that reads from OPNsense; the
+	// garbage collector only reports orphans.
+	var reconcileClient opnsense.Client = oc
+	if cfg.DryRun {
+		dry := opnsense.NewDryRun(oc)
+		if err := mgr.AddMetricsServerExtraHandler("/debug/plan", dry); err != nil {
+			panic(err)
+		}
+		if *metricsAddr == "0" {
+			setupLog.Info("Dry run plan is not served: /debug/plan needs --metrics-bind-address")
+		}
+		reconcileClient = dry
+	}
+
 	rec := controller.NewReconciler(
 		mgr.GetClient(),
 		mgr.GetEventRecorderFor("opnsense-lb-controller"), //nolint:staticcheck // SA1019: use GetEventRecorder later
-		oc,
+		reconcileClient,
 		vipAlloc,
 		cfg.LoadBalancerClass,
 		"opnsense-lb-controller",
 		"opnsense.org/opnsense-lb",
 	)
 
-	endpointsEnqueue := handler.EnqueueRequestsFromMapFunc(
-		func(ctx context.Context, obj client.Object) []reconcile.Request {
-			return []reconcile.Request{
-				{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}}
-		})
-
-	servicesEnqueueForNode := func(cl client.Reader, loadBalancerClass string) handler.MapFunc {
-		return func(ctx context.Context, obj client.Object) []reconcile.Request {
-			var list corev1.ServiceList
-			if err := cl.List(ctx, &list); err != nil {
-				return nil
-			}
-			var reqs []reconcile.Request
-			for i := range list.Items {
-				svc := &list.Items[i]
-				if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-					continue
-				}
-				if svc.Spec.LoadBalancerClass == nil || *svc.Spec.LoadBalancerClass != loadBalancerClass {
-					continue
-				}
-				reqs = append(reqs, reconcile.Request{
-					NamespacedName: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}})
-			}
-			return reqs
-		}
+	rec.ClusterID = cfg.ClusterID
+	rec.AdoptUnclustered = cfg.AdoptUnclustered
+	rec.Interface = cfg.DefaultInterface
+	rec.Paused = cfg.Paused
+	rec.DriftCheckInterval = cfg.DriftCheckInterval
+	rec.PassRules = controller.PassRuleOptions{Log: cfg.PassRuleLog, Sequence: cfg.PassRuleSequence}
+	if cfg.DriftPolicy == string(controller.DriftPolicyReport) {
+		rec.DriftPolicy = controller.DriftPolicyReport
 	}
 
-	if err := ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		WithEventFilter(controller.ServiceLoadBalancerClass(cfg.LoadBalancerClass)).
-		Watches(&corev1.Endpoints{}, endpointsEnqueue). //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
-		Watches(&corev1.Node{},
-			handler.EnqueueRequestsFromMapFunc(servicesEnqueueForNode(mgr.GetClient(), cfg.LoadBalancerClass))).
-		Complete(rec); err != nil {
+	if err := rec.SetupWithManager(mgr); err != nil {
 		panic(err)
 	}
 
-	if err := mgr.Start(ctx); err != nil {
-		panic(err)
+	if path := cfgFlags.ConfigFile(); path != "" || configMap.Name != "" {
+		reloader := controller.NewConfigReloader(cfgFlags.LoadData, cfg, rec, setLogLevel)
+		reloader.Path = path
+		if configMap.Name != "" {
+			reloader.Clientset = clientset
+			reloader.ConfigMap = configMap
+		}
+		reloader.Elected = mgr.Elected()
+		if err := mgr.Add(reloader); err != nil {
+			panic(err)
+		}
 	}
-}
 
-func loadKubeconfig(path string) (*rest.Config, error) {
-	if path != "" {
-		return clientcmd.BuildConfigFromFlags("", path)
+	if cfg.GCInterval > 0 {
+		var keepVIPs []string
+		if cfg.SingleVIP != "" {
+			keepVIPs = append(keepVIPs, cfg.SingleVIP)
+		}
+		gc := controller.NewGarbageCollector(
+			mgr.GetClient(),
+			oc,
+			vipAlloc,
+			cfg.LoadBalancerClass,
+			"opnsense-lb-controller",
+			"opnsense.org/opnsense-lb",
+			cfg.GCInterval,
+			cfg.GCGracePeriod,
+			cfg.GCDryRun || cfg.DryRun,
+			keepVIPs,
+		)
+		gc.ClusterID = cfg.ClusterID
+		gc.Namespaces = cfg.WatchNamespaces
+		gc.Paused = rec.IsPaused
+		if err := mgr.Add(gc); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := mgr.Start(ctx); err != nil {
+		panic(err)
 	}
-	return rest.InClusterConfig()
 }
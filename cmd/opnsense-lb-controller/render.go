@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/controller"
+)
+
+// runRender implements the render subcommand: it prints the OPNsense objects the controller
+// would create for the LoadBalancer Services of a manifest, without a cluster or a firewall.
+func runRender(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(stderr,
+			"Usage: opnsense-lb-controller render -f svc.yaml [--endpoints ep.yaml] [--nodes nodes.yaml] [flags]")
+		fs.PrintDefaults()
+	}
+	file := fs.String("f", "", "Manifest with the Services to render; other kinds are skipped")
+	endpointsFile := fs.String("endpoints", "",
+		"Manifest with the Endpoints of the Services; without it, rules have no backends")
+	nodesFile := fs.String("nodes", "",
+		"Manifest with the Nodes the Endpoints run on, e.g. from kubectl get nodes -o yaml")
+	output := fs.String("o", "table", "Output format: table or json")
+	// The other settings default as in the controller.
+	opts := controller.NewRenderOptions(config.Default())
+	fs.StringVar(&opts.VIP, "vip", "", "VIP of every Service; empty for its opnsense.org/load-balancer-ip or status")
+	fs.StringVar(&opts.Interface, "interface", opts.Interface,
+		"Default OPNsense interface, as defaultInterface in the config file")
+	fs.StringVar(&opts.ClusterID, "cluster-id", opts.ClusterID, "Cluster ID, as clusterID in the config file")
+	fs.StringVar(&opts.LoadBalancerClass, "load-balancer-class", opts.LoadBalancerClass,
+		"Render only Services of this class, as loadBalancerClass in the config file")
+	fs.BoolVar(&opts.PassRules.Log, "pass-rule-log", opts.PassRules.Log, "As passRules.log in the config file")
+	fs.IntVar(&opts.PassRules.Sequence, "pass-rule-sequence", opts.PassRules.Sequence,
+		"As passRules.sequence in the config file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || (*output != "table" && *output != "json") {
+		fs.Usage()
+		return 2
+	}
+	opts.ManagedBy = "opnsense-lb-controller"
+
+	rendered, skipped, err := render(*file, *endpointsFile, *nodesFile, opts)
+	for _, key := range skipped {
+		_, _ = fmt.Fprintf(stderr, "opnsense-lb-controller render: skipping %s: not of loadBalancerClass %q\n",
+			key, opts.LoadBalancerClass)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "opnsense-lb-controller render: "+err.Error())
+		return 1
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rendered)
+	} else {
+		for i, r := range rendered {
+			if i > 0 {
+				_, _ = fmt.Fprintln(stdout)
+			}
+			if err = r.WriteTable(stdout); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, "opnsense-lb-controller render: "+err.Error())
+		return 1
+	}
+	return 0
+}
+
+// render reads the manifests and renders every LoadBalancer Service in file of
+// opts.LoadBalancerClass. It returns the keys of the LoadBalancer Services of other classes,
+// which the controller ignores, as skipped.
+func render(file, endpointsFile, nodesFile string,
+	opts controller.RenderOptions) (out []*controller.Rendered, skipped []string, err error) {
+	var services []corev1.Service
+	if err := readManifest(file, "Service", &services); err != nil {
+		return nil, nil, err
+	}
+	var endpoints []corev1.Endpoints //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if err := readManifest(endpointsFile, "Endpoints", &endpoints); err != nil {
+		return nil, nil, err
+	}
+	var nodes []corev1.Node
+	if err := readManifest(nodesFile, "Node", &nodes); err != nil {
+		return nil, nil, err
+	}
+
+	for i := range services {
+		svc := &services[i]
+		svc.Namespace = defaultNamespace(svc.Namespace)
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		if svc.Spec.LoadBalancerClass == nil || *svc.Spec.LoadBalancerClass != opts.LoadBalancerClass {
+			skipped = append(skipped, svc.Namespace+"/"+svc.Name)
+			continue
+		}
+		var eps *corev1.Endpoints //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+		for j := range endpoints {
+			if endpoints[j].Name == svc.Name && defaultNamespace(endpoints[j].Namespace) == svc.Namespace {
+				eps = &endpoints[j]
+			}
+		}
+		r, err := controller.Render(svc, eps, nodes, opts)
+		if err != nil {
+			if opts.VIP == "" {
+				err = fmt.Errorf("%w (or pass --vip)", err)
+			}
+			return nil, skipped, err
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return nil, skipped, fmt.Errorf("%s has no Service of type LoadBalancer and class %q", file, opts.LoadBalancerClass)
+	}
+	return out, skipped, nil
+}
+
+// readManifest appends the objects of kind in the YAML or JSON documents of path to out, a
+// pointer to a slice of that kind; Lists are unpacked and other kinds skipped. An empty path
+// reads nothing.
+func readManifest[T any](path, kind string, out *[]T) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		var meta struct {
+			Kind  string            `json:"kind"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := yaml.Unmarshal(doc, &meta); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		items := [][]byte{doc}
+		if strings.HasSuffix(meta.Kind, "List") {
+			items = items[:0]
+			for _, item := range meta.Items {
+				items = append(items, item)
+			}
+		}
+		for _, item := range items {
+			var obj struct {
+				Kind string `json:"kind"`
+			}
+			if err := yaml.Unmarshal(item, &obj); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if obj.Kind != kind {
+				continue
+			}
+			var v T
+			if err := yaml.Unmarshal(item, &v); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, kind, err)
+			}
+			*out = append(*out, v)
+		}
+	}
+}
+
+// defaultNamespace returns namespace, or "default" for a manifest without one, as kubectl does.
+func defaultNamespace(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
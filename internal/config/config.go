@@ -1,60 +1,145 @@
 package config
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Config holds controller configuration from env or flags.
+// Config holds controller configuration; see Load for where it comes from.
 type Config struct {
 	LoadBalancerClass       string
 	OPNsenseURL             string
 	OPNsenseSecretName      string
 	OPNsenseSecretNamespace string
+	// CredentialsSource is where the OPNsense API credentials (and, unless OPNsenseURL is set,
+	// the URL) come from: "env" (APIKey and APISecret), "secret" (OPNsenseSecretName), "file"
+	// (CredentialsFile) or "exec" (CredentialsCommand). It defaults to "secret" when
+	// OPNsenseSecretName is set.
+	CredentialsSource  string
+	CredentialsFile    string
+	CredentialsCommand []string
+	// APIKey and APISecret are only read from the environment, never from flags or the file.
+	APIKey    string
+	APISecret string
+	// DefaultInterface is the OPNsense interface of Services without an interface annotation.
+	DefaultInterface string
 	// SingleVIP is used when set; otherwise VIPPool is used for allocation.
 	SingleVIP string
 	VIPPool   []string
+	// VIPPools are additional named pools a Service can select with an annotation.
+	VIPPools map[string][]string
+	// WatchNamespaces restricts the Services (and Endpoints) the controller caches and manages;
+	// empty means all namespaces.
+	WatchNamespaces []string
+	// ClusterID is embedded in every OPNsense object description so several clusters can share
+	// one firewall; empty for a single-cluster setup.
+	ClusterID string
+	// AdoptUnclustered lets the controller take over the objects it tagged without a cluster ID,
+	// to give an existing single-cluster setup a ClusterID. It requires ClusterID.
+	AdoptUnclustered bool
 	// LeaderElection
 	LeaseNamespace string
 	LeaseName      string
+	// Garbage collection of orphaned OPNsense objects. GCInterval 0 disables it;
+	// GCDryRun only reports orphans instead of removing them.
+	GCInterval    time.Duration
+	GCGracePeriod time.Duration
+	GCDryRun      bool
+	// DriftCheckInterval is how often synced Services are compared with OPNsense; 0 disables it.
+	// DriftPolicy is "repair" (re-apply) or "report" (Events and metrics only).
+	DriftCheckInterval time.Duration
+	DriftPolicy        string
+	// PassRuleLog enables logging on the filter pass rules of every NAT rule; PassRuleSequence
+	// positions them among the filter rules (0 appends them).
+	PassRuleLog      bool
+	PassRuleSequence int
+	// FirewallProbeInterval is how often the OPNsense API is probed for the readiness check; the
+	// controller is not ready after three intervals without a successful call. 0 disables the check.
+	FirewallProbeInterval time.Duration
+	// TracesExporter is "otlp" to export traces with the standard OTEL_EXPORTER_OTLP_* settings,
+	// or "none" (default).
+	TracesExporter string
+	// Paused stops all changes on OPNsense, e.g. during firewall maintenance; Services report
+	// the pending changes in their Paused condition instead.
+	Paused bool
+	// DryRun plans every change on OPNsense without making it; reads still go to the firewall.
+	DryRun bool
+	// LogLevel is "debug", "info" (default) or "error".
+	LogLevel string
 }
 
-// LoadFromEnv populates Config from environment variables.
-func LoadFromEnv() *Config {
-	c := &Config{
-		LoadBalancerClass:       getEnv("LOAD_BALANCER_CLASS", "opnsense.org/opnsense-lb"),
-		OPNsenseURL:             os.Getenv("OPNSENSE_URL"),
-		OPNsenseSecretName:      os.Getenv("OPNSENSE_SECRET_NAME"),
-		OPNsenseSecretNamespace: getEnv("OPNSENSE_SECRET_NAMESPACE", "default"),
-		SingleVIP:               os.Getenv("VIP"),
-		LeaseNamespace:          getEnv("LEASE_NAMESPACE", "default"),
-		LeaseName:               getEnv("LEASE_NAME", "opnsense-lb-controller"),
+// Default returns the configuration used for every setting that is not set anywhere.
+func Default() *Config {
+	return &Config{
+		LoadBalancerClass:       "opnsense.org/opnsense-lb",
+		OPNsenseSecretNamespace: "default",
+		DefaultInterface:        "wan",
+		LeaseNamespace:          "default",
+		LeaseName:               "opnsense-lb-controller",
+		GCInterval:              10 * time.Minute,
+		GCGracePeriod:           5 * time.Minute,
+		DriftCheckInterval:      5 * time.Minute,
+		DriftPolicy:             "repair",
+		PassRuleSequence:        10000,
+		FirewallProbeInterval:   30 * time.Second,
+		TracesExporter:          "none",
+		LogLevel:                "info",
 	}
-	if pool := os.Getenv("VIP_POOL"); pool != "" {
-		for s := range strings.SplitSeq(pool, ",") {
-			s = strings.TrimSpace(s)
-			if s != "" {
-				c.VIPPool = append(c.VIPPool, s)
-			}
-		}
-	}
-	return c
 }
 
-func getEnv(key, defaultVal string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return defaultVal
+// VIPRequest narrows the VIP allocated for one Service. The zero value takes any free VIP
+// from the default pool.
+type VIPRequest struct {
+	// Pool names one of Config.VIPPools; empty for the default pool.
+	Pool string
+	// IP requests a specific VIP, which must belong to the pool.
+	IP string
 }
 
+// ErrNoVIPAvailable is returned by Allocate when every VIP of the requested pool is in use.
+var ErrNoVIPAvailable = errors.New("no VIP available")
+
 // VIPAllocator assigns a VIP for a Service. When SingleVIP is set, returns it for all;
-// otherwise allocates from VIPPool per service key and releases on Release.
-// GetVIP returns the currently allocated VIP for a service key, or "" if none.
+// otherwise allocates from VIPPool (or the named pool of the request) per service key and
+// releases on Release. Allocate keeps a Service's VIP while it satisfies the request, and
+// returns an error other than ErrNoVIPAvailable when the request cannot be satisfied at all,
+// e.g. an unknown pool or an IP assigned to another Service; the current VIP is kept then.
+// GetVIP returns the currently allocated VIP for a service key, or "" if none. Usage reports
+// the allocated and free VIPs of each pool; it is empty for a single VIP. SetPools replaces the
+// pools (see Pools) while Services keep their VIPs; it returns a *VIPsInUseError and changes
+// nothing when a VIP assigned to a Service would leave the pool it was allocated from.
 type VIPAllocator interface {
-	Allocate(serviceKey string) string
+	Allocate(serviceKey string, req VIPRequest) (string, error)
 	Release(serviceKey string)
 	GetVIP(serviceKey string) string
+	Usage() []PoolUsage
+	SetPools(pools map[string][]string) error
+}
+
+// VIPsInUseError is returned by SetPools when the new pools drop VIPs assigned to Services.
+type VIPsInUseError struct {
+	// InUse maps each dropped VIP to the key of the Service it is assigned to.
+	InUse map[string]string
+}
+
+func (e *VIPsInUseError) Error() string {
+	var vips []string
+	for _, vip := range slices.Sorted(maps.Keys(e.InUse)) {
+		vips = append(vips, fmt.Sprintf("%s (used by %s)", vip, e.InUse[vip]))
+	}
+	return "VIPs in use cannot be removed from their pool: " + strings.Join(vips, ", ")
+}
+
+// PoolUsage counts the VIPs of one pool; Pool is "" for the default pool (VIP_POOL).
+type PoolUsage struct {
+	Pool      string
+	Allocated int
+	Free      int
 }
 
 // NewVIPAllocator returns a VIPAllocator from config.
@@ -62,53 +147,145 @@ func NewVIPAllocator(cfg *Config) VIPAllocator {
 	if cfg.SingleVIP != "" {
 		return &singleVIP{vip: cfg.SingleVIP}
 	}
-	return newPoolAllocator(cfg.VIPPool)
+	return newPoolAllocator(Pools(cfg))
+}
+
+// Pools returns the VIP pools of cfg by name: VIPPools plus VIPPool as the "" pool.
+func Pools(cfg *Config) map[string][]string {
+	pools := map[string][]string{"": cfg.VIPPool}
+	maps.Copy(pools, cfg.VIPPools)
+	return pools
 }
 
 type singleVIP struct{ vip string }
 
-func (s *singleVIP) Allocate(string) string { return s.vip }
-func (s *singleVIP) Release(string)         {}
+func (s *singleVIP) Allocate(_ string, req VIPRequest) (string, error) {
+	if req.Pool != "" {
+		return "", fmt.Errorf("unknown VIP pool %q: a single VIP is configured", req.Pool)
+	}
+	if req.IP != "" && req.IP != s.vip {
+		return "", fmt.Errorf("VIP %s is not available: only %s is configured", req.IP, s.vip)
+	}
+	return s.vip, nil
+}
+
+func (s *singleVIP) Release(string) {}
 
 // GetVIP returns "" for single-VIP so the controller does not call RemoveVIP (VIP is shared).
 func (s *singleVIP) GetVIP(serviceKey string) string { return "" }
 
+func (s *singleVIP) Usage() []PoolUsage { return nil }
+
+func (s *singleVIP) SetPools(map[string][]string) error {
+	return errors.New("VIP pools cannot be set: a single VIP is configured")
+}
+
+// poolAllocator is safe for concurrent use: the garbage collector and config reloads run
+// alongside reconciles.
 type poolAllocator struct {
-	pool   []string
+	mu     sync.Mutex
+	pools  map[string][]string
 	used   map[string]string
 	assign map[string]string
+	// assignPool is the pool each Service's VIP was allocated from.
+	assignPool map[string]string
 }
 
-func newPoolAllocator(pool []string) *poolAllocator {
+func newPoolAllocator(pools map[string][]string) *poolAllocator {
 	return &poolAllocator{
-		pool:   pool,
-		used:   make(map[string]string),
-		assign: make(map[string]string),
+		pools:      pools,
+		used:       make(map[string]string),
+		assign:     make(map[string]string),
+		assignPool: make(map[string]string),
 	}
 }
 
-func (p *poolAllocator) Allocate(serviceKey string) string {
-	if vip, ok := p.assign[serviceKey]; ok {
-		return vip
+func (p *poolAllocator) Allocate(serviceKey string, req VIPRequest) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pool, ok := p.pools[req.Pool]
+	if !ok {
+		return "", fmt.Errorf("unknown VIP pool %q", req.Pool)
+	}
+	candidates := pool
+	if req.IP != "" {
+		if !slices.Contains(pool, req.IP) {
+			return "", fmt.Errorf("VIP %s is not in pool %q", req.IP, req.Pool)
+		}
+		if owner := p.used[req.IP]; owner != "" && owner != serviceKey {
+			return "", fmt.Errorf("VIP %s is assigned to %s", req.IP, owner)
+		}
+		candidates = []string{req.IP}
 	}
-	for _, ip := range p.pool {
+	if vip, ok := p.assign[serviceKey]; ok && slices.Contains(candidates, vip) {
+		p.assignPool[serviceKey] = req.Pool
+		return vip, nil
+	}
+	for _, ip := range candidates {
 		if p.used[ip] == "" {
+			p.release(serviceKey)
 			p.used[ip] = serviceKey
 			p.assign[serviceKey] = ip
-			return ip
+			p.assignPool[serviceKey] = req.Pool
+			return ip, nil
 		}
 	}
-	return ""
+	return "", ErrNoVIPAvailable
 }
 
 func (p *poolAllocator) Release(serviceKey string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.release(serviceKey)
+}
+
+func (p *poolAllocator) release(serviceKey string) {
 	vip := p.assign[serviceKey]
 	delete(p.assign, serviceKey)
+	delete(p.assignPool, serviceKey)
 	if vip != "" {
 		delete(p.used, vip)
 	}
 }
 
 func (p *poolAllocator) GetVIP(serviceKey string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.assign[serviceKey]
 }
+
+func (p *poolAllocator) SetPools(pools map[string][]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	inUse := make(map[string]string)
+	for key, vip := range p.assign {
+		if !slices.Contains(pools[p.assignPool[key]], vip) {
+			inUse[vip] = key
+		}
+	}
+	if len(inUse) > 0 {
+		return &VIPsInUseError{InUse: inUse}
+	}
+	p.pools = pools
+	return nil
+}
+
+// Usage returns the pools sorted by name.
+func (p *poolAllocator) Usage() []PoolUsage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]PoolUsage, 0, len(p.pools))
+	for name, ips := range p.pools {
+		u := PoolUsage{Pool: name}
+		for _, ip := range ips {
+			if p.used[ip] != "" {
+				u.Allocated++
+			} else {
+				u.Free++
+			}
+		}
+		out = append(out, u)
+	}
+	slices.SortFunc(out, func(a, b PoolUsage) int { return strings.Compare(a.Pool, b.Pool) })
+	return out
+}
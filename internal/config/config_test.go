@@ -0,0 +1,45 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPoolAllocator_SetPools(t *testing.T) {
+	alloc := NewVIPAllocator(&Config{VIPPool: []string{"192.0.2.1", "192.0.2.2"}, VIPPools: map[string][]string{"dmz": {"192.0.2.10"}}})
+	if vip, err := alloc.Allocate("default/web", VIPRequest{}); err != nil || vip != "192.0.2.1" {
+		t.Fatalf("Allocate = %q, %v", vip, err)
+	}
+	if vip, err := alloc.Allocate("default/dmz", VIPRequest{Pool: "dmz"}); err != nil || vip != "192.0.2.10" {
+		t.Fatalf("Allocate dmz = %q, %v", vip, err)
+	}
+
+	// Moving an assigned VIP to another pool drops it from the pool it was allocated from.
+	err := alloc.SetPools(map[string][]string{"": {"192.0.2.2"}, "dmz": {"192.0.2.10", "192.0.2.1"}})
+	var inUse *VIPsInUseError
+	if !errors.As(err, &inUse) || !reflect.DeepEqual(inUse.InUse, map[string]string{"192.0.2.1": "default/web"}) {
+		t.Fatalf("SetPools = %v, want 192.0.2.1 in use by default/web", err)
+	}
+	if _, err := alloc.Allocate("default/new", VIPRequest{Pool: "internal"}); err == nil {
+		t.Fatal("refused SetPools must leave the pools unchanged")
+	}
+
+	if err := alloc.SetPools(map[string][]string{"": {"192.0.2.1"}, "dmz": {"192.0.2.10"}, "internal": {"10.0.0.5"}}); err != nil {
+		t.Fatalf("SetPools: %v", err)
+	}
+	if vip := alloc.GetVIP("default/web"); vip != "192.0.2.1" {
+		t.Errorf("GetVIP after SetPools = %q, want the VIP kept", vip)
+	}
+	if vip, err := alloc.Allocate("default/new", VIPRequest{Pool: "internal"}); err != nil || vip != "10.0.0.5" {
+		t.Errorf("Allocate from added pool = %q, %v", vip, err)
+	}
+	want := []PoolUsage{{Pool: "", Allocated: 1}, {Pool: "dmz", Allocated: 1}, {Pool: "internal", Allocated: 1}}
+	if got := alloc.Usage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage = %+v, want %+v", got, want)
+	}
+
+	if err := NewVIPAllocator(&Config{SingleVIP: "192.0.2.1"}).SetPools(map[string][]string{"": {"192.0.2.2"}}); err == nil {
+		t.Error("SetPools on a single VIP must fail")
+	}
+}
@@ -0,0 +1,426 @@
+package config
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// APIVersion is the apiVersion of the config file format this release reads.
+const APIVersion = "opnsense-lb-controller/v1"
+
+// setting is one configuration value, stored in the Config field named field. It is set by the
+// environment variable env, the flag --flag and the config file key, a dotted path such as
+// "vip.pool"; set parses the raw value. Lists are comma-separated, except commands, which are a
+// JSON array or split on spaces. Reloadable settings can change while the controller runs (see Changed).
+type setting struct {
+	key, field, flag, env, usage string
+	boolean, reloadable          bool
+	set                          func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{key: "loadBalancerClass", field: "LoadBalancerClass", flag: "load-balancer-class", env: "LOAD_BALANCER_CLASS",
+		usage: "spec.loadBalancerClass of the Services to manage",
+		set:   stringVar(func(c *Config) *string { return &c.LoadBalancerClass })},
+	{key: "opnsense.url", field: "OPNsenseURL", flag: "opnsense-url", env: "OPNSENSE_URL",
+		usage: "OPNsense API base URL, e.g. https://firewall.example.com; default: the url from the credentials source",
+		set:   stringVar(func(c *Config) *string { return &c.OPNsenseURL })},
+	{key: "opnsense.secretName", field: "OPNsenseSecretName", flag: "opnsense-secret-name", env: "OPNSENSE_SECRET_NAME",
+		usage: "Secret with the OPNsense API credentials, for the secret credentials source",
+		set:   stringVar(func(c *Config) *string { return &c.OPNsenseSecretName })},
+	{key: "opnsense.secretNamespace", field: "OPNsenseSecretNamespace", flag: "opnsense-secret-namespace", env: "OPNSENSE_SECRET_NAMESPACE",
+		usage: "Namespace of the OPNsense Secret",
+		set:   stringVar(func(c *Config) *string { return &c.OPNsenseSecretNamespace })},
+	{key: "opnsense.credentials.source", field: "CredentialsSource", flag: "opnsense-credentials-source", env: "OPNSENSE_CREDENTIALS_SOURCE",
+		usage: "Where the API credentials come from: env, secret, file or exec (default: secret when a Secret name is set, otherwise env)",
+		set:   stringVar(func(c *Config) *string { return &c.CredentialsSource })},
+	{key: "opnsense.credentials.file", field: "CredentialsFile", flag: "opnsense-credentials-file", env: "OPNSENSE_CREDENTIALS_FILE",
+		usage: "Credentials directory or JSON file, for the file credentials source",
+		set:   stringVar(func(c *Config) *string { return &c.CredentialsFile })},
+	{key: "opnsense.credentials.command", field: "CredentialsCommand", flag: "opnsense-credentials-command", env: "OPNSENSE_CREDENTIALS_COMMAND",
+		usage: "Command and arguments printing JSON credentials, for the exec credentials source: a JSON array, or words split on spaces",
+		set: func(c *Config, v string) error {
+			if !strings.HasPrefix(strings.TrimSpace(v), "[") {
+				c.CredentialsCommand = strings.Fields(v)
+				return nil
+			}
+			var argv []string
+			if err := json.Unmarshal([]byte(v), &argv); err != nil {
+				return fmt.Errorf("not a JSON array of strings: %w", err)
+			}
+			c.CredentialsCommand = argv
+			return nil
+		}},
+	{key: "defaultInterface", field: "DefaultInterface", flag: "default-interface", env: "DEFAULT_INTERFACE", reloadable: true,
+		usage: "OPNsense interface of Services without the opnsense.org/interface annotation",
+		set:   stringVar(func(c *Config) *string { return &c.DefaultInterface })},
+	{key: "vip.single", field: "SingleVIP", flag: "vip", env: "VIP",
+		usage: "Single VIP shared by all Services",
+		set:   stringVar(func(c *Config) *string { return &c.SingleVIP })},
+	{key: "vip.pool", field: "VIPPool", flag: "vip-pool", env: "VIP_POOL", reloadable: true,
+		usage: "Comma-separated VIPs allocated one per Service",
+		set:   listVar(func(c *Config) *[]string { return &c.VIPPool })},
+	{key: "vip.pools", field: "VIPPools", flag: "vip-pools", env: "VIP_POOLS", reloadable: true,
+		usage: "Named VIP pools, e.g. dmz=192.0.2.10,192.0.2.11;internal=10.0.0.5",
+		set: func(c *Config, v string) error {
+			pools, err := parsePools(v)
+			c.VIPPools = pools
+			return err
+		}},
+	{key: "watchNamespaces", field: "WatchNamespaces", flag: "watch-namespaces", env: "WATCH_NAMESPACES",
+		usage: "Comma-separated namespaces whose Services are managed; empty for all",
+		set:   listVar(func(c *Config) *[]string { return &c.WatchNamespaces })},
+	{key: "clusterID", field: "ClusterID", flag: "cluster-id", env: "CLUSTER_ID",
+		usage: "Distinct ID of this cluster when several clusters share one firewall",
+		set:   stringVar(func(c *Config) *string { return &c.ClusterID })},
+	{key: "adoptUnclustered", field: "AdoptUnclustered", flag: "adopt-unclustered", env: "ADOPT_UNCLUSTERED", boolean: true,
+		usage: "Take over this controller's OPNsense objects tagged without a cluster ID; requires clusterID",
+		set:   boolVar(func(c *Config) *bool { return &c.AdoptUnclustered })},
+	{key: "leaderElection.namespace", field: "LeaseNamespace", flag: "lease-namespace", env: "LEASE_NAMESPACE",
+		usage: "Namespace of the leader election Lease",
+		set:   stringVar(func(c *Config) *string { return &c.LeaseNamespace })},
+	{key: "leaderElection.name", field: "LeaseName", flag: "lease-name", env: "LEASE_NAME",
+		usage: "Name of the leader election Lease",
+		set:   stringVar(func(c *Config) *string { return &c.LeaseName })},
+	{key: "gc.interval", field: "GCInterval", flag: "gc-interval", env: "GC_INTERVAL",
+		usage: "How often orphaned OPNsense objects are garbage-collected; 0 disables it",
+		set:   durationVar(func(c *Config) *time.Duration { return &c.GCInterval })},
+	{key: "gc.gracePeriod", field: "GCGracePeriod", flag: "gc-grace-period", env: "GC_GRACE_PERIOD",
+		usage: "How long an object must stay orphaned before it is removed",
+		set:   durationVar(func(c *Config) *time.Duration { return &c.GCGracePeriod })},
+	{key: "gc.dryRun", field: "GCDryRun", flag: "gc-dry-run", env: "GC_DRY_RUN", boolean: true,
+		usage: "Only report orphaned objects instead of removing them",
+		set:   boolVar(func(c *Config) *bool { return &c.GCDryRun })},
+	{key: "drift.checkInterval", field: "DriftCheckInterval", flag: "drift-check-interval", env: "DRIFT_CHECK_INTERVAL",
+		usage: "How often synced Services are compared with OPNsense; 0 disables it",
+		set:   durationVar(func(c *Config) *time.Duration { return &c.DriftCheckInterval })},
+	{key: "drift.policy", field: "DriftPolicy", flag: "drift-policy", env: "DRIFT_POLICY",
+		usage: "repair re-applies drifted rules; report only reports them",
+		set:   stringVar(func(c *Config) *string { return &c.DriftPolicy })},
+	{key: "passRules.log", field: "PassRuleLog", flag: "pass-rule-log", env: "PASS_RULE_LOG", boolean: true,
+		usage: "Log the traffic matched by the controller's filter pass rules",
+		set:   boolVar(func(c *Config) *bool { return &c.PassRuleLog })},
+	{key: "passRules.sequence", field: "PassRuleSequence", flag: "pass-rule-sequence", env: "PASS_RULE_SEQUENCE",
+		usage: "Position of the filter pass rules among all filter rules; 0 appends them",
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%q is not an integer", v)
+			}
+			c.PassRuleSequence = n
+			return nil
+		}},
+	{key: "firewallProbeInterval", field: "FirewallProbeInterval", flag: "firewall-probe-interval", env: "FIREWALL_PROBE_INTERVAL",
+		usage: "How often the OPNsense API is probed for the readiness check; 0 disables the check",
+		set:   durationVar(func(c *Config) *time.Duration { return &c.FirewallProbeInterval })},
+	{key: "tracing.exporter", field: "TracesExporter", flag: "traces-exporter", env: "OTEL_TRACES_EXPORTER",
+		usage: "otlp exports traces over OTLP/gRPC; none disables tracing",
+		set:   stringVar(func(c *Config) *string { return &c.TracesExporter })},
+	{key: "paused", field: "Paused", flag: "paused", env: "PAUSED", boolean: true, reloadable: true,
+		usage: "Pause reconciliation: report the changes Services need on OPNsense without making any",
+		set:   boolVar(func(c *Config) *bool { return &c.Paused })},
+	{key: "dryRun", field: "DryRun", flag: "dry-run", env: "DRY_RUN", boolean: true,
+		usage: "Plan the changes on OPNsense without making any; see /debug/plan on the metrics endpoint (--metrics-bind-address)",
+		set:   boolVar(func(c *Config) *bool { return &c.DryRun })},
+	{key: "logLevel", field: "LogLevel", flag: "log-level", env: "LOG_LEVEL", reloadable: true,
+		usage: "Log verbosity: debug, info or error",
+		set:   stringVar(func(c *Config) *string { return &c.LogLevel })},
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func listVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = splitList(v)
+		return nil
+	}
+}
+
+func durationVar(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// splitList splits v on commas, trimming spaces and dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for s := range strings.SplitSeq(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// parsePools parses "<name>=<ip>,<ip>;<name>=<ip>". Empty entries are skipped; the pools of
+// valid entries are returned along with the error for invalid ones.
+func parsePools(v string) (map[string][]string, error) {
+	pools := make(map[string][]string)
+	var errs []error
+	for entry := range strings.SplitSeq(v, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, ips, ok := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			errs = append(errs, fmt.Errorf("%q is not <name>=<ip>,<ip>", strings.TrimSpace(entry)))
+			continue
+		}
+		pools[name] = append(pools[name], splitList(ips)...)
+	}
+	return pools, errors.Join(errs...)
+}
+
+// Flags are the command-line flags of every setting, plus --config for the config file and
+// --config-map for a ConfigMap holding it.
+type Flags struct {
+	configFile string
+	configMap  string
+	values     map[string]string
+}
+
+// ConfigFile returns the config file given with --config or CONFIG_FILE, or "".
+func (f *Flags) ConfigFile() string {
+	return cmp.Or(f.configFile, os.Getenv("CONFIG_FILE"))
+}
+
+// ConfigMap returns the <namespace>/<name> of the ConfigMap given with --config-map or
+// CONFIG_MAP, or "".
+func (f *Flags) ConfigMap() string {
+	return cmp.Or(f.configMap, os.Getenv("CONFIG_MAP"))
+}
+
+// RegisterFlags adds the flags to fs. Call Load after fs.Parse.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+	fs.StringVar(&f.configFile, "config", "",
+		"YAML config file with apiVersion "+APIVersion+" (env CONFIG_FILE)")
+	fs.StringVar(&f.configMap, "config-map", "",
+		"ConfigMap <namespace>/<name> whose config.yaml key is the config file, instead of --config (env CONFIG_MAP)")
+	for _, s := range settings {
+		record := func(v string) error {
+			f.values[s.flag] = v
+			return nil
+		}
+		usage := s.usage + " (env " + s.env + ")"
+		if s.boolean {
+			fs.BoolFunc(s.flag, usage, record)
+		} else {
+			fs.Func(s.flag, usage, record)
+		}
+	}
+	return f
+}
+
+// Load builds the Config from, in increasing precedence: Default, the environment, the config
+// file (--config or CONFIG_FILE) and the flags given on the command line. Empty environment
+// variables count as unset. Every value that cannot be parsed and every problem Validate finds
+// is reported in one error.
+func (f *Flags) Load() (*Config, error) {
+	return load(os.Getenv, os.ReadFile, f.ConfigFile(), f.values)
+}
+
+// LoadData is Load with data as the content of the config file, e.g. after it changed or when
+// it comes from a ConfigMap. name identifies the file in errors.
+func (f *Flags) LoadData(name string, data []byte) (*Config, error) {
+	readFile := func(string) ([]byte, error) { return data, nil }
+	return load(os.Getenv, readFile, name, f.values)
+}
+
+// Changed returns the keys of the settings whose values differ between c and next, split into
+// those that can be applied while the controller runs and those that need a restart.
+func Changed(c, next *Config) (reloadable, restart []string) {
+	cur, nxt := reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem()
+	for _, s := range settings {
+		if reflect.DeepEqual(cur.FieldByName(s.field).Interface(), nxt.FieldByName(s.field).Interface()) {
+			continue
+		}
+		if s.reloadable {
+			reloadable = append(reloadable, s.key)
+		} else {
+			restart = append(restart, s.key)
+		}
+	}
+	if c.APIKey != next.APIKey || c.APISecret != next.APISecret {
+		restart = append(restart, "OPNSENSE_API_KEY")
+	}
+	return reloadable, restart
+}
+
+func load(getenv func(string) string, readFile func(string) ([]byte, error), configFile string, flags map[string]string) (*Config, error) {
+	c := Default()
+	var errs []error
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(c, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	c.APIKey = getenv("OPNSENSE_API_KEY")
+	c.APISecret = getenv("OPNSENSE_API_SECRET")
+
+	if configFile == "" {
+		configFile = getenv("CONFIG_FILE")
+	}
+	if configFile != "" {
+		values, err := readConfigFile(readFile, configFile)
+		errs = append(errs, err)
+		for _, s := range settings {
+			v, ok := values[s.key]
+			if !ok {
+				continue
+			}
+			if err := s.set(c, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", configFile, s.key, err))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		v, ok := flags[s.flag]
+		if !ok {
+			continue
+		}
+		if err := s.set(c, v); err != nil {
+			errs = append(errs, fmt.Errorf("--%s: %w", s.flag, err))
+		}
+	}
+
+	if c.CredentialsSource == "" {
+		c.CredentialsSource = "env"
+		if c.OPNsenseSecretName != "" {
+			c.CredentialsSource = "secret"
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, errors.Join(err, c.Validate())
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readConfigFile reads the YAML config file at path into raw setting values by key, in the form
+// the environment and flags use: lists are joined with commas (spaces for commands) and pools
+// with semicolons. Unknown keys and a missing or wrong apiVersion are errors.
+func readConfigFile(readFile func(string) ([]byte, error), path string) (map[string]string, error) {
+	b, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var errs []error
+	if v := doc["apiVersion"]; v != APIVersion {
+		errs = append(errs, fmt.Errorf("%s: apiVersion must be %q, got %v", path, APIVersion, v))
+	}
+	delete(doc, "apiVersion")
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.key] = true
+	}
+	values := make(map[string]string)
+	var walk func(prefix string, m map[string]any)
+	walk = func(prefix string, m map[string]any) {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			key, v := prefix+k, m[k]
+			if known[key] {
+				s, err := fileValue(key, v)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+				}
+				values[key] = s
+				continue
+			}
+			sub, ok := v.(map[string]any)
+			if !ok || !slices.ContainsFunc(settings, func(s setting) bool { return strings.HasPrefix(s.key, key+".") }) {
+				errs = append(errs, fmt.Errorf("%s: %s: unknown setting", path, key))
+				continue
+			}
+			walk(key+".", sub)
+		}
+	}
+	walk("", doc)
+	return values, errors.Join(errs...)
+}
+
+// fileValue converts a YAML value of the setting key to its raw string form.
+func fileValue(key string, v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := fileValue(key, item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		if key == "opnsense.credentials.command" {
+			// Kept as an argv, so arguments may hold spaces.
+			b, err := json.Marshal(items)
+			return string(b), err
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		if key != "vip.pools" {
+			break
+		}
+		var entries []string
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			ips, err := fileValue("", v[name])
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, name+"="+ips)
+		}
+		return strings.Join(entries, ";"), nil
+	}
+	return "", fmt.Errorf("unexpected value %v", v)
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad_Precedence(t *testing.T) {
+	env := map[string]string{
+		"OPNSENSE_URL":        "https://env.example.com",
+		"OPNSENSE_API_KEY":    "key",
+		"OPNSENSE_API_SECRET": "secret",
+		"VIP_POOL":            "192.0.2.1,192.0.2.2",
+		"GC_INTERVAL":         "1m",
+		"DRIFT_POLICY":        "report",
+		"CLUSTER_ID":          "env",
+	}
+	file := `apiVersion: opnsense-lb-controller/v1
+opnsense:
+  url: https://file.example.com
+vip:
+  pools:
+    dmz: [192.0.2.10, 192.0.2.11]
+gc:
+  interval: 2m
+  dryRun: true
+clusterID: file
+`
+	readFile := func(path string) ([]byte, error) {
+		if path != "config.yaml" {
+			return nil, os.ErrNotExist
+		}
+		return []byte(file), nil
+	}
+	c, err := load(func(k string) string { return env[k] }, readFile, "config.yaml",
+		map[string]string{"cluster-id": "flag", "pass-rule-log": "true"})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if c.OPNsenseURL != "https://file.example.com" || c.GCInterval != 2*time.Minute || c.ClusterID != "flag" {
+		t.Errorf("file and flags must override the environment: url=%q gc.interval=%s clusterID=%q",
+			c.OPNsenseURL, c.GCInterval, c.ClusterID)
+	}
+	if c.DriftPolicy != "report" || !reflect.DeepEqual(c.VIPPool, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("environment must fill settings unset elsewhere: drift.policy=%q vip.pool=%v", c.DriftPolicy, c.VIPPool)
+	}
+	if !c.GCDryRun || !c.PassRuleLog || !reflect.DeepEqual(c.VIPPools, map[string][]string{"dmz": {"192.0.2.10", "192.0.2.11"}}) {
+		t.Errorf("gc.dryRun=%v passRules.log=%v vip.pools=%v", c.GCDryRun, c.PassRuleLog, c.VIPPools)
+	}
+	if c.CredentialsSource != "env" || c.APIKey != "key" || c.LeaseName != "opnsense-lb-controller" {
+		t.Errorf("defaults: credentials source=%q apiKey=%q lease=%q", c.CredentialsSource, c.APIKey, c.LeaseName)
+	}
+}
+
+func TestLoad_ReportsEveryError(t *testing.T) {
+	env := map[string]string{
+		"OPNSENSE_URL":         "firewall.example.com",
+		"OPNSENSE_SECRET_NAME": "opnsense",
+		"VIP_POOL":             "192.0.2.1,192.0.2.300",
+		"VIP_POOLS":            "dmz=192.0.2.1",
+		"DEFAULT_INTERFACE":    "WAN 1",
+		"GC_INTERVAL":          "soon",
+		"ADOPT_UNCLUSTERED":    "true",
+	}
+	file := `apiVersion: opnsense-lb-controller/v2
+drift:
+  policy: repair
+  interval: 5m
+`
+	_, err := load(func(k string) string { return env[k] }, func(string) ([]byte, error) { return []byte(file), nil },
+		"config.yaml", map[string]string{"pass-rule-sequence": "first"})
+	if err == nil {
+		t.Fatal("load: got nil error")
+	}
+	for _, want := range []string{
+		`GC_INTERVAL: "soon" is not a duration`,
+		`apiVersion must be "opnsense-lb-controller/v1"`,
+		"drift.interval: unknown setting",
+		`--pass-rule-sequence: "first" is not an integer`,
+		`opnsense.url (--opnsense-url, OPNSENSE_URL): "firewall.example.com" is not an http(s) URL`,
+		`defaultInterface (--default-interface, DEFAULT_INTERFACE): "WAN 1" is not an OPNsense interface name`,
+		`vip.pool (--vip-pool, VIP_POOL): "192.0.2.300" is not an IPv4 address`,
+		`192.0.2.1 is in both the default pool and pool "dmz"`,
+		"adoptUnclustered (--adopt-unclustered, ADOPT_UNCLUSTERED): requires clusterID",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("load error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoad_CredentialsCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name, env, file string
+		want            []string
+	}{
+		{name: "words", env: "vault-creds --path secret/opnsense", want: []string{"vault-creds", "--path", "secret/opnsense"}},
+		{name: "JSON array", env: `["sh", "-c", "vault read -format=json 'secret/opnsense lb'"]`,
+			want: []string{"sh", "-c", "vault read -format=json 'secret/opnsense lb'"}},
+		{name: "YAML list", file: "opnsense:\n  credentials:\n    command: [sh, -c, \"echo '{}'\"]\n",
+			want: []string{"sh", "-c", "echo '{}'"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := map[string]string{"OPNSENSE_URL": "https://fw", "OPNSENSE_CREDENTIALS_SOURCE": "exec",
+				"OPNSENSE_CREDENTIALS_COMMAND": tc.env, "VIP": "192.0.2.1"}
+			c, err := load(func(k string) string { return env[k] },
+				func(string) ([]byte, error) { return []byte("apiVersion: opnsense-lb-controller/v1\n" + tc.file), nil },
+				"config.yaml", nil)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if !reflect.DeepEqual(c.CredentialsCommand, tc.want) {
+				t.Errorf("opnsense.credentials.command: got %q, want %q", c.CredentialsCommand, tc.want)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	c := Default()
+	next := Default()
+	next.VIPPools = map[string][]string{"dmz": {"192.0.2.10"}}
+	next.LogLevel = "debug"
+	next.ClusterID = "prod"
+	next.APISecret = "rotated"
+	reloadable, restart := Changed(c, next)
+	if want := []string{"vip.pools", "logLevel"}; !reflect.DeepEqual(reloadable, want) {
+		t.Errorf("reloadable = %v, want %v", reloadable, want)
+	}
+	if want := []string{"clusterID", "OPNSENSE_API_KEY"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("restart = %v, want %v", restart, want)
+	}
+}
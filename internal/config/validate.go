@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// clusterID matches cluster IDs that fit in the space-separated ownership tag of OPNsense
+// object descriptions.
+var clusterID = regexp.MustCompile(`^[A-Za-z0-9._-]{0,63}$`)
+
+// poolName matches the names of VIPPools, which Services reference in an annotation.
+var poolName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// Validate checks c and reports every invalid setting in one error, one line each. Settings
+// are named by their config file key, flag and environment variable.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", settingName(key), fmt.Sprintf(format, args...)))
+	}
+
+	if c.LoadBalancerClass == "" {
+		invalid("loadBalancerClass", "must not be empty")
+	}
+	if c.OPNsenseURL != "" {
+		if u, err := url.Parse(c.OPNsenseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("opnsense.url", "%q is not an http(s) URL", c.OPNsenseURL)
+		}
+	}
+	switch c.CredentialsSource {
+	case "env":
+		if c.OPNsenseURL == "" {
+			invalid("opnsense.url", "is required with the env credentials source")
+		}
+		if c.APIKey == "" || c.APISecret == "" {
+			errs = append(errs, errors.New("OPNSENSE_API_KEY and OPNSENSE_API_SECRET are required with the env credentials source"))
+		}
+	case "secret":
+		if c.OPNsenseSecretName == "" {
+			invalid("opnsense.secretName", "is required with the secret credentials source")
+		}
+		if c.OPNsenseSecretNamespace == "" {
+			invalid("opnsense.secretNamespace", "is required with the secret credentials source")
+		}
+	case "file":
+		if c.CredentialsFile == "" {
+			invalid("opnsense.credentials.file", "is required with the file credentials source")
+		}
+	case "exec":
+		if len(c.CredentialsCommand) == 0 {
+			invalid("opnsense.credentials.command", "is required with the exec credentials source")
+		}
+	default:
+		invalid("opnsense.credentials.source", "%q must be env, secret, file or exec", c.CredentialsSource)
+	}
+	if !opnsense.IsInterfaceName(c.DefaultInterface) {
+		invalid("defaultInterface", "%q is not an OPNsense interface name", c.DefaultInterface)
+	}
+
+	errs = append(errs, c.validateVIPs()...)
+
+	for _, ns := range c.WatchNamespaces {
+		if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+			invalid("watchNamespaces", "%q is not a namespace name", ns)
+		}
+	}
+	if !clusterID.MatchString(c.ClusterID) {
+		invalid("clusterID", "%q must be at most 63 letters, digits, '.', '_' or '-'", c.ClusterID)
+	}
+	if c.AdoptUnclustered && c.ClusterID == "" {
+		invalid("adoptUnclustered", "requires clusterID")
+	}
+	if msgs := validation.IsDNS1123Label(c.LeaseNamespace); len(msgs) > 0 {
+		invalid("leaderElection.namespace", "%q is not a namespace name", c.LeaseNamespace)
+	}
+	if msgs := validation.IsDNS1123Subdomain(c.LeaseName); len(msgs) > 0 {
+		invalid("leaderElection.name", "%q is not a Lease name", c.LeaseName)
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"gc.interval", c.GCInterval},
+		{"gc.gracePeriod", c.GCGracePeriod},
+		{"drift.checkInterval", c.DriftCheckInterval},
+		{"firewallProbeInterval", c.FirewallProbeInterval},
+	} {
+		if d.value < 0 {
+			invalid(d.key, "%s must not be negative", d.value)
+		}
+	}
+	if c.DriftPolicy != "repair" && c.DriftPolicy != "report" {
+		invalid("drift.policy", "%q must be repair or report", c.DriftPolicy)
+	}
+	if c.PassRuleSequence < 0 {
+		invalid("passRules.sequence", "%d must not be negative", c.PassRuleSequence)
+	}
+	if c.TracesExporter != "none" && c.TracesExporter != "otlp" {
+		invalid("tracing.exporter", "%q must be none or otlp", c.TracesExporter)
+	}
+	if !slices.Contains([]string{"debug", "info", "error"}, c.LogLevel) {
+		invalid("logLevel", "%q must be debug, info or error", c.LogLevel)
+	}
+	return errors.Join(errs...)
+}
+
+// validateVIPs checks that exactly one of a single VIP or pools is configured, that every VIP
+// is an IPv4 address, and that no VIP is listed twice, within a pool or across pools.
+func (c *Config) validateVIPs() []error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", settingName(key), fmt.Sprintf(format, args...)))
+	}
+	hasPools := len(c.VIPPool) > 0 || len(c.VIPPools) > 0
+	switch {
+	case c.SingleVIP != "" && hasPools:
+		invalid("vip.single", "cannot be combined with vip.pool or vip.pools")
+	case c.SingleVIP == "" && !hasPools:
+		invalid("vip.single", "one of vip.single, vip.pool or vip.pools is required")
+	}
+	if c.SingleVIP != "" {
+		if addr, err := netip.ParseAddr(c.SingleVIP); err != nil || !addr.Is4() {
+			invalid("vip.single", "%q is not an IPv4 address", c.SingleVIP)
+		}
+	}
+
+	// owner records the pool each VIP was first seen in, "" for the default pool.
+	owner := make(map[string]string)
+	pool := func(name string) string {
+		if name == "" {
+			return "the default pool"
+		}
+		return fmt.Sprintf("pool %q", name)
+	}
+	check := func(key, name string, ips []string) {
+		for _, ip := range ips {
+			if addr, err := netip.ParseAddr(ip); err != nil || !addr.Is4() {
+				invalid(key, "%q is not an IPv4 address", ip)
+				continue
+			}
+			if first, seen := owner[ip]; seen {
+				if first == name {
+					invalid(key, "%s is listed twice in %s", ip, pool(name))
+				} else {
+					invalid(key, "%s is in both %s and %s", ip, pool(first), pool(name))
+				}
+				continue
+			}
+			owner[ip] = name
+		}
+	}
+	check("vip.pool", "", c.VIPPool)
+	for _, name := range slices.Sorted(maps.Keys(c.VIPPools)) {
+		if !poolName.MatchString(name) {
+			invalid("vip.pools", "%q is not a pool name", name)
+		}
+		if len(c.VIPPools[name]) == 0 {
+			invalid("vip.pools", "pool %q has no VIPs", name)
+		}
+		check("vip.pools", name, c.VIPPools[name])
+	}
+	return errs
+}
+
+// settingName names the setting with config file key key by its key, flag and environment variable.
+func settingName(key string) string {
+	for _, s := range settings {
+		if s.key == key {
+			return fmt.Sprintf("%s (--%s, %s)", s.key, s.flag, s.env)
+		}
+	}
+	return key
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// AnnotationForceDelete, when set to "true" on a Service, lets the controller remove its
+// finalizer even though OPNsense cleanup keeps failing. Use it only when the firewall is
+// gone for good; any rules or VIP still on it are left behind.
+const AnnotationForceDelete = "opnsense.org/force-delete"
+
+// AnnotationPaused, when set to "true" on a Service, pauses its reconciliation: the controller
+// reports the changes it would make on OPNsense but makes none, and defers cleanup when the
+// Service is deleted. See Reconciler.Paused for pausing every Service.
+const AnnotationPaused = "opnsense.org/paused"
+
+// Per-Service options. Every annotation with the opnsense.org/ prefix must be one of these (or
+// AnnotationForceDelete or AnnotationPaused); see ParseServiceOptions.
+const (
+	annotationPrefix = "opnsense.org/"
+	// AnnotationInterface is the OPNsense interface the Service's NAT rules and VIP are created
+	// on, e.g. "opt1" (default: the controller's default interface).
+	AnnotationInterface = annotationPrefix + "interface"
+	// AnnotationVIPPool selects a named VIP pool (VIP_POOLS) instead of the default pool.
+	AnnotationVIPPool = annotationPrefix + "vip-pool"
+	// AnnotationLoadBalancerIP requests a specific VIP from the pool.
+	AnnotationLoadBalancerIP = annotationPrefix + "load-balancer-ip"
+	// AnnotationBackendMode selects the NAT targets: BackendModeNodePort or BackendModePod.
+	AnnotationBackendMode = annotationPrefix + "backend-mode"
+	// AnnotationLog enables OPNsense logging for the Service's rules ("true" or "false").
+	AnnotationLog = annotationPrefix + "log"
+	// AnnotationSourceRanges is a comma-separated list of CIDRs allowed to reach the Service
+	// (default: any source).
+	AnnotationSourceRanges = annotationPrefix + "source-ranges"
+	// AnnotationAllowCountries is a comma-separated list of ISO 3166-1 alpha-2 country codes;
+	// traffic from other countries is blocked using a GeoIP alias (default: any country).
+	AnnotationAllowCountries = annotationPrefix + "allow-countries"
+	// AnnotationBlockLists is a comma-separated list of blocked sources: http(s) URLs of IP lists,
+	// collected in a URL table alias, or names of aliases already configured on OPNsense.
+	AnnotationBlockLists = annotationPrefix + "block-lists"
+	// AnnotationDescription is a note appended to the ownership tag of the Service's rules, so
+	// they are recognizable in the OPNsense UI.
+	AnnotationDescription = annotationPrefix + "description"
+)
+
+// maxDescriptionLength bounds the note so the rule description, tag included, fits OPNsense's
+// description field.
+const maxDescriptionLength = 128
+
+// BackendMode selects where a Service's NAT rules forward traffic to.
+type BackendMode string
+
+const (
+	// BackendModeNodePort forwards to each backend node's InternalIP and the Service's nodePort.
+	BackendModeNodePort BackendMode = "node-port"
+	// BackendModePod forwards to the endpoint (pod) IPs and target ports directly; the pod
+	// network must be routable from OPNsense.
+	BackendModePod BackendMode = "pod"
+)
+
+// countryCode matches ISO 3166-1 alpha-2 codes as OPNsense GeoIP aliases list them.
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// aliasName matches OPNsense alias names. Names starting with "lbc_" are reserved for the
+// aliases the controller creates (see opnsense.Owner.AliasName) and cannot be referenced.
+var aliasName = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+
+// ServiceOptions are the per-Service settings read from opnsense.org/ annotations. The zero
+// value is the behaviour of a Service without annotations.
+type ServiceOptions struct {
+	// Interface is empty for the controller's default interface (Reconciler.Interface).
+	Interface string
+	// VIP narrows VIP allocation to a pool and/or address.
+	VIP         config.VIPRequest
+	BackendMode BackendMode
+	Log         bool
+	// SourceRanges are canonical CIDRs; empty allows any source.
+	SourceRanges []string
+	// AllowCountries are sorted country codes; empty allows any country.
+	AllowCountries []string
+	// BlockURLs are the block list URLs and BlockAliases the referenced aliases, both sorted.
+	BlockURLs    []string
+	BlockAliases []string
+	Description  string
+}
+
+// ParseServiceOptions reads and validates the opnsense.org/ annotations. It reports every
+// invalid or unknown annotation in one error, so the Service can be fixed in a single edit;
+// no option is applied unless all of them are valid.
+func ParseServiceOptions(annotations map[string]string) (ServiceOptions, error) {
+	opts := ServiceOptions{BackendMode: BackendModeNodePort}
+	var problems []string
+	invalid := func(key, format string, args ...any) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, annotationPrefix) {
+			continue
+		}
+		switch key {
+		case AnnotationForceDelete:
+		case AnnotationPaused:
+			if value != "true" && value != "false" {
+				invalid(key, "%q must be true or false", value)
+			}
+		case AnnotationInterface:
+			if !opnsense.IsInterfaceName(value) {
+				invalid(key, "%q is not an OPNsense interface name", value)
+			}
+			opts.Interface = value
+		case AnnotationVIPPool:
+			if value == "" {
+				invalid(key, "must not be empty")
+			}
+			opts.VIP.Pool = value
+		case AnnotationLoadBalancerIP:
+			addr, err := netip.ParseAddr(value)
+			if err != nil || !addr.Is4() {
+				invalid(key, "%q is not an IPv4 address", value)
+			}
+			opts.VIP.IP = value
+		case AnnotationBackendMode:
+			switch mode := BackendMode(value); mode {
+			case BackendModeNodePort, BackendModePod:
+				opts.BackendMode = mode
+			default:
+				invalid(key, "%q must be %q or %q", value, BackendModeNodePort, BackendModePod)
+			}
+		case AnnotationLog:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid(key, "%q is not a boolean", value)
+			}
+			opts.Log = b
+		case AnnotationSourceRanges:
+			for s := range strings.SplitSeq(value, ",") {
+				prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+				if err != nil || !prefix.Addr().Is4() {
+					invalid(key, "%q is not an IPv4 CIDR", strings.TrimSpace(s))
+					continue
+				}
+				opts.SourceRanges = append(opts.SourceRanges, prefix.Masked().String())
+			}
+			slices.Sort(opts.SourceRanges)
+			opts.SourceRanges = slices.Compact(opts.SourceRanges)
+		case AnnotationAllowCountries:
+			for s := range strings.SplitSeq(value, ",") {
+				code := strings.ToUpper(strings.TrimSpace(s))
+				if !countryCode.MatchString(code) {
+					invalid(key, "%q is not an ISO 3166-1 alpha-2 country code", strings.TrimSpace(s))
+					continue
+				}
+				opts.AllowCountries = append(opts.AllowCountries, code)
+			}
+			slices.Sort(opts.AllowCountries)
+			opts.AllowCountries = slices.Compact(opts.AllowCountries)
+		case AnnotationBlockLists:
+			for s := range strings.SplitSeq(value, ",") {
+				s = strings.TrimSpace(s)
+				if u, err := url.Parse(s); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+					opts.BlockURLs = append(opts.BlockURLs, s)
+					continue
+				}
+				if !aliasName.MatchString(s) || strings.HasPrefix(s, "lbc_") {
+					invalid(key, "%q is neither an http(s) URL nor an OPNsense alias name", s)
+					continue
+				}
+				opts.BlockAliases = append(opts.BlockAliases, s)
+			}
+			slices.Sort(opts.BlockURLs)
+			opts.BlockURLs = slices.Compact(opts.BlockURLs)
+			slices.Sort(opts.BlockAliases)
+			opts.BlockAliases = slices.Compact(opts.BlockAliases)
+		case AnnotationDescription:
+			if len(value) > maxDescriptionLength || strings.ContainsFunc(value, unicode.IsControl) {
+				invalid(key, "must be at most %d characters without control characters", maxDescriptionLength)
+			}
+			opts.Description = value
+		default:
+			invalid(key, "unknown annotation")
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return ServiceOptions{}, fmt.Errorf("invalid annotations: %s", strings.Join(problems, "; "))
+	}
+	return opts, nil
+}
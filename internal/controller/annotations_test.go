@@ -0,0 +1,84 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+)
+
+func TestParseServiceOptions(t *testing.T) {
+	got, err := ParseServiceOptions(map[string]string{
+		AnnotationInterface:      "opt1",
+		AnnotationVIPPool:        "dmz",
+		AnnotationLoadBalancerIP: "192.0.2.10",
+		AnnotationBackendMode:    "pod",
+		AnnotationLog:            "true",
+		AnnotationSourceRanges:   "203.0.113.7/32, 198.51.100.1/24,198.51.100.0/24",
+		AnnotationAllowCountries: "de, FR,de",
+		AnnotationBlockLists:     "https://www.spamhaus.org/drop/drop.txt, spamhaus_edrop",
+		AnnotationDescription:    "Public website",
+		AnnotationForceDelete:    "true",
+		"example.com/other":      "ignored",
+	})
+	if err != nil {
+		t.Fatalf("ParseServiceOptions: %v", err)
+	}
+	want := ServiceOptions{
+		Interface:      "opt1",
+		VIP:            config.VIPRequest{Pool: "dmz", IP: "192.0.2.10"},
+		BackendMode:    BackendModePod,
+		Log:            true,
+		SourceRanges:   []string{"198.51.100.0/24", "203.0.113.7/32"},
+		AllowCountries: []string{"DE", "FR"},
+		BlockURLs:      []string{"https://www.spamhaus.org/drop/drop.txt"},
+		BlockAliases:   []string{"spamhaus_edrop"},
+		Description:    "Public website",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseServiceOptions: got %+v, want %+v", got, want)
+	}
+
+	if got, err := ParseServiceOptions(nil); err != nil || got.BackendMode != BackendModeNodePort {
+		t.Errorf("no annotations: got %+v, %v; want node-port defaults", got, err)
+	}
+
+	_, err = ParseServiceOptions(map[string]string{
+		AnnotationInterface:            "WAN!",
+		AnnotationLoadBalancerIP:       "2001:db8::1",
+		AnnotationBackendMode:          "direct",
+		AnnotationLog:                  "yes",
+		AnnotationSourceRanges:         "198.51.100.0/24,",
+		AnnotationAllowCountries:       "DEU",
+		AnnotationBlockLists:           "ftp://example.com/list.txt",
+		AnnotationDescription:          "line\nbreak",
+		"opnsense.org/loadbalancer-ip": "192.0.2.10",
+	})
+	if err == nil {
+		t.Fatal("ParseServiceOptions: expected an error for invalid annotations")
+	}
+	for _, key := range []string{AnnotationInterface, AnnotationLoadBalancerIP, AnnotationBackendMode, AnnotationLog,
+		AnnotationSourceRanges, AnnotationAllowCountries, AnnotationBlockLists, AnnotationDescription,
+		"opnsense.org/loadbalancer-ip"} {
+		if !strings.Contains(err.Error(), key+": ") {
+			t.Errorf("error %q does not report %s", err, key)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// annotationLastApplied is written by kubectl apply and often holds a full copy of the object.
+const annotationLastApplied = "kubectl.kubernetes.io/last-applied-configuration"
+
+// CacheOptions returns manager cache options that keep only the fields the controller reads.
+// Services, Endpoints and Nodes are all needed in full structure, so none can be a
+// metadata-only watch; instead each type is trimmed by a transform before it is stored.
+// When namespaces is non-empty, only Services and Endpoints in those namespaces are cached
+// (Nodes are cluster-scoped and always cached).
+func CacheOptions(namespaces []string) cache.Options {
+	opts := cache.Options{
+		DefaultTransform: cache.TransformStripManagedFields(),
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Service{}:   {Transform: transformService},
+			&corev1.Endpoints{}: {Transform: transformEndpoints}, //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+			&corev1.Node{}:      {Transform: transformNode},
+		},
+	}
+	if len(namespaces) > 0 {
+		opts.DefaultNamespaces = make(map[string]cache.Config, len(namespaces))
+		for _, ns := range namespaces {
+			opts.DefaultNamespaces[ns] = cache.Config{}
+		}
+	}
+	return opts
+}
+
+// transformService drops managedFields and kubectl's last-applied copy. Everything else,
+// including annotations, is read by the controller or needed to patch the Service.
+func transformService(in any) (any, error) {
+	svc, ok := in.(*corev1.Service)
+	if !ok {
+		return in, nil
+	}
+	svc.ManagedFields = nil
+	delete(svc.Annotations, annotationLastApplied)
+	return svc, nil
+}
+
+// transformEndpoints keeps only the IP and node name of each address; target references and
+// hostnames are never read.
+func transformEndpoints(in any) (any, error) {
+	ep, ok := in.(*corev1.Endpoints) //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if !ok {
+		return in, nil
+	}
+	ep.ManagedFields = nil
+	ep.Annotations = nil
+	for i := range ep.Subsets {
+		ep.Subsets[i].Addresses = trimEndpointAddresses(ep.Subsets[i].Addresses)
+		ep.Subsets[i].NotReadyAddresses = trimEndpointAddresses(ep.Subsets[i].NotReadyAddresses)
+	}
+	return ep, nil
+}
+
+func trimEndpointAddresses(addrs []corev1.EndpointAddress) []corev1.EndpointAddress {
+	for i := range addrs {
+		addrs[i] = corev1.EndpointAddress{IP: addrs[i].IP, NodeName: addrs[i].NodeName}
+	}
+	return addrs
+}
+
+// transformNode keeps what NodeBackendChanged and the NodePort backends read: labels, addresses
+// and the Ready condition. Images, node info, capacity, other conditions and annotations, which
+// make up most of a Node object, are dropped.
+func transformNode(in any) (any, error) {
+	node, ok := in.(*corev1.Node)
+	if !ok {
+		return in, nil
+	}
+	node.ManagedFields = nil
+	node.Annotations = nil
+	node.Spec = corev1.NodeSpec{}
+	status := corev1.NodeStatus{Addresses: node.Status.Addresses}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			status.Conditions = []corev1.NodeCondition{{Type: c.Type, Status: c.Status}}
+		}
+	}
+	node.Status = status
+	return node, nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fatNode returns a Node shaped like one from a real cluster: dozens of cached images,
+// several conditions, annotations and managedFields.
+func fatNode(i int) *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("node-%d", i),
+			Labels: map[string]string{"kubernetes.io/hostname": fmt.Sprintf("node-%d", i)},
+			Annotations: map[string]string{
+				"node.alpha.kubernetes.io/ttl":                    "0",
+				"volumes.kubernetes.io/controller-managed-attach": "true",
+				"csi.volume.kubernetes.io/nodeid":                 strings.Repeat("x", 200),
+			},
+			ManagedFields: []metav1.ManagedFieldsEntry{{
+				Manager:  "kubelet",
+				FieldsV1: &metav1.FieldsV1{Raw: []byte(strings.Repeat("{}", 2000))},
+			}},
+		},
+		Spec: corev1.NodeSpec{PodCIDR: "10.244.0.0/24", ProviderID: "aws:///eu-west-1a/i-0123456789"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: fmt.Sprintf("192.168.%d.%d", i/250, i%250)},
+				{Type: corev1.NodeHostName, Address: fmt.Sprintf("node-%d", i)},
+			},
+			Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")},
+			NodeInfo: corev1.NodeSystemInfo{KernelVersion: "6.1.0", OSImage: "Ubuntu 24.04", KubeletVersion: "v1.35.0"},
+		},
+	}
+	for _, t := range []corev1.NodeConditionType{corev1.NodeReady, corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure} {
+		node.Status.Conditions = append(node.Status.Conditions, corev1.NodeCondition{
+			Type: t, Status: corev1.ConditionFalse, Reason: "KubeletHasSufficient" + string(t), Message: "kubelet is posting ready status",
+		})
+	}
+	for j := range 50 {
+		node.Status.Images = append(node.Status.Images, corev1.ContainerImage{
+			Names:     []string{fmt.Sprintf("registry.example.com/team/image-%d@sha256:%s", j, strings.Repeat("a", 64))},
+			SizeBytes: 100 << 20,
+		})
+	}
+	return node
+}
+
+// fatEndpoints returns an Endpoints object with n addresses, each with a target reference.
+func fatEndpoints(i, n int) *corev1.Endpoints { //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	ep := &corev1.Endpoints{ //nolint:staticcheck // SA1019
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("svc-%d", i)},
+	}
+	sub := corev1.EndpointSubset{Ports: []corev1.EndpointPort{{Port: 8080, Protocol: corev1.ProtocolTCP}}} //nolint:staticcheck // SA1019
+	for j := range n {
+		nodeName := fmt.Sprintf("node-%d", j)
+		sub.Addresses = append(sub.Addresses, corev1.EndpointAddress{
+			IP: fmt.Sprintf("10.0.%d.%d", j/250, j%250), NodeName: &nodeName, Hostname: fmt.Sprintf("pod-%d", j),
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: fmt.Sprintf("svc-%d-pod-%d", i, j),
+				UID: types.UID(fmt.Sprintf("0b6c7c3e-%04d-%04d-8e0f-3f2a9c4e5d6f", i, j)), ResourceVersion: "123456"},
+		})
+	}
+	ep.Subsets = []corev1.EndpointSubset{sub} //nolint:staticcheck // SA1019
+	return ep
+}
+
+func TestCacheTransforms(t *testing.T) {
+	out, _ := transformNode(fatNode(1))
+	node := out.(*corev1.Node)
+	if len(node.Status.Images) != 0 || node.ManagedFields != nil || node.Annotations != nil {
+		t.Errorf("transformNode kept unused fields: %+v", node)
+	}
+	if ip, ok := nodeInternalIP(node); !ok || ip != "192.168.0.1" {
+		t.Errorf("transformNode dropped the InternalIP: got %q", ip)
+	}
+	if len(node.Status.Conditions) != 1 || node.Status.Conditions[0].Type != corev1.NodeReady {
+		t.Errorf("transformNode conditions: got %+v, want only Ready", node.Status.Conditions)
+	}
+
+	out, _ = transformEndpoints(fatEndpoints(1, 2))
+	ep := out.(*corev1.Endpoints) //nolint:staticcheck // SA1019
+	addr := ep.Subsets[0].Addresses[1]
+	if addr.TargetRef != nil || addr.Hostname != "" || addr.IP != "10.0.0.1" || addr.NodeName == nil || *addr.NodeName != "node-1" {
+		t.Errorf("transformEndpoints: got %+v, want only IP and NodeName", addr)
+	}
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		annotationLastApplied: "{}", AnnotationForceDelete: "true",
+	}}}
+	out, _ = transformService(svc)
+	if annotations := out.(*corev1.Service).Annotations; len(annotations) != 1 || annotations[AnnotationForceDelete] != "true" {
+		t.Errorf("transformService annotations: got %v", annotations)
+	}
+}
+
+// BenchmarkCacheMemory reports the heap retained per cached object with and without the
+// cache transforms (retained-B/obj). Run with: go test -run=^$ -bench=CacheMemory ./internal/controller
+func BenchmarkCacheMemory(b *testing.B) {
+	const objects = 500
+	cases := []struct {
+		name      string
+		build     func(i int) any
+		transform func(any) (any, error)
+	}{
+		{"Node/raw", func(i int) any { return fatNode(i) }, nil},
+		{"Node/transformed", func(i int) any { return fatNode(i) }, transformNode},
+		{"Endpoints/raw", func(i int) any { return fatEndpoints(i, 50) }, nil},
+		{"Endpoints/transformed", func(i int) any { return fatEndpoints(i, 50) }, transformEndpoints},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			var retained uint64
+			for b.Loop() {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				store := make([]any, objects)
+				for i := range store {
+					obj := tc.build(i)
+					if tc.transform != nil {
+						obj, _ = tc.transform(obj)
+					}
+					store[i] = obj
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				if after.HeapAlloc > before.HeapAlloc {
+					retained += after.HeapAlloc - before.HeapAlloc
+				}
+				runtime.KeepAlive(store)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N*objects), "retained-B/obj")
+		})
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// ForeignVIPOwners returns one message per VIP in vips that OPNsense has tagged with a
+// controller identity other than id, e.g. because another cluster sharing the firewall was
+// configured with an overlapping pool. It is meant to be logged as a warning at startup;
+// nothing is modified.
+func ForeignVIPOwners(ctx context.Context, oc opnsense.Client, id opnsense.Identity, vips []string) ([]string, error) {
+	existing, err := oc.ListVIPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, v := range existing {
+		tag, ok := opnsense.ParseVIPTag(v.Description)
+		if !ok || id.Same(tag.Identity) || !slices.Contains(vips, v.Address()) {
+			continue
+		}
+		if id.Migrates(tag.Identity) {
+			out = append(out, fmt.Sprintf("VIP %s is tagged by %s without a cluster ID; this cluster will adopt it",
+				v.Address(), tag.ManagedBy))
+			continue
+		}
+		out = append(out, fmt.Sprintf("VIP %s is owned by %s in cluster %q; its pool overlaps this cluster's",
+			v.Address(), tag.ManagedBy, tag.ClusterID))
+	}
+	return out, nil
+}
@@ -1,19 +1,38 @@
 package controller
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
 )
 
-// DesiredState holds the desired NAT state for a Service: VIP and rules.
+// DesiredState holds the desired NAT state for a Service: VIP and rules, plus the options
+// from its annotations that apply to every rule (see ServiceOptions).
 type DesiredState struct {
-	VIP   string
-	Rules []NATRule
+	VIP            string
+	Rules          []NATRule
+	Interface      string
+	SourceRanges   []string
+	AllowCountries []string
+	BlockURLs      []string
+	BlockAliases   []string
+	Log            bool
+	Description    string
 }
 
 // NATRule represents one port-forward rule (external port → backends).
+// PortName is the Service port name, empty for an unnamed port.
 type NATRule struct {
+	PortName     string
 	ExternalPort int32
 	Protocol     string
 	Backends     []Backend
@@ -25,27 +44,67 @@ type Backend struct {
 	Port int32
 }
 
-// NodeIPResolver returns the internal IP for a node by name, or false if not found.
-type NodeIPResolver func(nodeName string) (internalIP string, ok bool)
+// NodeResolver returns a Node by name, or false if not found.
+type NodeResolver func(nodeName string) (*corev1.Node, bool)
+
+// Purposes of the aliases the controller creates for a Service (see opnsense.Owner.AliasName):
+// its source ranges, allowed countries and block list URLs.
+const (
+	aliasSourceRanges = "src"
+	aliasCountries    = "geo"
+	aliasBlockLists   = "block"
+)
 
 // ComputeDesiredState builds the desired NAT state from a Service and its Endpoints.
 // vip is the virtual IP to use. For each LoadBalancer port, one NATRule is built with
-// backends from Endpoints. When getNodeIP is set, EndpointAddress.NodeName is resolved
-// to the node's internal IP for NodePort backends; otherwise addr.IP is used.
+// backends from Endpoints. When getNode is set, EndpointAddress.NodeName is resolved
+// to the node's internal IP for NodePort backends, and addresses on Nodes that must not serve
+// load balancer traffic (see nodeServesLoadBalancer) are skipped; otherwise addr.IP is used.
+// With BackendModePod in opts, backends are the endpoint IPs and the port's target port.
+// SourceRanges are spec.loadBalancerSourceRanges or, when those are unset, the ranges from opts.
 // Nil or empty Endpoints yield rules with empty Backends.
-func ComputeDesiredState(vip string, svc *corev1.Service, endpoints *corev1.Endpoints, nodePort int32, getNodeIP NodeIPResolver) (*DesiredState, error) { //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+func ComputeDesiredState(vip string, svc *corev1.Service, endpoints *corev1.Endpoints, nodePort int32, getNode NodeResolver, opts ServiceOptions) (*DesiredState, error) { //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
 	if svc == nil {
 		return nil, nil
 	}
-	state := &DesiredState{VIP: vip}
+	sourceRanges, err := serviceSourceRanges(svc, opts)
+	if err != nil {
+		return nil, err
+	}
+	state := &DesiredState{
+		VIP:            vip,
+		Interface:      cmp.Or(opts.Interface, opnsense.DefaultInterface),
+		SourceRanges:   sourceRanges,
+		AllowCountries: opts.AllowCountries,
+		BlockURLs:      opts.BlockURLs,
+		BlockAliases:   opts.BlockAliases,
+		Log:            opts.Log,
+		Description:    opts.Description,
+	}
+	if opts.BackendMode == BackendModePod {
+		for _, p := range svc.Spec.Ports {
+			state.Rules = append(state.Rules, NATRule{
+				PortName:     p.Name,
+				ExternalPort: p.Port,
+				Protocol:     string(p.Protocol),
+				Backends:     podBackends(endpoints, p.Name),
+			})
+		}
+		return state, nil
+	}
 	var backendIPs []string
 	if endpoints != nil {
 		for _, sub := range endpoints.Subsets {
 			for _, addr := range sub.Addresses {
 				ip := addr.IP
-				if addr.NodeName != nil && getNodeIP != nil {
-					if nodeIP, ok := getNodeIP(*addr.NodeName); ok {
-						ip = nodeIP
+				if addr.NodeName != nil && getNode != nil {
+					if node, ok := getNode(*addr.NodeName); ok {
+						if !nodeServesLoadBalancer(node) {
+							continue
+						}
+						if nodeIP, ok := nodeInternalIP(node); ok {
+							ip = nodeIP
+						}
 					}
 				}
 				if ip != "" {
@@ -64,6 +123,7 @@ func ComputeDesiredState(vip string, svc *corev1.Service, endpoints *corev1.Endp
 			backends = append(backends, Backend{IP: ip, Port: np})
 		}
 		state.Rules = append(state.Rules, NATRule{
+			PortName:     p.Name,
 			ExternalPort: p.Port,
 			Protocol:     string(p.Protocol),
 			Backends:     backends,
@@ -72,15 +132,66 @@ func ComputeDesiredState(vip string, svc *corev1.Service, endpoints *corev1.Endp
 	return state, nil
 }
 
-// desiredStateToOPNsenseRules converts controller desired state to one opnsense.NATRule per backend.
-// Description includes managedBy and serviceKey so rules are scoped per service.
-func desiredStateToOPNsenseRules(state *DesiredState, managedBy, serviceKey string) []opnsense.NATRule {
+// nodeServesLoadBalancer reports whether node may be a NodePort backend: it is not labeled with
+// LabelExcludeFromExternalLB and its Ready condition, if reported, is True. Cordoned Nodes keep
+// serving, as with Kubernetes' own service controller, so draining every Node does not take the
+// Service down.
+func nodeServesLoadBalancer(node *corev1.Node) bool {
+	ready := nodeReady(node)
+	return !hasLabel(node, LabelExcludeFromExternalLB) && (ready == "" || ready == corev1.ConditionTrue)
+}
+
+// podBackends returns the ready endpoint addresses of the Service port named portName, with the
+// target port Endpoints lists for it.
+func podBackends(endpoints *corev1.Endpoints, portName string) []Backend { //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	backends := make([]Backend, 0)
+	if endpoints == nil {
+		return backends
+	}
+	for _, sub := range endpoints.Subsets {
+		i := slices.IndexFunc(sub.Ports, func(p corev1.EndpointPort) bool { return p.Name == portName }) //nolint:staticcheck // SA1019
+		if i < 0 {
+			continue
+		}
+		for _, addr := range sub.Addresses {
+			backends = append(backends, Backend{IP: addr.IP, Port: sub.Ports[i].Port})
+		}
+	}
+	return backends
+}
+
+// serviceSourceRanges returns the sorted, canonical source ranges of svc: its
+// spec.loadBalancerSourceRanges, or the AnnotationSourceRanges ranges in opts when unset.
+func serviceSourceRanges(svc *corev1.Service, opts ServiceOptions) ([]string, error) {
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 {
+		return opts.SourceRanges, nil
+	}
+	ranges := make([]string, 0, len(svc.Spec.LoadBalancerSourceRanges))
+	for _, s := range svc.Spec.LoadBalancerSourceRanges {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil || !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("spec.loadBalancerSourceRanges: %q is not an IPv4 CIDR", s)
+		}
+		ranges = append(ranges, prefix.Masked().String())
+	}
+	slices.Sort(ranges)
+	return slices.Compact(ranges), nil
+}
+
+// desiredStateToOPNsenseRules converts controller desired state to one opnsense.NATRule per
+// backend. Descriptions are left to the client, which derives them from the rule's
+// opnsense.Owner and the state's Description note. Rules never pass traffic themselves:
+// desiredFilterRules does. With source ranges, rules match the owner's source range alias.
+func desiredStateToOPNsenseRules(state *DesiredState, owner opnsense.Owner) []opnsense.NATRule {
 	var capacity int
 	for _, r := range state.Rules {
 		capacity += len(r.Backends)
 	}
+	var sourceNet string
+	if len(state.SourceRanges) > 0 {
+		sourceNet = owner.AliasName(aliasSourceRanges)
+	}
 	out := make([]opnsense.NATRule, 0, capacity)
-	descPrefix := managedBy + " " + serviceKey + " " + state.VIP
 	for _, r := range state.Rules {
 		for _, b := range r.Backends {
 			out = append(out, opnsense.NATRule{
@@ -88,9 +199,122 @@ func desiredStateToOPNsenseRules(state *DesiredState, managedBy, serviceKey stri
 				Protocol:     r.Protocol,
 				TargetIP:     b.IP,
 				TargetPort:   int(b.Port),
-				Description:  descPrefix,
+				PortName:     r.PortName,
+				Interface:    state.Interface,
+				SourceNet:    sourceNet,
+				Log:          state.Log,
+				Note:         state.Description,
+				NoAutoPass:   true,
 			})
 		}
 	}
 	return out
 }
+
+// desiredAliases returns the aliases the rules of state reference and the controller manages:
+// the source range, GeoIP and URL table aliases, for the options the Service sets.
+func desiredAliases(state *DesiredState, owner opnsense.Owner) []opnsense.Alias {
+	var out []opnsense.Alias
+	if len(state.SourceRanges) > 0 {
+		out = append(out, opnsense.Alias{Name: owner.AliasName(aliasSourceRanges), Type: "network", Content: state.SourceRanges})
+	}
+	if len(state.AllowCountries) > 0 {
+		out = append(out, opnsense.Alias{Name: owner.AliasName(aliasCountries), Type: "geoip", Content: state.AllowCountries})
+	}
+	if len(state.BlockURLs) > 0 {
+		out = append(out, opnsense.Alias{Name: owner.AliasName(aliasBlockLists), Type: "urltable", Content: state.BlockURLs})
+	}
+	return out
+}
+
+// PassRuleOptions controls the filter pass rules created for every NAT rule.
+type PassRuleOptions struct {
+	// Log enables logging on every pass rule; AnnotationLog enables it for one Service.
+	Log bool
+	// Sequence positions the pass rules among all filter rules: operator rules with a lower
+	// sequence are evaluated first. 0 lets OPNsense append them.
+	Sequence int
+}
+
+// checkBlockRules returns an error when opts needs block rules but the pass rules of pass sit at
+// a sequence that leaves no lower one to evaluate the block rules first.
+func checkBlockRules(opts ServiceOptions, pass PassRuleOptions) error {
+	if len(opts.AllowCountries)+len(opts.BlockURLs)+len(opts.BlockAliases) == 0 || pass.Sequence > 1 {
+		return nil
+	}
+	return fmt.Errorf("%s and %s need block rules evaluated before the pass rules, which needs a pass rule sequence above 1 (got %d)",
+		AnnotationAllowCountries, AnnotationBlockLists, pass.Sequence)
+}
+
+// desiredFilterRules returns the filter rules for each NAT rule without filter rule association:
+// a block rule per block list and one for sources outside the allowed countries, followed by the
+// pass rule allowing the NAT rule's source to reach its target. Block rules are ordered just
+// before the pass rules. Each is described as e.g. "Pass default/web TCP/80", followed by the
+// Service's Description note, if any.
+func desiredFilterRules(state *DesiredState, natRules []opnsense.NATRule, owner opnsense.Owner, opts PassRuleOptions) []opnsense.FilterRule {
+	var blocked []string
+	if len(state.BlockURLs) > 0 {
+		blocked = append(blocked, owner.AliasName(aliasBlockLists))
+	}
+	blocked = append(blocked, state.BlockAliases...)
+	blockSequence := opts.Sequence
+	if blockSequence > 1 {
+		blockSequence--
+	}
+	var out []opnsense.FilterRule
+	for _, r := range natRules {
+		if !r.NoAutoPass {
+			continue
+		}
+		target := fmt.Sprintf("%s %s/%d", owner.ServiceKey(), strings.ToUpper(r.Protocol), r.ExternalPort)
+		rule := func(action, sourceNet string, sourceNot bool, sequence int, note string) opnsense.FilterRule {
+			if state.Description != "" {
+				note += ": " + state.Description
+			}
+			return opnsense.FilterRule{
+				Action:          action,
+				Interface:       r.Interface,
+				Protocol:        r.Protocol,
+				SourceNet:       sourceNet,
+				SourceNot:       sourceNot,
+				DestinationIP:   r.TargetIP,
+				DestinationPort: r.TargetPort,
+				PortName:        r.PortName,
+				Log:             opts.Log || state.Log,
+				Sequence:        sequence,
+				Note:            note,
+			}
+		}
+		for _, alias := range blocked {
+			out = append(out, rule(opnsense.ActionBlock, alias, false, blockSequence, "Block "+target+" from "+alias))
+		}
+		if len(state.AllowCountries) > 0 {
+			out = append(out, rule(opnsense.ActionBlock, owner.AliasName(aliasCountries), true, blockSequence,
+				"Block "+target+" outside "+strings.Join(state.AllowCountries, ",")))
+		}
+		out = append(out, rule(opnsense.ActionPass, r.SourceNet, false, opts.Sequence, "Pass "+target))
+	}
+	return out
+}
+
+// desiredStateHash returns a canonical hash of state: rules and backends are sorted first, so
+// the order of Service ports or Endpoints addresses does not matter. Every field of DesiredState
+// is covered, so options added to it later change the hash too.
+func desiredStateHash(state *DesiredState) string {
+	canonical := *state
+	canonical.Rules = make([]NATRule, 0, len(state.Rules))
+	for _, r := range state.Rules {
+		r.Backends = slices.Clone(r.Backends)
+		slices.SortFunc(r.Backends, func(a, b Backend) int {
+			return cmp.Or(cmp.Compare(a.IP, b.IP), cmp.Compare(a.Port, b.Port))
+		})
+		canonical.Rules = append(canonical.Rules, r)
+	}
+	slices.SortFunc(canonical.Rules, func(a, b NATRule) int {
+		return cmp.Or(cmp.Compare(a.Protocol, b.Protocol), cmp.Compare(a.ExternalPort, b.ExternalPort),
+			cmp.Compare(a.PortName, b.PortName))
+	})
+	b, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
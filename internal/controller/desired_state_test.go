@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"slices"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -29,7 +30,7 @@ func TestComputeDesiredState(t *testing.T) {
 		},
 	}
 
-	got, err := ComputeDesiredState(vip, svc, ep, nodePort, nil)
+	got, err := ComputeDesiredState(vip, svc, ep, nodePort, nil, ServiceOptions{})
 	if err != nil {
 		t.Fatalf("ComputeDesiredState: %v", err)
 	}
@@ -80,13 +81,13 @@ func TestComputeDesiredState_NodeNameResolution(t *testing.T) {
 			},
 		},
 	}
-	getNodeIP := func(name string) (string, bool) {
+	getNode := func(name string) (*corev1.Node, bool) {
 		if name == "node-1" {
-			return "192.168.1.10", true
+			return testNode("node-1", "192.168.1.10"), true
 		}
-		return "", false
+		return nil, false
 	}
-	got, err := ComputeDesiredState(vip, svc, ep, nodePort, getNodeIP)
+	got, err := ComputeDesiredState(vip, svc, ep, nodePort, getNode, ServiceOptions{})
 	if err != nil {
 		t.Fatalf("ComputeDesiredState: %v", err)
 	}
@@ -97,3 +98,126 @@ func TestComputeDesiredState_NodeNameResolution(t *testing.T) {
 		t.Errorf("Backend IP: got %s, want 192.168.1.10 (resolved from NodeName)", got.Rules[0].Backends[0].IP)
 	}
 }
+
+// testNode returns a Node named name with internal IP ip.
+func testNode(name, ip string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}}},
+	}
+}
+
+func TestComputeDesiredState_SkipsNodesThatMustNotServe(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-svc"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	ready := func(status corev1.ConditionStatus) func(*corev1.Node) {
+		return func(n *corev1.Node) {
+			n.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+		}
+	}
+	nodes := map[string]*corev1.Node{}
+	ep := &corev1.Endpoints{ //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-svc"},
+		Subsets:    []corev1.EndpointSubset{{}}, //nolint:staticcheck // SA1019
+	}
+	for _, n := range []struct {
+		name, ip string
+		mutate   func(*corev1.Node)
+	}{
+		{"ready", "192.168.1.1", ready(corev1.ConditionTrue)},
+		{"unreported", "192.168.1.2", func(*corev1.Node) {}},
+		{"cordoned", "192.168.1.3", func(n *corev1.Node) { n.Spec.Unschedulable = true }},
+		{"not-ready", "192.168.1.4", ready(corev1.ConditionFalse)},
+		{"lost", "192.168.1.5", ready(corev1.ConditionUnknown)},
+		{"excluded", "192.168.1.6", func(n *corev1.Node) { n.Labels = map[string]string{LabelExcludeFromExternalLB: ""} }},
+	} {
+		nodes[n.name] = testNode(n.name, n.ip)
+		n.mutate(nodes[n.name])
+		ep.Subsets[0].Addresses = append(ep.Subsets[0].Addresses, corev1.EndpointAddress{IP: "10.0.0.1", NodeName: &n.name})
+	}
+	getNode := func(name string) (*corev1.Node, bool) {
+		n, ok := nodes[name]
+		return n, ok
+	}
+	got, err := ComputeDesiredState("192.0.2.1", svc, ep, 0, getNode, ServiceOptions{})
+	if err != nil {
+		t.Fatalf("ComputeDesiredState: %v", err)
+	}
+	var ips []string
+	for _, b := range got.Rules[0].Backends {
+		ips = append(ips, b.IP)
+	}
+	slices.Sort(ips)
+	if want := []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"}; !slices.Equal(ips, want) {
+		t.Errorf("backends: got %v, want the ready, unreported and cordoned Nodes %v", ips, want)
+	}
+}
+
+func TestComputeDesiredState_PodBackends(t *testing.T) {
+	nodeName := "node-1"
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-svc"},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+				{Name: "metrics", Port: 9090, NodePort: 30090, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+	ep := &corev1.Endpoints{ //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-svc"},
+		Subsets: []corev1.EndpointSubset{{ //nolint:staticcheck // SA1019
+			Addresses: []corev1.EndpointAddress{{IP: "10.244.1.5", NodeName: &nodeName}},
+			Ports:     []corev1.EndpointPort{{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP}}, //nolint:staticcheck // SA1019
+		}},
+	}
+	getNode := func(string) (*corev1.Node, bool) { return testNode("node-1", "192.168.1.10"), true }
+	got, err := ComputeDesiredState("192.0.2.1", svc, ep, 0, getNode,
+		ServiceOptions{BackendMode: BackendModePod, Interface: "opt1"})
+	if err != nil {
+		t.Fatalf("ComputeDesiredState: %v", err)
+	}
+	if len(got.Rules) != 2 {
+		t.Fatalf("Rules: got %d, want 2", len(got.Rules))
+	}
+	if b := got.Rules[0].Backends; len(b) != 1 || b[0] != (Backend{IP: "10.244.1.5", Port: 8080}) {
+		t.Errorf("http backends: got %+v, want the pod IP and target port", b)
+	}
+	if b := got.Rules[1].Backends; b == nil || len(b) != 0 {
+		t.Errorf("metrics backends: got %#v, want none", b)
+	}
+	if got.Interface != "opt1" {
+		t.Errorf("Interface: got %q, want opt1", got.Interface)
+	}
+}
+
+func TestDesiredStateHash(t *testing.T) {
+	base := &DesiredState{VIP: "192.0.2.1", Rules: []NATRule{
+		{PortName: "http", ExternalPort: 80, Protocol: "TCP", Backends: []Backend{{IP: "10.0.0.1", Port: 30080}, {IP: "10.0.0.2", Port: 30080}}},
+		{PortName: "dns", ExternalPort: 53, Protocol: "UDP", Backends: []Backend{{IP: "10.0.0.1", Port: 30053}}},
+	}}
+	reordered := &DesiredState{VIP: "192.0.2.1", Rules: []NATRule{
+		{PortName: "dns", ExternalPort: 53, Protocol: "UDP", Backends: []Backend{{IP: "10.0.0.1", Port: 30053}}},
+		{PortName: "http", ExternalPort: 80, Protocol: "TCP", Backends: []Backend{{IP: "10.0.0.2", Port: 30080}, {IP: "10.0.0.1", Port: 30080}}},
+	}}
+	if desiredStateHash(base) != desiredStateHash(reordered) {
+		t.Error("hash depends on the order of rules or backends")
+	}
+	if base.Rules[0].Backends[0].IP != "10.0.0.1" || reordered.Rules[1].Backends[0].IP != "10.0.0.2" {
+		t.Error("desiredStateHash modified its input")
+	}
+	changed := &DesiredState{VIP: "192.0.2.2", Rules: base.Rules}
+	if desiredStateHash(base) == desiredStateHash(changed) {
+		t.Error("hash ignores the VIP")
+	}
+	logged := &DesiredState{VIP: "192.0.2.1", Rules: base.Rules, Log: true}
+	if desiredStateHash(base) == desiredStateHash(logged) {
+		t.Error("hash ignores the Service options")
+	}
+}
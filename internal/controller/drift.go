@@ -0,0 +1,285 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// DriftPolicy controls what the controller does when the firewall no longer matches what it
+// last applied for a Service (e.g. a port forward was edited or disabled in the OPNsense UI).
+type DriftPolicy string
+
+const (
+	// DriftPolicyRepair re-applies the desired state, overwriting manual edits.
+	DriftPolicyRepair DriftPolicy = "repair"
+	// DriftPolicyReport only emits a DriftDetected Event and metrics; the firewall is left
+	// alone until the Service itself changes.
+	DriftPolicyReport DriftPolicy = "report"
+)
+
+// syncRecord is what the controller last applied to OPNsense for one Service key.
+type syncRecord struct {
+	// hash is the desiredStateHash of the state last applied in full; empty after a partial apply.
+	hash  string
+	vip   string
+	rules []string
+	// filters are the filterSignatures of the filter rules last applied.
+	filters []string
+	// drifted is true while the firewall is known to differ from rules (report policy).
+	drifted bool
+	// checkedAt is when OPNsense was last set to, or compared with, this record.
+	checkedAt time.Time
+}
+
+// ruleSignatures returns a sorted, comparable representation of rules: everything the
+// controller sets on a rule, plus whether it was disabled.
+func ruleSignatures(rules []opnsense.NATRule) []string {
+	out := make([]string, 0, len(rules))
+	for _, r := range rules {
+		sig := fmt.Sprintf("%s %s:%d->%s:%d", strings.ToUpper(r.Protocol), cmp.Or(r.Interface, opnsense.DefaultInterface),
+			r.ExternalPort, r.TargetIP, r.TargetPort)
+		if r.SourceNet != "" {
+			sig += " from " + r.SourceNet
+		}
+		if r.Log {
+			sig += " (logged)"
+		}
+		if r.Note != "" {
+			sig += fmt.Sprintf(" %q", r.Note)
+		}
+		if r.NoAutoPass {
+			sig += " (no auto-pass)"
+		}
+		if r.Disabled {
+			sig += " (disabled)"
+		}
+		out = append(out, sig)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// filterSignatures returns a sorted, comparable representation of filter rules, like
+// ruleSignatures.
+func filterSignatures(rules []opnsense.FilterRule) []string {
+	out := make([]string, 0, len(rules))
+	for _, r := range rules {
+		source := cmp.Or(r.SourceNet, "any")
+		if r.SourceNot {
+			source = "!" + source
+		}
+		sig := fmt.Sprintf("%s %s %s from %s to %s:%d", cmp.Or(r.Action, opnsense.ActionPass), strings.ToUpper(r.Protocol),
+			cmp.Or(r.Interface, opnsense.DefaultInterface), source, r.DestinationIP, r.DestinationPort)
+		if r.Sequence > 0 {
+			sig += fmt.Sprintf(" at %d", r.Sequence)
+		}
+		if r.Log {
+			sig += " (logged)"
+		}
+		if r.Note != "" {
+			sig += fmt.Sprintf(" %q", r.Note)
+		}
+		if r.Disabled {
+			sig += " (disabled)"
+		}
+		out = append(out, sig)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// diffSignatures appends "missing" and "unexpected" entries to drift[kind] for the differences
+// between want and got.
+func diffSignatures(drift map[string][]string, kind string, want, got []string) {
+	got = slices.Clone(got)
+	for _, sig := range want {
+		if i := slices.Index(got, sig); i >= 0 {
+			got = slices.Delete(got, i, i+1)
+			continue
+		}
+		drift[kind] = append(drift[kind], "missing "+sig)
+	}
+	for _, sig := range got {
+		drift[kind] = append(drift[kind], "unexpected "+sig)
+	}
+}
+
+// detectDrift compares the NAT rules, filter rules and VIP on OPNsense with what was last applied for owner.
+// It returns one human-readable entry per difference, grouped by object kind; both are empty
+// when nothing was applied for owner by this process yet.
+func (r *Reconciler) detectDrift(ctx context.Context, owner opnsense.Owner) (map[string][]string, error) {
+	r.mu.Lock()
+	rec, ok := r.synced[owner.ServiceKey()]
+	r.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return r.firewallDiff(ctx, owner, rec.rules, rec.filters, rec.vip)
+}
+
+// firewallDiff compares the NAT rules and filter rules of owner on OPNsense with the signatures
+// rules and filters, and checks that vip exists unless it is empty. It returns one
+// human-readable entry per difference, grouped by object kind.
+func (r *Reconciler) firewallDiff(ctx context.Context, owner opnsense.Owner, rules, filters []string, vip string) (map[string][]string, error) {
+	all, err := r.OPNsense.ListNATRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var current []opnsense.NATRule
+	for _, rule := range all {
+		if tag, ok := opnsense.ParseTag(rule.Description); ok && tag.OwnedBy(owner) {
+			current = append(current, rule)
+		}
+	}
+	drift := make(map[string][]string)
+	diffSignatures(drift, kindNATRule, rules, ruleSignatures(current))
+
+	allFilters, err := r.OPNsense.ListFilterRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var currentFilters []opnsense.FilterRule
+	for _, rule := range allFilters {
+		if tag, ok := opnsense.ParseTag(rule.Description); ok && tag.OwnedBy(owner) {
+			currentFilters = append(currentFilters, rule)
+		}
+	}
+	diffSignatures(drift, kindFilterRule, filters, filterSignatures(currentFilters))
+
+	if vip != "" {
+		vips, err := r.OPNsense.ListVIPs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(vips, func(v opnsense.VIP) bool { return v.Address() == vip }) {
+			drift[kindVIP] = append(drift[kindVIP], "missing VIP "+vip)
+		}
+	}
+	return drift, nil
+}
+
+// appliedHash returns the hash of the state last applied in full for key, or "".
+func (r *Reconciler) appliedHash(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.synced[key]; ok {
+		return rec.hash
+	}
+	return ""
+}
+
+// skipUnchanged reports whether a reconcile of key can skip OPNsense entirely: hash equals the
+// state last applied in full and the next drift check is not due. wait is the time until it is
+// (0 when periodic drift checks are disabled).
+func (r *Reconciler) skipUnchanged(key, hash string) (wait time.Duration, skip bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.synced[key]
+	if !ok || rec.hash == "" || rec.hash != hash {
+		return 0, false
+	}
+	if r.DriftCheckInterval <= 0 {
+		return 0, true
+	}
+	wait = r.DriftCheckInterval - r.now().Sub(rec.checkedAt)
+	return wait, wait > 0
+}
+
+// recordApplied remembers vip, rules and filters as the last state applied for key. hash is the
+// desiredStateHash of that state, or "" when it was only partially applied.
+func (r *Reconciler) recordApplied(key, hash, vip string, rules []opnsense.NATRule, filters []opnsense.FilterRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.synced[key] = &syncRecord{hash: hash, vip: vip, rules: ruleSignatures(rules), filters: filterSignatures(filters),
+		checkedAt: r.now()}
+	serviceRules.WithLabelValues(key, kindNATRule).Set(float64(len(rules)))
+	serviceRules.WithLabelValues(key, kindFilterRule).Set(float64(len(filters)))
+	if hash != "" {
+		serviceLastSync.WithLabelValues(key).Set(float64(r.now().Unix()))
+	}
+	r.updateServiceGauges()
+}
+
+// markChecked records that OPNsense was found to match the last applied state for key.
+func (r *Reconciler) markChecked(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.synced[key]; ok {
+		rec.checkedAt = r.now()
+		serviceLastSync.WithLabelValues(key).Set(float64(r.now().Unix()))
+	}
+}
+
+// markDrifted flags key as left drifted under DriftPolicyReport.
+func (r *Reconciler) markDrifted(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.synced[key]; ok {
+		rec.drifted = true
+		rec.checkedAt = r.now()
+	}
+	r.updateServiceGauges()
+}
+
+// markPaused records that a reconcile of key was skipped while paused, so the next one applies
+// the desired state in full even if it did not change.
+func (r *Reconciler) markPaused(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rec, ok := r.synced[key]; ok {
+		rec.hash = ""
+	}
+}
+
+// forget drops everything recorded for key, e.g. after cleanup.
+func (r *Reconciler) forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.synced, key)
+	serviceRules.DeletePartialMatch(map[string]string{"service": key})
+	serviceLastSync.DeleteLabelValues(key)
+	r.updateServiceGauges()
+}
+
+// updateServiceGauges must be called with r.mu held.
+func (r *Reconciler) updateServiceGauges() {
+	var n int
+	for _, rec := range r.synced {
+		if rec.drifted {
+			n++
+		}
+	}
+	servicesDrifted.Set(float64(n))
+	servicesManaged.Set(float64(len(r.synced)))
+}
+
+// driftSummary formats drift for an Event message.
+func driftSummary(drift map[string][]string) string {
+	var parts []string
+	for _, kind := range []string{kindNATRule, kindFilterRule, kindVIP} {
+		parts = append(parts, drift[kind]...)
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// plannedChanges returns the changes held back for the Service key and its VIPs when the
+// Reconciler runs on an *opnsense.DryRun client; ok is false otherwise.
+func (r *Reconciler) plannedChanges(key string, vips ...string) (changes []opnsense.Change, ok bool) {
+	dry, ok := r.OPNsense.(*opnsense.DryRun)
+	if !ok {
+		return nil, false
+	}
+	for _, vip := range vips {
+		if c, planned := dry.VIPPlan(vip); vip != "" && planned {
+			changes = append(changes, c)
+		}
+	}
+	return append(changes, dry.ServicePlan(key)...), true
+}
+
+// reportPlan logs the changes held back for svc and records them in a Planned Event.
+func (r *Reconciler) reportPlan(ctx context.Context, svc *corev1.Service, changes []opnsense.Change) {
+	if lines := logPlan(ctx, svc.Namespace+"/"+svc.Name, changes); len(lines) > 0 {
+		r.EventRecorder.Eventf(svc, corev1.EventTypeNormal, "Planned", "dry run; not applied on OPNsense: %s",
+			strings.Join(lines, "; "))
+	}
+}
+
+// logPlan logs the changes held back for the Service key and returns them, one per line.
+func logPlan(ctx context.Context, key string, changes []opnsense.Change) []string {
+	if len(changes) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	log.FromContext(ctx).Info("Dry run: planned OPNsense changes", "key", key, "changes", lines)
+	return lines
+}
+
+// finishPlanned completes a sync of the Service nn, assigned vip instead of prevVIP, on an
+// *opnsense.DryRun client: the conditions are updated as after a sync, except that
+// LoadBalancerSynced counts the changes held back. The ingress is left alone, as vip may not
+// exist on OPNsense. Nothing is recorded as applied, so every reconcile plans again against the
+// current firewall state.
+func (r *Reconciler) finishPlanned(ctx context.Context, nn types.NamespacedName,
+	vip, prevVIP string) (ctrl.Result, string, error) {
+	key := nn.String()
+	vips := []string{vip}
+	if prevVIP != "" && prevVIP != vip {
+		if err := r.OPNsense.RemoveVIP(ctx, prevVIP, r.identity()); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to plan removal of previous VIP", "key", key, "vip", prevVIP)
+		}
+		vips = append(vips, prevVIP)
+	}
+	changes, _ := r.plannedChanges(key, vips...)
+	synced := condition(ConditionLoadBalancerSynced, true, "DryRun", "dry run; OPNsense matches the desired state")
+	if len(changes) > 0 {
+		synced = condition(ConditionLoadBalancerSynced, false, "DryRun",
+			"dry run; "+strconv.Itoa(len(changes))+" changes planned but not applied on OPNsense")
+	}
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, nn, &svc); err != nil {
+		return ctrl.Result{}, "Error", err
+	}
+	assigned := condition(ConditionVIPAssigned, false, "DryRun", "dry run; VIP "+vip+" planned but not published")
+	if ingress := svc.Status.LoadBalancer.Ingress; len(ingress) > 0 && ingress[0].IP == vip {
+		assigned = condition(ConditionVIPAssigned, true, "Allocated", "assigned VIP "+vip)
+	}
+	if err := SetServiceConditions(ctx, r.Client, &svc,
+		synced,
+		assigned,
+		condition(ConditionFirewallReachable, true, "APIReachable", "OPNsense API calls succeeded"),
+		condition(ConditionDegraded, false, "DryRun", "dry run; the ingress is left unchanged"),
+		condition(ConditionAnnotationsValid, true, "Valid", "all opnsense.org/ annotations are valid"),
+		condition(ConditionPaused, false, "Active", "reconciliation is active"),
+	); err != nil {
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "StatusPatchFailed", "patch Service status: %v", err)
+		return ctrl.Result{Requeue: true}, "StatusPatchFailed", nil
+	}
+	r.reportPlan(ctx, &svc, changes)
+	return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "Planned", nil
+}
+
+// deferPlanned handles the cleanup of svc, which is being deleted or no longer ours, on an
+// *opnsense.DryRun client: the removals are planned and reported, but OPNsense keeps the objects,
+// so the finalizer stays and the VIP stays allocated, as in deferPaused.
+func (r *Reconciler) deferPlanned(ctx context.Context, svc *corev1.Service, key string) (ctrl.Result, string, error) {
+	vip := r.VIPAlloc.GetVIP(key)
+	if err := r.removeOwned(ctx, r.owner(svc.Namespace, svc.Name, svc.UID), vip); err != nil {
+		r.EventRecorder.Eventf(svc, corev1.EventTypeWarning, "CleanupFailed", "dry run; could not plan the cleanup: %v", err)
+		return ctrl.Result{Requeue: true}, "CleanupFailed", nil
+	}
+	changes, _ := r.plannedChanges(key, vip)
+	r.reportPlan(ctx, svc, changes)
+	return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "Planned", nil
+}
+
+// deferPlannedDeleted is deferPlanned for a Service that is already gone, so the cleanup is only
+// logged: the VIP stays allocated until the controller runs without dry run.
+func (r *Reconciler) deferPlannedDeleted(ctx context.Context, owner opnsense.Owner) (ctrl.Result, string, error) {
+	key := owner.ServiceKey()
+	vip := r.VIPAlloc.GetVIP(key)
+	if err := r.removeOwned(ctx, owner, vip); err != nil {
+		log.FromContext(ctx).Error(err, "Dry run: could not plan the cleanup of deleted Service; will retry", "key", key)
+		return ctrl.Result{Requeue: true}, "CleanupFailed", nil
+	}
+	changes, _ := r.plannedChanges(key, vip)
+	logPlan(ctx, key, changes)
+	return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "Planned", nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// plannedEvent returns the only Planned event in events.
+func plannedEvent(t *testing.T, events []string) string {
+	t.Helper()
+	var planned []string
+	for _, ev := range events {
+		if strings.Contains(ev, " Planned ") {
+			planned = append(planned, ev)
+		}
+	}
+	if len(planned) != 1 {
+		t.Fatalf("events: got %v, want one Planned event", events)
+	}
+	return planned[0]
+}
+
+func TestReconcile_DryRunPlansChanges(t *testing.T) {
+	ctx := context.Background()
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	dry := opnsense.NewDryRun(mock)
+	r.OPNsense = dry
+
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if len(mock.VIPs()) != 0 || len(mock.NATRulesFor("default/web")) != 0 || len(mock.FilterRulesFor("default/web")) != 0 {
+		t.Fatalf("OPNsense changed in a dry run: VIPs %v, rules %+v", mock.VIPs(), mock.NATRulesFor("default/web"))
+	}
+	ev := plannedEvent(t, drainEvents(recorder))
+	for _, want := range []string{"create vip 192.0.2.1/32 on wan", "create nat rule TCP wan:80->192.168.1.10:30080", "create filter rule pass TCP wan"} {
+		if !strings.Contains(ev, want) {
+			t.Errorf("Planned event %q: want %q", ev, want)
+		}
+	}
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if c := meta.FindStatusCondition(svc.Status.Conditions, ConditionLoadBalancerSynced); c == nil ||
+		c.Status != metav1.ConditionFalse || c.Reason != "DryRun" {
+		t.Errorf("%s: got %+v, want False/DryRun", ConditionLoadBalancerSynced, c)
+	}
+	// The planned VIP does not exist on OPNsense, so it is not published.
+	if ingress := svc.Status.LoadBalancer.Ingress; len(ingress) != 0 {
+		t.Errorf("ingress in a dry run: got %+v, want none", ingress)
+	}
+	if c := meta.FindStatusCondition(svc.Status.Conditions, ConditionVIPAssigned); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("%s: got %+v, want False", ConditionVIPAssigned, c)
+	}
+	if got := len(dry.Plan().Services["default/web"]); got != 2 {
+		t.Errorf("planned Service changes: got %d, want the NAT and filter rule", got)
+	}
+
+	// Against a synced firewall, only the difference is planned.
+	r.OPNsense = mock
+	reconcileKey(ctx, t, r, "default", "web")
+	r.OPNsense = dry
+	drainEvents(recorder)
+	setAnnotations(ctx, t, r, map[string]string{AnnotationLog: "true"})
+	reconcileKey(ctx, t, r, "default", "web")
+	ev = plannedEvent(t, drainEvents(recorder))
+	if !strings.Contains(ev, "update nat rule TCP wan:80->192.168.1.10:30080 (logged)") || strings.Contains(ev, "vip") {
+		t.Errorf("Planned event %q: want only the NAT and filter rule updates", ev)
+	}
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || rules[0].Log {
+		t.Errorf("rules changed in a dry run: %+v", rules)
+	}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if ingress := svc.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != "192.0.2.1" {
+		t.Errorf("ingress of a synced Service in a dry run: got %+v, want 192.0.2.1", ingress)
+	}
+	if c := meta.FindStatusCondition(svc.Status.Conditions, ConditionVIPAssigned); c == nil || c.Status != metav1.ConditionTrue {
+		t.Errorf("%s: got %+v, want True", ConditionVIPAssigned, c)
+	}
+
+	// Deleting the Service plans its removal, but keeps its finalizer and VIP as long as
+	// OPNsense keeps the objects.
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if err := r.Client.Delete(ctx, &svc); err != nil {
+		t.Fatalf("Delete Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(&svc), &svc); err != nil || !slices.Contains(svc.Finalizers, testFinalizer) {
+		t.Fatalf("finalizer removed in a dry run: %v, %v", err, svc.Finalizers)
+	}
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "192.0.2.1" {
+		t.Errorf("VIP after a dry-run delete: got %q, want 192.0.2.1 still allocated", vip)
+	}
+	ev = plannedEvent(t, drainEvents(recorder))
+	if !strings.Contains(ev, "delete vip 192.0.2.1/32") || !strings.Contains(ev, "delete nat rule") {
+		t.Errorf("Planned event %q: want the VIP and NAT rule deleted", ev)
+	}
+	if !slices.Contains(mock.VIPs(), "192.0.2.1") || len(mock.NATRulesFor("default/web")) != 1 {
+		t.Errorf("OPNsense changed in a dry run: VIPs %v, rules %+v", mock.VIPs(), mock.NATRulesFor("default/web"))
+	}
+
+	// A real run then completes the cleanup.
+	r.OPNsense = mock
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(&svc), &svc); err == nil {
+		t.Errorf("Service still present after a real run: finalizers %v", svc.Finalizers)
+	}
+	if len(mock.VIPs()) != 0 || len(mock.NATRulesFor("default/web")) != 0 {
+		t.Errorf("OPNsense not cleaned up: VIPs %v, rules %+v", mock.VIPs(), mock.NATRulesFor("default/web"))
+	}
+}
+
+func TestReconcile_DryRunPlansCleanupOfGoneService(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	// The Service disappears without the controller seeing its deletion, e.g. while it was down.
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	svc.Finalizers = nil
+	if err := r.Client.Update(ctx, &svc); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+	if err := r.Client.Delete(ctx, &svc); err != nil {
+		t.Fatalf("Delete Service: %v", err)
+	}
+
+	dry := opnsense.NewDryRun(mock)
+	r.OPNsense = dry
+	reconcileKey(ctx, t, r, "default", "web")
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "192.0.2.1" {
+		t.Errorf("VIP after a dry-run cleanup: got %q, want 192.0.2.1 still allocated", vip)
+	}
+	if !slices.Contains(mock.VIPs(), "192.0.2.1") || len(mock.NATRulesFor("default/web")) != 1 {
+		t.Errorf("OPNsense changed in a dry run: VIPs %v, rules %+v", mock.VIPs(), mock.NATRulesFor("default/web"))
+	}
+	plan := dry.Plan()
+	if len(plan.Services["default/web"]) == 0 || len(plan.VIPs) != 1 {
+		t.Errorf("plan: got %+v, want the removal of the Service's rules and VIP", plan)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// serviceForEndpoints maps an Endpoints object to the Service of the same name when that
+// Service is ours (or still carries our finalizer), so unrelated Endpoints churn is dropped.
+func (r *Reconciler) serviceForEndpoints(ctx context.Context, obj client.Object) []reconcile.Request {
+	nn := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, nn, &svc); err != nil {
+		return nil
+	}
+	if !r.isOurService(&svc) && !slices.Contains(svc.Finalizers, r.FinalizerName) {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: nn}}
+}
+
+// endpointsNodeNameField indexes Endpoints by the names of the Nodes their addresses run on.
+const endpointsNodeNameField = "opnsense-lb.endpoints.nodeName"
+
+// endpointsNodeNames is the indexer for endpointsNodeNameField. Not-ready addresses count too:
+// their Service will select the Node as soon as they become ready.
+func endpointsNodeNames(obj client.Object) []string {
+	ep, ok := obj.(*corev1.Endpoints) //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, sub := range ep.Subsets {
+		for _, addrs := range [][]corev1.EndpointAddress{sub.Addresses, sub.NotReadyAddresses} {
+			for _, a := range addrs {
+				if a.NodeName != nil && !slices.Contains(names, *a.NodeName) {
+					names = append(names, *a.NodeName)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// servicesForNode enqueues the Services of our class that have endpoints on the Node, since
+// node addresses are used as NodePort backends. Other Services cannot be affected by it.
+func (r *Reconciler) servicesForNode(ctx context.Context, node client.Object) []reconcile.Request {
+	var list corev1.EndpointsList //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if err := r.Client.List(ctx, &list, client.MatchingFields{endpointsNodeNameField: node.GetName()}); err != nil {
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		reqs = append(reqs, r.serviceForEndpoints(ctx, &list.Items[i])...)
+	}
+	return reqs
+}
+
+// Resync enqueues every Service of our class, e.g. after the configuration changed, and returns
+// how many. It blocks until the controller has taken them or ctx is done, so only call it while
+// the controller runs.
+func (r *Reconciler) Resync(ctx context.Context) (int, error) {
+	var list corev1.ServiceList
+	if err := r.Client.List(ctx, &list); err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range list.Items {
+		if !r.isOurService(&list.Items[i]) {
+			continue
+		}
+		select {
+		case r.resync <- event.GenericEvent{Object: &list.Items[i]}:
+			n++
+		case <-ctx.Done():
+			return n, ctx.Err()
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServicesForNode(t *testing.T) {
+	node1, node2 := "node-1", "node-2"
+	svc := func(name, class string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerClass: ptrString(class)},
+		}
+	}
+	endpoints := func(name string, ready, notReady *string) *corev1.Endpoints { //nolint:staticcheck // SA1019
+		sub := corev1.EndpointSubset{} //nolint:staticcheck // SA1019
+		if ready != nil {
+			sub.Addresses = []corev1.EndpointAddress{{IP: "10.0.0.1", NodeName: ready}}
+		}
+		if notReady != nil {
+			sub.NotReadyAddresses = []corev1.EndpointAddress{{IP: "10.0.0.2", NodeName: notReady}}
+		}
+		return &corev1.Endpoints{ //nolint:staticcheck // SA1019
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Subsets:    []corev1.EndpointSubset{sub}, //nolint:staticcheck // SA1019
+		}
+	}
+	r, _, _ := newTestReconciler(t, nil,
+		svc("on-node", testClass), endpoints("on-node", &node1, nil),
+		svc("starting-on-node", testClass), endpoints("starting-on-node", nil, &node1),
+		svc("elsewhere", testClass), endpoints("elsewhere", &node2, nil),
+		svc("foreign", "other.org/lb"), endpoints("foreign", &node1, nil),
+	)
+
+	reqs := r.servicesForNode(context.Background(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node1}})
+	got := make(map[string]bool)
+	for _, req := range reqs {
+		got[req.Name] = true
+	}
+	if len(got) != 2 || !got["on-node"] || !got["starting-on-node"] {
+		t.Errorf("servicesForNode(%s): got %v, want on-node and starting-on-node", node1, reqs)
+	}
+}
@@ -1,8 +1,10 @@
 package controller
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
@@ -11,83 +13,279 @@ import (
 // FakeOPNsense is an in-memory implementation of opnsense.Client for integration tests.
 // It records VIPs and NAT rules so tests can assert controller behavior.
 type FakeOPNsense struct {
-	mu    sync.RWMutex
-	vips  map[string]struct{}
-	rules []fakeNATRule
-	uuid  int
-}
-
-type fakeNATRule struct {
-	opnsense.NATRule
-	serviceKey string
+	mu      sync.RWMutex
+	vips    map[string]opnsense.Identity
+	rules   []opnsense.NATRule
+	filters []opnsense.FilterRule
+	aliases []opnsense.Alias
+	uuid    int
+	// err, when set, is returned by every mutating call (see SetError).
+	err error
+	// rejectPorts are external ports whose rules are refused (see RejectNATRulePort).
+	rejectPorts map[int]bool
+	// calls counts every opnsense.Client call (see Calls).
+	calls int
+	// creds are the credentials last set with SetCredentials.
+	creds opnsense.Credentials
 }
 
 // NewFakeOPNsense returns a new FakeOPNsense ready for use.
 func NewFakeOPNsense() *FakeOPNsense {
 	return &FakeOPNsense{
-		vips:  make(map[string]struct{}),
+		vips:  make(map[string]opnsense.Identity),
 		rules: nil,
 	}
 }
 
-// EnsureVIP records the VIP. Implements opnsense.Client.
-func (f *FakeOPNsense) EnsureVIP(ctx context.Context, vip string) error {
+// SetError makes every subsequent mutating call fail with err until it is reset with nil.
+// Used to simulate an unreachable firewall.
+func (f *FakeOPNsense) SetError(err error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	f.vips[vip] = struct{}{}
+	f.err = err
+}
+
+// RejectNATRulePort makes ApplyNATRules refuse rules for the given external port, returning an
+// *opnsense.ApplyError like the real client does when OPNsense rejects some rules.
+func (f *FakeOPNsense) RejectNATRulePort(port int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rejectPorts == nil {
+		f.rejectPorts = make(map[int]bool)
+	}
+	f.rejectPorts[port] = true
+}
+
+// EnsureVIP records the VIP tagged with id, unless it already exists. Implements opnsense.Client.
+func (f *FakeOPNsense) EnsureVIP(ctx context.Context, vip, iface string, id opnsense.Identity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.vips[vip]; !ok {
+		f.vips[vip] = id
+	}
 	return nil
 }
 
-// RemoveVIP removes the VIP. Implements opnsense.Client.
-func (f *FakeOPNsense) RemoveVIP(ctx context.Context, vip string) error {
+// RemoveVIP removes the VIP if id adopts its tag. Implements opnsense.Client.
+func (f *FakeOPNsense) RemoveVIP(ctx context.Context, vip string, id opnsense.Identity) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	delete(f.vips, vip)
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	if owner, ok := f.vips[vip]; ok && id.Adopts(owner) {
+		delete(f.vips, vip)
+	}
 	return nil
 }
 
-// ListNATRules returns all stored rules so the controller can diff. Implements opnsense.Client.
-func (f *FakeOPNsense) ListNATRules(ctx context.Context) ([]opnsense.NATRule, error) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	out := make([]opnsense.NATRule, len(f.rules))
-	for i, r := range f.rules {
-		out[i] = r.NATRule
+// ListVIPs returns the recorded VIPs tagged like the real client tags the VIPs it creates.
+// Implements opnsense.Client.
+func (f *FakeOPNsense) ListVIPs(ctx context.Context) ([]opnsense.VIP, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	out := make([]opnsense.VIP, 0, len(f.vips))
+	for v, id := range f.vips {
+		out = append(out, opnsense.VIP{
+			UUID:        "fake-vip-" + v,
+			Subnet:      v + "/32",
+			Interface:   "wan",
+			Mode:        "ipalias",
+			Description: id.VIPDescription(v),
+		})
 	}
 	return out, nil
 }
 
-// ApplyNATRules replaces rules for this serviceKey with desired. Implements opnsense.Client.
-func (f *FakeOPNsense) ApplyNATRules(ctx context.Context, desired []opnsense.NATRule, managedBy, serviceKey string) error {
+// ListNATRules returns all stored rules so the controller can diff. Implements opnsense.Client.
+func (f *FakeOPNsense) ListNATRules(ctx context.Context) ([]opnsense.NATRule, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	// Remove existing rules for this serviceKey
-	n := 0
-	for _, r := range f.rules {
-		if r.serviceKey != serviceKey {
-			f.rules[n] = r
-			n++
-		}
+	f.calls++
+	return slices.Clone(f.rules), nil
+}
+
+// AddNATRule stores rule verbatim, description included, simulating a rule created outside
+// the controller (by an operator or an earlier release).
+func (f *FakeOPNsense) AddNATRule(rule opnsense.NATRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uuid++
+	rule.UUID = fmt.Sprintf("fake-uuid-%d", f.uuid)
+	f.rules = append(f.rules, rule)
+}
+
+// AddAlias stores alias verbatim, simulating an alias configured by an operator.
+func (f *FakeOPNsense) AddAlias(alias opnsense.Alias) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uuid++
+	alias.UUID = fmt.Sprintf("fake-uuid-%d", f.uuid)
+	f.aliases = append(f.aliases, alias)
+}
+
+// ApplyNATRules replaces the rules owned by owner with desired, matching descriptions with
+// opnsense.ParseTag like the real client. Implements opnsense.Client.
+func (f *FakeOPNsense) ApplyNATRules(ctx context.Context, desired []opnsense.NATRule, owner opnsense.Owner) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return f.err
 	}
-	f.rules = f.rules[:n]
-	// Append desired rules with UUID and serviceKey
+	f.rules = slices.DeleteFunc(f.rules, func(r opnsense.NATRule) bool {
+		tag, ok := opnsense.ParseTag(r.Description)
+		return ok && tag.OwnedBy(owner)
+	})
+	// Append desired rules with UUID and the owner's description, as ListNATRules returns them
+	var failed []opnsense.RuleError
 	for _, r := range desired {
+		if f.rejectPorts[r.ExternalPort] {
+			failed = append(failed, opnsense.RuleError{Rule: r, Err: fmt.Errorf("opnsense d_nat add_rule: 400 Bad Request")})
+			continue
+		}
 		f.uuid++
-		f.rules = append(f.rules, fakeNATRule{
-			NATRule: opnsense.NATRule{
-				UUID:         fmt.Sprintf("fake-uuid-%d", f.uuid),
-				ExternalPort: r.ExternalPort,
-				Protocol:     r.Protocol,
-				TargetIP:     r.TargetIP,
-				TargetPort:   r.TargetPort,
-				Description:  r.Description,
-			},
-			serviceKey: serviceKey,
+		f.rules = append(f.rules, opnsense.NATRule{
+			UUID:         fmt.Sprintf("fake-uuid-%d", f.uuid),
+			ExternalPort: r.ExternalPort,
+			Protocol:     r.Protocol,
+			TargetIP:     r.TargetIP,
+			TargetPort:   r.TargetPort,
+			Interface:    cmp.Or(r.Interface, opnsense.DefaultInterface),
+			SourceNet:    r.SourceNet,
+			Log:          r.Log,
+			Note:         r.Note,
+			NoAutoPass:   r.NoAutoPass,
+			Description:  opnsense.WithNote(owner.Description(r.PortName), r.Note),
 		})
 	}
+	if len(failed) > 0 {
+		return &opnsense.ApplyError{Failed: failed}
+	}
+	return nil
+}
+
+// ListFilterRules returns all stored filter rules. Implements opnsense.Client.
+func (f *FakeOPNsense) ListFilterRules(ctx context.Context) ([]opnsense.FilterRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return slices.Clone(f.filters), nil
+}
+
+// ApplyFilterRules makes the filter rules owned by owner match desired, keeping (with their
+// UUIDs) the rules that already do. Implements opnsense.Client.
+func (f *FakeOPNsense) ApplyFilterRules(ctx context.Context, desired []opnsense.FilterRule, owner opnsense.Owner) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	var kept []string
+	for _, r := range desired {
+		r.Interface = cmp.Or(r.Interface, opnsense.DefaultInterface)
+		r.Description = opnsense.WithNote(owner.Description(r.PortName), r.Note)
+		r.PortName = ""
+		if i := slices.IndexFunc(f.filters, func(cur opnsense.FilterRule) bool {
+			r.UUID = cur.UUID
+			return cur == r && !slices.Contains(kept, cur.UUID)
+		}); i >= 0 {
+			kept = append(kept, f.filters[i].UUID)
+			continue
+		}
+		f.uuid++
+		r.UUID = fmt.Sprintf("fake-uuid-%d", f.uuid)
+		kept = append(kept, r.UUID)
+		f.filters = append(f.filters, r)
+	}
+	f.filters = slices.DeleteFunc(f.filters, func(r opnsense.FilterRule) bool {
+		tag, ok := opnsense.ParseTag(r.Description)
+		return ok && tag.OwnedBy(owner) && !slices.Contains(kept, r.UUID)
+	})
+	return nil
+}
+
+// ListAliases returns all stored aliases. Implements opnsense.Client.
+func (f *FakeOPNsense) ListAliases(ctx context.Context) ([]opnsense.Alias, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return slices.Clone(f.aliases), nil
+}
+
+// EnsureAliases creates or replaces each alias, tagged with owner. Implements opnsense.Client.
+func (f *FakeOPNsense) EnsureAliases(ctx context.Context, aliases []opnsense.Alias, owner opnsense.Owner) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	for _, a := range aliases {
+		f.aliases = slices.DeleteFunc(f.aliases, func(e opnsense.Alias) bool { return e.Name == a.Name })
+		f.uuid++
+		a.UUID = fmt.Sprintf("fake-uuid-%d", f.uuid)
+		a.Description = owner.Description("")
+		f.aliases = append(f.aliases, a)
+	}
+	return nil
+}
+
+// RemoveAliases deletes the aliases owned by owner except keep. Implements opnsense.Client.
+func (f *FakeOPNsense) RemoveAliases(ctx context.Context, owner opnsense.Owner, keep []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	f.aliases = slices.DeleteFunc(f.aliases, func(a opnsense.Alias) bool {
+		tag, ok := opnsense.ParseTag(a.Description)
+		return ok && tag.OwnedBy(owner) && !slices.Contains(keep, a.Name)
+	})
 	return nil
 }
 
+// SetCredentials records creds. Implements opnsense.Client.
+func (f *FakeOPNsense) SetCredentials(creds opnsense.Credentials) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.creds = creds
+}
+
+// Credentials returns the credentials last set with SetCredentials (for assertions).
+func (f *FakeOPNsense) Credentials() opnsense.Credentials {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.creds
+}
+
+// DisableNATRules marks every rule of serviceKey as disabled, simulating a manual edit in the
+// OPNsense UI (for drift tests).
+func (f *FakeOPNsense) DisableNATRules(serviceKey string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.rules {
+		if tag, ok := opnsense.ParseTag(f.rules[i].Description); ok && tag.ServiceKey() == serviceKey {
+			f.rules[i].Disabled = true
+		}
+	}
+}
+
+// Calls returns the number of opnsense.Client calls made so far (for assertions).
+func (f *FakeOPNsense) Calls() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.calls
+}
+
 // VIPs returns a copy of the current set of VIPs (for assertions).
 func (f *FakeOPNsense) VIPs() []string {
 	f.mu.RLock()
@@ -99,14 +297,40 @@ func (f *FakeOPNsense) VIPs() []string {
 	return out
 }
 
-// NATRulesFor returns rules that were applied for the given serviceKey (for assertions).
+// NATRulesFor returns the rules whose description is tagged with serviceKey (for assertions).
 func (f *FakeOPNsense) NATRulesFor(serviceKey string) []opnsense.NATRule {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 	var out []opnsense.NATRule
 	for _, r := range f.rules {
-		if r.serviceKey == serviceKey {
-			out = append(out, r.NATRule)
+		if tag, ok := opnsense.ParseTag(r.Description); ok && tag.ServiceKey() == serviceKey {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// FilterRulesFor returns the filter rules whose description is tagged with serviceKey (for assertions).
+func (f *FakeOPNsense) FilterRulesFor(serviceKey string) []opnsense.FilterRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var out []opnsense.FilterRule
+	for _, r := range f.filters {
+		if tag, ok := opnsense.ParseTag(r.Description); ok && tag.ServiceKey() == serviceKey {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// AliasesFor returns the aliases whose description is tagged with serviceKey (for assertions).
+func (f *FakeOPNsense) AliasesFor(serviceKey string) []opnsense.Alias {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var out []opnsense.Alias
+	for _, a := range f.aliases {
+		if tag, ok := opnsense.ParseTag(a.Description); ok && tag.ServiceKey() == serviceKey {
+			out = append(out, a)
 		}
 	}
 	return out
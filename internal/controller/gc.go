@@ -0,0 +1,279 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// GarbageCollector removes NAT rules, filter rules, aliases and VIPs tagged with ManagedBy and ClusterID that no longer
+// belong to a live Service of our class, e.g. because the Service was deleted while the controller was down
+// or its finalizer was stripped. It runs once at startup and then every Interval as a manager
+// Runnable. An object is only removed after it has been orphaned for GracePeriod, so objects
+// created by an in-flight reconcile are not raced.
+type GarbageCollector struct {
+	Client            client.Reader
+	OPNsense          opnsense.Client
+	VIPAlloc          config.VIPAllocator
+	LoadBalancerClass string
+	ManagedBy         string
+	FinalizerName     string
+	// ClusterID scopes collection to objects tagged with exactly this cluster, so clusters
+	// sharing one firewall never collect each other's objects.
+	ClusterID   string
+	Interval    time.Duration
+	GracePeriod time.Duration
+	// DryRun logs orphans without removing them.
+	DryRun bool
+	// Paused, when set and returning true, makes a pass behave like DryRun, e.g. while
+	// reconciliation is paused (Reconciler.IsPaused).
+	Paused func() bool
+	// KeepVIPs are never removed, e.g. the single VIP shared by all Services.
+	KeepVIPs []string
+	// Namespaces restricts collection to rules of Services in these namespaces, matching a
+	// namespace-restricted cache; empty means all namespaces.
+	Namespaces []string
+
+	// firstSeen records when each orphan (by object ID) was first observed.
+	firstSeen map[string]time.Time
+	now       func() time.Time
+}
+
+// NewGarbageCollector returns a GarbageCollector with the given dependencies.
+func NewGarbageCollector(
+	c client.Reader,
+	opnsenseClient opnsense.Client,
+	vipAlloc config.VIPAllocator,
+	loadBalancerClass string,
+	managedBy string,
+	finalizerName string,
+	interval time.Duration,
+	gracePeriod time.Duration,
+	dryRun bool,
+	keepVIPs []string,
+) *GarbageCollector {
+	return &GarbageCollector{
+		Client:            c,
+		OPNsense:          opnsenseClient,
+		VIPAlloc:          vipAlloc,
+		LoadBalancerClass: loadBalancerClass,
+		ManagedBy:         managedBy,
+		FinalizerName:     finalizerName,
+		Interval:          interval,
+		GracePeriod:       gracePeriod,
+		DryRun:            dryRun,
+		KeepVIPs:          keepVIPs,
+		firstSeen:         make(map[string]time.Time),
+		now:               time.Now,
+	}
+}
+
+// Start runs a collection pass immediately and then every Interval until ctx is done.
+// Implements manager.Runnable.
+func (g *GarbageCollector) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("gc")
+	ctx = log.IntoContext(ctx, logger)
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		if err := g.Collect(ctx); err != nil {
+			logger.Error(err, "Garbage collection pass failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the collector run only on the leader. Implements manager.LeaderElectionRunnable.
+func (g *GarbageCollector) NeedLeaderElection() bool { return true }
+
+// Collect performs one garbage collection pass.
+func (g *GarbageCollector) Collect(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	var list corev1.ServiceList
+	if err := g.Client.List(ctx, &list); err != nil {
+		return err
+	}
+	liveKeys := make(map[string]bool)
+	inUseVIPs := make(map[string]bool)
+	for _, vip := range g.KeepVIPs {
+		inUseVIPs[vip] = true
+	}
+	for i := range list.Items {
+		svc := &list.Items[i]
+		// Services still carrying our finalizer are being finalized by the reconciler.
+		if !isLoadBalancerOfClass(svc, g.LoadBalancerClass) && !slices.Contains(svc.Finalizers, g.FinalizerName) {
+			continue
+		}
+		key := svc.Namespace + "/" + svc.Name
+		liveKeys[key] = true
+		if vip := g.VIPAlloc.GetVIP(key); vip != "" {
+			inUseVIPs[vip] = true
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			if ing.IP != "" {
+				inUseVIPs[ing.IP] = true
+			}
+		}
+	}
+
+	// Rules and aliases are grouped by Service and removed together, rules first, so an alias is
+	// no longer referenced when it is deleted.
+	orphanKeys := make(map[string]map[string]int)
+	owners := make(map[string]opnsense.Owner)
+	orphanCount := make(map[string]int)
+	collect := func(kind, desc string) {
+		tag, ok := opnsense.ParseTag(desc)
+		if !ok || tag.Identity != g.identity() || liveKeys[tag.ServiceKey()] {
+			return
+		}
+		// Services outside the cached namespaces are invisible, not gone.
+		if len(g.Namespaces) > 0 && !slices.Contains(g.Namespaces, tag.Namespace) {
+			return
+		}
+		key := tag.ServiceKey()
+		if orphanKeys[key] == nil {
+			orphanKeys[key] = make(map[string]int)
+		}
+		orphanKeys[key][kind]++
+		orphanCount[kind]++
+		owners[key] = tag.Owner
+	}
+	rules, err := g.OPNsense.ListNATRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		collect(kindNATRule, r.Description)
+	}
+	filters, err := g.OPNsense.ListFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range filters {
+		collect(kindFilterRule, r.Description)
+	}
+	aliases, err := g.OPNsense.ListAliases(ctx)
+	if err != nil {
+		return err
+	}
+	for _, a := range aliases {
+		collect(kindAlias, a.Description)
+	}
+
+	vips, err := g.OPNsense.ListVIPs(ctx)
+	if err != nil {
+		return err
+	}
+	var orphanVIPs []string
+	for _, v := range vips {
+		tag, ok := opnsense.ParseVIPTag(v.Description)
+		if !ok || tag.Identity != g.identity() || inUseVIPs[v.Address()] {
+			continue
+		}
+		orphanVIPs = append(orphanVIPs, v.Address())
+	}
+
+	for _, kind := range []string{kindNATRule, kindFilterRule, kindAlias} {
+		orphanedObjects.WithLabelValues(kind).Set(float64(orphanCount[kind]))
+	}
+	orphanedObjects.WithLabelValues(kindVIP).Set(float64(len(orphanVIPs)))
+
+	// Orphans are still tracked while paused, so their grace period runs.
+	dryRun := g.DryRun || (g.Paused != nil && g.Paused())
+	seen := make(map[string]time.Time)
+	for key, counts := range orphanKeys {
+		id := "service/" + key
+		if !g.due(id, seen) {
+			continue
+		}
+		if dryRun {
+			logger.Info("Orphaned rules found (dry run)", "key", key,
+				"natRules", counts[kindNATRule], "filterRules", counts[kindFilterRule], "aliases", counts[kindAlias])
+			continue
+		}
+		logger.Info("Removing orphaned rules", "key", key,
+			"natRules", counts[kindNATRule], "filterRules", counts[kindFilterRule], "aliases", counts[kindAlias])
+		if err := g.removeServiceObjects(ctx, owners[key]); err != nil {
+			logger.Error(err, "Removing orphaned rules failed", "key", key)
+			continue
+		}
+		for kind, n := range counts {
+			orphanedObjectsRemoved.WithLabelValues(kind).Add(float64(n))
+		}
+		delete(seen, id)
+	}
+	for _, vip := range orphanVIPs {
+		id := kindVIP + "/" + vip
+		if !g.due(id, seen) {
+			continue
+		}
+		if dryRun {
+			logger.Info("Orphaned VIP found (dry run)", "vip", vip)
+			continue
+		}
+		logger.Info("Removing orphaned VIP", "vip", vip)
+		if err := g.OPNsense.RemoveVIP(ctx, vip, g.identity()); err != nil {
+			logger.Error(err, "Removing orphaned VIP failed", "vip", vip)
+			continue
+		}
+		orphanedObjectsRemoved.WithLabelValues(kindVIP).Inc()
+		delete(seen, id)
+	}
+	// Objects that are no longer orphaned (or were removed) forget their first-seen time.
+	g.firstSeen = seen
+	return nil
+}
+
+// removeServiceObjects removes the NAT rules, filter rules and aliases of owner, in that order.
+func (g *GarbageCollector) removeServiceObjects(ctx context.Context, owner opnsense.Owner) error {
+	if err := g.OPNsense.ApplyNATRules(ctx, nil, owner); err != nil {
+		return err
+	}
+	if err := g.OPNsense.ApplyFilterRules(ctx, nil, owner); err != nil {
+		return err
+	}
+	return g.OPNsense.RemoveAliases(ctx, owner, nil)
+}
+
+// identity returns the opnsense.Identity whose objects g collects.
+func (g *GarbageCollector) identity() opnsense.Identity {
+	return opnsense.Identity{ManagedBy: g.ManagedBy, ClusterID: g.ClusterID}
+}
+
+// due records id as orphaned in seen and reports whether it has been orphaned for at least GracePeriod.
+func (g *GarbageCollector) due(id string, seen map[string]time.Time) bool {
+	first, ok := g.firstSeen[id]
+	if !ok {
+		first = g.now()
+	}
+	seen[id] = first
+	return g.now().Sub(first) >= g.GracePeriod
+}
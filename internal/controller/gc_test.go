@@ -0,0 +1,175 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+func TestGarbageCollector_Collect(t *testing.T) {
+	ctx := context.Background()
+	live := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "live"},
+		Spec: corev1.ServiceSpec{
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: ptrString(testClass),
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "192.0.2.1"}}},
+		},
+	}
+	newGC := func(dryRun bool) (*GarbageCollector, *FakeOPNsense, *time.Time) {
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(live).Build()
+		mock := NewFakeOPNsense()
+		for _, vip := range []string{"192.0.2.1", "192.0.2.2"} {
+			_ = mock.EnsureVIP(ctx, vip, "", testIdentity)
+		}
+		// Another cluster sharing the firewall owns default/gone too.
+		other := opnsense.Owner{Identity: opnsense.Identity{ManagedBy: testManagedBy, ClusterID: "other"},
+			Namespace: "default", Name: "gone"}
+		_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, other)
+		_ = mock.EnsureVIP(ctx, "192.0.2.9", "", other.Identity)
+		_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, testOwner("default/live"))
+		_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, testOwner("default/gone"))
+		_ = mock.ApplyFilterRules(ctx, []opnsense.FilterRule{{Protocol: "TCP", DestinationPort: 30080}}, testOwner("default/gone"))
+		_ = mock.EnsureAliases(ctx, []opnsense.Alias{{Name: "gone_src", Type: "network"}}, testOwner("default/gone"))
+		// Rules written by an earlier release use the legacy description format.
+		mock.AddNATRule(opnsense.NATRule{ExternalPort: 443, Protocol: "TCP", Description: testManagedBy + " default/gone 192.0.2.2"})
+		// Operator rules mentioning a Service must never be touched.
+		mock.AddNATRule(opnsense.NATRule{ExternalPort: 22, Protocol: "TCP", Description: "operator ssh for default/gone"})
+		vipAlloc := config.NewVIPAllocator(&config.Config{VIPPool: []string{"192.0.2.1", "192.0.2.2"}})
+		gc := NewGarbageCollector(c, mock, vipAlloc, testClass, testManagedBy, testFinalizer,
+			time.Minute, 5*time.Minute, dryRun, nil)
+		now := time.Now()
+		gc.now = func() time.Time { return now }
+		return gc, mock, &now
+	}
+
+	t.Run("removes orphans only after the grace period", func(t *testing.T) {
+		gc, mock, now := newGC(false)
+		if err := gc.Collect(ctx); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if len(mock.NATRulesFor("default/gone")) != 3 || !slices.Contains(mock.VIPs(), "192.0.2.2") {
+			t.Fatal("orphans removed before grace period elapsed")
+		}
+		*now = now.Add(6 * time.Minute)
+		if err := gc.Collect(ctx); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if n := len(mock.NATRulesFor("default/gone")); n != 1 {
+			t.Errorf("default/gone NAT rules: got %d, want only the other cluster's", n)
+		}
+		if n := len(mock.FilterRulesFor("default/gone")) + len(mock.AliasesFor("default/gone")); n != 0 {
+			t.Errorf("default/gone filter rules and aliases: got %d, want 0", n)
+		}
+		if !slices.Contains(mock.VIPs(), "192.0.2.9") {
+			t.Error("VIP of another cluster removed")
+		}
+		if slices.Contains(mock.VIPs(), "192.0.2.2") {
+			t.Error("orphaned VIP 192.0.2.2 not removed")
+		}
+		if n := len(mock.NATRulesFor("default/live")); n != 1 {
+			t.Errorf("live Service NAT rules: got %d, want 1", n)
+		}
+		if !slices.Contains(mock.VIPs(), "192.0.2.1") {
+			t.Error("VIP of live Service removed")
+		}
+		rules, _ := mock.ListNATRules(ctx)
+		if len(rules) != 3 {
+			t.Errorf("remaining NAT rules: got %v, want the live Service's, the other cluster's and the operator's", rules)
+		}
+	})
+
+	t.Run("dry run reports without removing", func(t *testing.T) {
+		gc, mock, now := newGC(true)
+		_ = gc.Collect(ctx)
+		*now = now.Add(6 * time.Minute)
+		if err := gc.Collect(ctx); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if len(mock.NATRulesFor("default/gone")) != 3 || !slices.Contains(mock.VIPs(), "192.0.2.2") {
+			t.Error("dry run removed orphaned objects")
+		}
+	})
+
+	t.Run("paused keeps orphans until resumed", func(t *testing.T) {
+		gc, mock, now := newGC(false)
+		paused := true
+		gc.Paused = func() bool { return paused }
+		_ = gc.Collect(ctx)
+		*now = now.Add(6 * time.Minute)
+		if err := gc.Collect(ctx); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if len(mock.NATRulesFor("default/gone")) != 3 || !slices.Contains(mock.VIPs(), "192.0.2.2") {
+			t.Fatal("orphaned objects removed while paused")
+		}
+		// The grace period kept running while paused.
+		paused = false
+		if err := gc.Collect(ctx); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		if slices.Contains(mock.VIPs(), "192.0.2.2") {
+			t.Error("orphaned VIP 192.0.2.2 not removed after resume")
+		}
+	})
+}
+
+func TestForeignVIPOwners(t *testing.T) {
+	ctx := context.Background()
+	mock := NewFakeOPNsense()
+	prod := opnsense.Identity{ManagedBy: testManagedBy, ClusterID: "prod"}
+	_ = mock.EnsureVIP(ctx, "192.0.2.1", "", prod)
+	_ = mock.EnsureVIP(ctx, "192.0.2.2", "", opnsense.Identity{ManagedBy: testManagedBy, ClusterID: "staging"})
+	_ = mock.EnsureVIP(ctx, "192.0.2.3", "", testIdentity)
+	_ = mock.EnsureVIP(ctx, "192.0.2.4", "", opnsense.Identity{ManagedBy: testManagedBy, ClusterID: "staging"})
+
+	got, err := ForeignVIPOwners(ctx, mock, prod, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	if err != nil {
+		t.Fatalf("ForeignVIPOwners: %v", err)
+	}
+	slices.Sort(got)
+	if len(got) != 2 || !strings.Contains(got[0], "192.0.2.2") || !strings.Contains(got[1], "192.0.2.3") {
+		t.Errorf("ForeignVIPOwners: got %q, want warnings for 192.0.2.2 and 192.0.2.3", got)
+	}
+	if strings.Contains(got[1], "adopt") {
+		t.Errorf("ForeignVIPOwners: 192.0.2.3 must not be adopted without AdoptUnclustered: %q", got[1])
+	}
+
+	prod.AdoptUnclustered = true
+	got, err = ForeignVIPOwners(ctx, mock, prod, []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"})
+	if err != nil {
+		t.Fatalf("ForeignVIPOwners: %v", err)
+	}
+	slices.Sort(got)
+	if len(got) != 2 || !strings.Contains(got[1], "192.0.2.3") || !strings.Contains(got[1], "adopt") {
+		t.Errorf("ForeignVIPOwners with AdoptUnclustered: got %q, want 192.0.2.3 to be adopted", got)
+	}
+}
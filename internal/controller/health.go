@@ -0,0 +1,121 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// FirewallProbe checks every Interval that the OPNsense API answers with the configured
+// credentials, and serves the result as a readiness check. It runs on every replica, leader or
+// not, so a standby that could not take over is not reported ready.
+type FirewallProbe struct {
+	OPNsense opnsense.Client
+	Interval time.Duration
+	// MaxAge is how long after the last successful call the firewall still counts as reachable.
+	MaxAge time.Duration
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	lastErr error
+	now     func() time.Time
+}
+
+// NewFirewallProbe returns a FirewallProbe with the given dependencies.
+func NewFirewallProbe(opnsenseClient opnsense.Client, interval, maxAge time.Duration) *FirewallProbe {
+	return &FirewallProbe{
+		OPNsense: opnsenseClient,
+		Interval: interval,
+		MaxAge:   maxAge,
+		now:      time.Now,
+	}
+}
+
+// Start probes immediately and then every Interval until ctx is done. Implements manager.Runnable.
+func (p *FirewallProbe) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("firewall-probe")
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Probe(ctx); err != nil {
+			logger.Error(err, "OPNsense API probe failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes the probe run on every replica. Implements manager.LeaderElectionRunnable.
+func (p *FirewallProbe) NeedLeaderElection() bool { return false }
+
+// Probe makes one read-only OPNsense API call and records its outcome.
+func (p *FirewallProbe) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Interval)
+	defer cancel()
+	_, err := p.OPNsense.ListVIPs(ctx)
+	if opnsense.IsAuthError(err) {
+		err = fmt.Errorf("OPNsense rejected the API credentials: %w", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErr = err
+	if err == nil {
+		p.lastOK = p.now()
+	}
+	return err
+}
+
+// Check fails unless a probe succeeded within MaxAge. Implements healthz.Checker.
+func (p *FirewallProbe) Check(_ *http.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.lastOK.IsZero() && p.lastErr == nil:
+		return errors.New("OPNsense API not probed yet")
+	case p.lastOK.IsZero():
+		return fmt.Errorf("OPNsense API never reachable: %w", p.lastErr)
+	case p.now().Sub(p.lastOK) > p.MaxAge:
+		return fmt.Errorf("OPNsense API last reachable %s ago: %w", p.now().Sub(p.lastOK).Round(time.Second), p.lastErr)
+	}
+	return nil
+}
+
+// CacheSyncCheck returns a readiness check that fails until the informer caches of c have synced.
+func CacheSyncCheck(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("informer caches not synced")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// failingVIPList makes ListVIPs return err, like an unreachable or misconfigured firewall.
+type failingVIPList struct {
+	*FakeOPNsense
+	err error
+}
+
+func (f *failingVIPList) ListVIPs(ctx context.Context) ([]opnsense.VIP, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.FakeOPNsense.ListVIPs(ctx)
+}
+
+func TestFirewallProbe(t *testing.T) {
+	ctx := context.Background()
+	fw := &failingVIPList{FakeOPNsense: NewFakeOPNsense()}
+	p := NewFirewallProbe(fw, 30*time.Second, 90*time.Second)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	if err := p.Check(nil); err == nil {
+		t.Error("Check before the first probe: got ready")
+	}
+	fw.err = &opnsense.APIError{Endpoint: "interfaces/vip_settings searchItem", StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"}
+	_ = p.Probe(ctx)
+	if err := p.Check(nil); err == nil || !strings.Contains(err.Error(), "credentials") {
+		t.Errorf("Check with rejected credentials: got %v, want a credentials error", err)
+	}
+
+	fw.err = nil
+	if err := p.Probe(ctx); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	fw.err = context.DeadlineExceeded
+	now = now.Add(time.Minute)
+	_ = p.Probe(ctx)
+	if err := p.Check(nil); err != nil {
+		t.Errorf("Check one failed probe after a success: got %v, want ready within MaxAge", err)
+	}
+	now = now.Add(time.Minute)
+	if err := p.Check(nil); err == nil || !strings.Contains(err.Error(), "2m0s ago") {
+		t.Errorf("Check after MaxAge: got %v, want not ready since 2m0s", err)
+	}
+}
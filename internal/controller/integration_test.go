@@ -17,10 +17,7 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/scheuk/opnsense-lb-controller/internal/config"
 )
@@ -84,35 +81,7 @@ func startController(ctx context.Context, cfg *rest.Config, mock *FakeOPNsense,
 		"opnsense-lb-controller",
 		"opnsense.org/opnsense-lb",
 	)
-	endpointsEnqueue := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
-		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}}
-	})
-	servicesEnqueueForNode := func(cl client.Reader, loadBalancerClass string) handler.MapFunc {
-		return func(ctx context.Context, obj client.Object) []reconcile.Request {
-			var list corev1.ServiceList
-			if err := cl.List(ctx, &list); err != nil {
-				return nil
-			}
-			var reqs []reconcile.Request
-			for i := range list.Items {
-				svc := &list.Items[i]
-				if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-					continue
-				}
-				if svc.Spec.LoadBalancerClass == nil || *svc.Spec.LoadBalancerClass != loadBalancerClass {
-					continue
-				}
-				reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}})
-			}
-			return reqs
-		}
-	}
-	if err := ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Service{}).
-		WithEventFilter(ServiceLoadBalancerClass("opnsense.org/opnsense-lb")).
-		Watches(&corev1.Endpoints{}, endpointsEnqueue). //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
-		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(servicesEnqueueForNode(mgr.GetClient(), "opnsense.org/opnsense-lb"))).
-		Complete(rec); err != nil {
+	if err := rec.SetupWithManager(mgr); err != nil {
 		panic(err)
 	}
 	go func() {
@@ -277,6 +246,44 @@ func TestIntegration_ChangeLoadBalancerClass_Cleanup(t *testing.T) {
 	waitForNoNATRules(t, mock, serviceKey, 10*time.Second)
 }
 
+func TestIntegration_ChangeTypeToClusterIP_Cleanup(t *testing.T) {
+	requireEnvtest(t)
+	_, k8sClient, mock, _ := testStartEnvtest()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ns := "test-changetype-svc"
+	svcName := "lb5"
+	nodeName := "node-changetype-svc"
+	serviceKey := ns + "/" + svcName
+	createNamespace(ctx, t, k8sClient, ns)
+	createNode(ctx, t, k8sClient, nodeName, "192.0.2.10")
+	createLoadBalancerService(ctx, t, k8sClient, ns, svcName, 30084)
+	createEndpoints(ctx, t, k8sClient, ns, svcName, nodeName)
+
+	ip := waitForIngressIP(ctx, t, k8sClient, ns, svcName, 10*time.Second)
+	patch := `{"spec":{"type":"ClusterIP"}}`
+	if _, err := k8sClient.CoreV1().Services(ns).Patch(ctx, svcName, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		t.Fatalf("patch service: %v", err)
+	}
+	waitForNoNATRules(t, mock, serviceKey, 10*time.Second)
+	if slices.Contains(mock.VIPs(), ip) {
+		t.Errorf("expected VIP %s to be released after type change, still in mock: %v", ip, mock.VIPs())
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		svc, err := k8sClient.CoreV1().Services(ns).Get(ctx, svcName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get service: %v", err)
+		}
+		if !slices.Contains(svc.Finalizers, "opnsense.org/opnsense-lb") && len(svc.Status.LoadBalancer.Ingress) == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("timeout waiting for finalizer and status to be removed after type change")
+}
+
 func ptr(s string) *string { return &s }
 
 func waitForIngressIP(ctx context.Context, t *testing.T, cl kubernetes.Interface, ns, svcName string, timeout time.Duration) string {
@@ -0,0 +1,114 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+)
+
+// Object kinds used as the "kind" label on firewall object metrics.
+const (
+	kindNATRule    = "nat_rule"
+	kindFilterRule = "filter_rule"
+	kindAlias      = "alias"
+	kindVIP        = "vip"
+)
+
+var (
+	orphanedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opnsense_lb_orphaned_objects",
+		Help: "Managed OPNsense objects without a live Service of our class, as of the last garbage collection pass.",
+	}, []string{"kind"})
+
+	orphanedObjectsRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_orphaned_objects_removed_total",
+		Help: "Orphaned OPNsense objects removed by garbage collection.",
+	}, []string{"kind"})
+
+	driftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_drift_detected_total",
+		Help: "Differences found between OPNsense and the state last applied by the controller.",
+	}, []string{"kind"})
+
+	servicesDrifted = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "opnsense_lb_services_drifted",
+		Help: "Services whose firewall state is known to differ from the desired state and was not repaired.",
+	})
+
+	servicesManaged = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "opnsense_lb_services_managed",
+		Help: "Services whose firewall objects the controller has applied since it started.",
+	})
+
+	poolVIPs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opnsense_lb_pool_vips",
+		Help: "VIPs per pool by state (allocated or free); the default pool is labeled \"default\".",
+	}, []string{"pool", "state"})
+
+	serviceRules = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opnsense_lb_service_rules",
+		Help: "Firewall rules last applied for each Service, by kind.",
+	}, []string{"service", "kind"})
+
+	serviceLastSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "opnsense_lb_service_last_sync_timestamp_seconds",
+		Help: "When OPNsense last fully matched the desired state of each Service, as a Unix timestamp.",
+	}, []string{"service"})
+
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_reconcile_total",
+		Help: "Service reconciles by result (success, requeue or error) and reason.",
+	}, []string{"result", "reason"})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_config_reloads_total",
+		Help: "Changes of the config file by result: applied, refused (needs a restart or drops VIPs in use) or invalid.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphanedObjects, orphanedObjectsRemoved, driftDetected, servicesDrifted,
+		servicesManaged, poolVIPs, serviceRules, serviceLastSync, reconcileTotal, configReloads)
+}
+
+// reconcileResult returns the "result" label of opnsense_lb_reconcile_total.
+func reconcileResult(res ctrl.Result, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case res.Requeue: //nolint:staticcheck // SA1019: Requeue is still how this controller retries
+		return "requeue"
+	}
+	return "success"
+}
+
+// setPoolVIPs sets opnsense_lb_pool_vips from usage, dropping the series of pools no longer
+// configured.
+func setPoolVIPs(usage []config.PoolUsage) {
+	poolVIPs.Reset()
+	for _, u := range usage {
+		pool := cmp.Or(u.Pool, "default")
+		poolVIPs.WithLabelValues(pool, "allocated").Set(float64(u.Allocated))
+		poolVIPs.WithLabelValues(pool, "free").Set(float64(u.Free))
+	}
+}
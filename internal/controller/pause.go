@@ -0,0 +1,100 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// Reasons of the Paused condition.
+const (
+	pausedReasonController = "ControllerPaused"
+	pausedReasonService    = "ServicePaused"
+)
+
+// SetPaused pauses or resumes reconciliation of every Service. Call Resync after resuming, so
+// the changes held back are applied.
+func (r *Reconciler) SetPaused(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Paused = paused
+}
+
+// IsPaused reports whether reconciliation of every Service is paused.
+func (r *Reconciler) IsPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Paused
+}
+
+// pauseReason returns the reason of the Paused condition when reconciliation of svc is
+// paused, globally or by AnnotationPaused.
+func (r *Reconciler) pauseReason(svc *corev1.Service) (string, bool) {
+	switch {
+	case r.IsPaused():
+		return pausedReasonController, true
+	case svc.Annotations[AnnotationPaused] == "true":
+		return pausedReasonService, true
+	}
+	return "", false
+}
+
+// reportPaused handles a reconcile of svc while it is paused. The changes a sync of rules and
+// the VIP would make are listed in the Paused condition, and in a Paused Event whenever they
+// change, but OPNsense is not touched. The Service is checked again every DriftCheckInterval.
+func (r *Reconciler) reportPaused(ctx context.Context, svc *corev1.Service, reason string,
+	owner opnsense.Owner, vip string, rules []opnsense.NATRule, filters []opnsense.FilterRule) (ctrl.Result, string, error) {
+	r.markPaused(owner.ServiceKey())
+	msg := "reconciliation paused; OPNsense matches the desired state"
+	if diff, err := r.firewallDiff(ctx, owner, ruleSignatures(rules), filterSignatures(filters), vip); err != nil {
+		msg = "reconciliation paused; could not compare with OPNsense: " + err.Error()
+	} else if len(diff) > 0 {
+		msg = "reconciliation paused; OPNsense differs from the desired state: " + driftSummary(diff)
+	}
+	return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "Paused", r.setPaused(ctx, svc, reason, msg)
+}
+
+// deferPaused handles the cleanup of svc, which is being deleted or no longer ours, while it is
+// paused: the finalizer stays until reconciliation is resumed.
+func (r *Reconciler) deferPaused(ctx context.Context, svc *corev1.Service, reason string) (ctrl.Result, string, error) {
+	log.FromContext(ctx).Info("Cleanup deferred while paused", "key", svc.Namespace+"/"+svc.Name)
+	return ctrl.Result{}, "Paused", r.setPaused(ctx, svc, reason,
+		"reconciliation paused; removal of the Service's rules and VIP from OPNsense is deferred")
+}
+
+// setPaused sets the Paused condition of svc to True with msg, and emits a Paused Event when
+// msg is new.
+func (r *Reconciler) setPaused(ctx context.Context, svc *corev1.Service, reason, msg string) error {
+	var latest corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, &latest); err != nil {
+		return err
+	}
+	if c := meta.FindStatusCondition(latest.Status.Conditions, ConditionPaused); c == nil ||
+		c.Status != metav1.ConditionTrue || c.Message != msg {
+		r.EventRecorder.Event(&latest, corev1.EventTypeNormal, "Paused", msg)
+	}
+	return SetServiceConditions(ctx, r.Client, &latest, condition(ConditionPaused, true, reason, msg))
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+)
+
+// pausedCondition returns the Paused condition of default/web.
+func pausedCondition(ctx context.Context, t *testing.T, r *Reconciler) *metav1.Condition {
+	t.Helper()
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	return meta.FindStatusCondition(svc.Status.Conditions, ConditionPaused)
+}
+
+func TestReconcile_ServicePausedReportsChanges(t *testing.T) {
+	ctx := context.Background()
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if c := pausedCondition(ctx, t, r); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("%s after sync: got %+v, want False", ConditionPaused, c)
+	}
+	before := mock.NATRulesFor("default/web")
+	drainEvents(recorder)
+
+	setAnnotations(ctx, t, r, map[string]string{AnnotationPaused: "true"})
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); !slices.Equal(rules, before) {
+		t.Errorf("rules changed while paused: got %+v, want %+v", rules, before)
+	}
+	c := pausedCondition(ctx, t, r)
+	if c == nil || c.Status != metav1.ConditionTrue || c.Reason != pausedReasonService {
+		t.Fatalf("%s: got %+v, want True/%s", ConditionPaused, c, pausedReasonService)
+	}
+	if c.Message != "reconciliation paused; OPNsense matches the desired state" {
+		t.Errorf("message: got %q, want no pending changes", c.Message)
+	}
+	if !hasEvent(drainEvents(recorder), "Paused") {
+		t.Error("expected Paused event")
+	}
+
+	// A pending change is listed, and the Event is only repeated when the list changes.
+	setAnnotations(ctx, t, r, map[string]string{AnnotationPaused: "true", AnnotationLog: "true"})
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	c = pausedCondition(ctx, t, r)
+	if !strings.Contains(c.Message, "missing TCP wan:80->192.168.1.10:30080 (logged)") ||
+		!strings.Contains(c.Message, "unexpected TCP wan:80->192.168.1.10:30080") {
+		t.Errorf("message: got %q, want the logged rule missing and the current one unexpected", c.Message)
+	}
+	if events := drainEvents(recorder); len(events) != 1 {
+		t.Errorf("events: got %v, want one Paused event", events)
+	}
+
+	// Requesting another VIP keeps the current one allocated, and reports the new one missing.
+	setAnnotations(ctx, t, r, map[string]string{AnnotationPaused: "true", AnnotationLog: "true",
+		AnnotationLoadBalancerIP: "192.0.2.2"})
+	reconcileKey(ctx, t, r, "default", "web")
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "192.0.2.1" {
+		t.Errorf("VIP while paused: got %q, want 192.0.2.1 kept", vip)
+	}
+	if _, err := r.VIPAlloc.Allocate("default/other", config.VIPRequest{IP: "192.0.2.1"}); err == nil {
+		t.Error("the VIP of a paused Service was handed to another Service")
+	}
+	if c := pausedCondition(ctx, t, r); !strings.Contains(c.Message, "192.0.2.2") {
+		t.Errorf("message: got %q, want the requested VIP 192.0.2.2 missing", c.Message)
+	}
+	drainEvents(recorder)
+
+	setAnnotations(ctx, t, r, map[string]string{AnnotationLog: "true"})
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || !rules[0].Log {
+		t.Errorf("rules after resume: got %+v, want one logged rule", rules)
+	}
+	if c := pausedCondition(ctx, t, r); c == nil || c.Status != metav1.ConditionFalse {
+		t.Errorf("%s after resume: got %+v, want False", ConditionPaused, c)
+	}
+}
+
+func TestReconcile_ControllerPausedDefersChanges(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	r.SetPaused(true)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if len(mock.VIPs()) != 0 || len(mock.NATRulesFor("default/web")) != 0 {
+		t.Fatalf("OPNsense changed while paused: VIPs %v, rules %+v", mock.VIPs(), mock.NATRulesFor("default/web"))
+	}
+	c := pausedCondition(ctx, t, r)
+	if c == nil || c.Reason != pausedReasonController || !strings.Contains(c.Message, "missing TCP wan:80->192.168.1.10:30080") {
+		t.Errorf("%s: got %+v, want ControllerPaused listing the missing NAT rule", ConditionPaused, c)
+	}
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "" {
+		t.Errorf("VIP allocated while paused: %q", vip)
+	}
+
+	// Deleting the Service keeps its finalizer until reconciliation resumes.
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if err := r.Client.Delete(ctx, &svc); err != nil {
+		t.Fatalf("Delete Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(&svc), &svc); err != nil || !slices.Contains(svc.Finalizers, testFinalizer) {
+		t.Fatalf("finalizer removed while paused: %v, %v", err, svc.Finalizers)
+	}
+
+	r.SetPaused(false)
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(&svc), &svc); err == nil {
+		t.Errorf("Service still present after resume: finalizers %v", svc.Finalizers)
+	}
+}
+
+func TestReconcile_PausedAfterRestartChecksTheStatusVIP(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	// A restart during a maintenance window empties the in-memory allocator; meanwhile the VIP
+	// disappeared from OPNsense.
+	r.VIPAlloc = config.NewVIPAllocator(&config.Config{VIPPool: []string{"192.0.2.1"}})
+	r.SetPaused(true)
+	if err := mock.RemoveVIP(ctx, "192.0.2.1", testIdentity); err != nil {
+		t.Fatalf("RemoveVIP: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if c := pausedCondition(ctx, t, r); c == nil || !strings.Contains(c.Message, "missing VIP 192.0.2.1") {
+		t.Errorf("%s: got %+v, want the missing VIP from the Service status", ConditionPaused, c)
+	}
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "" {
+		t.Errorf("VIP allocated while paused: %q", vip)
+	}
+}
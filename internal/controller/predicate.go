@@ -17,27 +17,82 @@ limitations under the License.
 package controller
 
 import (
+	"slices"
+
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 // ServiceLoadBalancerClass returns a predicate that filters core/v1 Service events:
 // only Services with Spec.Type == LoadBalancer and Spec.LoadBalancerClass matching
-// the given loadBalancerClass pass the filter. Used with WithEventFilter() so only
-// Services with this LoadBalancerClass are reconciled.
+// the given loadBalancerClass pass the filter. Update events also pass when the old
+// object matched, so transitions away from the class (another class, or a type other
+// than LoadBalancer) still reach Reconcile and the Service's rules are cleaned up.
 func ServiceLoadBalancerClass(loadBalancerClass string) predicate.Predicate {
-	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
-		svc, ok := obj.(*corev1.Service)
-		if !ok || svc == nil {
-			return false
-		}
-		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-			return false
-		}
-		if svc.Spec.LoadBalancerClass == nil {
-			return false
+	matches := func(obj client.Object) bool {
+		return isLoadBalancerOfClass(obj, loadBalancerClass)
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return matches(e.Object) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return matches(e.Object) },
+		GenericFunc: func(e event.GenericEvent) bool { return matches(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return matches(e.ObjectOld) || matches(e.ObjectNew)
+		},
+	}
+}
+
+// isLoadBalancerOfClass reports whether obj is a LoadBalancer Service with the given loadBalancerClass.
+func isLoadBalancerOfClass(obj client.Object, loadBalancerClass string) bool {
+	svc, ok := obj.(*corev1.Service)
+	if !ok || svc == nil {
+		return false
+	}
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	if svc.Spec.LoadBalancerClass == nil {
+		return false
+	}
+	return *svc.Spec.LoadBalancerClass == loadBalancerClass
+}
+
+// LabelExcludeFromExternalLB is the well-known label that removes a Node from external load
+// balancer backends (see nodeServesLoadBalancer).
+const LabelExcludeFromExternalLB = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+// NodeBackendChanged returns a predicate that filters core/v1 Node events down to changes that
+// can affect NAT backends: creation, deletion, and updates of the Node's addresses, Ready
+// condition or LabelExcludeFromExternalLB. Status heartbeats and other updates, including
+// cordoning, are dropped, so large clusters do not reconcile every Service on every heartbeat.
+func NodeBackendChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok1 := e.ObjectOld.(*corev1.Node)
+			newNode, ok2 := e.ObjectNew.(*corev1.Node)
+			if !ok1 || !ok2 {
+				return true
+			}
+			return !slices.Equal(oldNode.Status.Addresses, newNode.Status.Addresses) ||
+				nodeReady(oldNode) != nodeReady(newNode) ||
+				hasLabel(oldNode, LabelExcludeFromExternalLB) != hasLabel(newNode, LabelExcludeFromExternalLB)
+		},
+	}
+}
+
+// nodeReady returns the status of the Node's Ready condition ("" when absent).
+func nodeReady(node *corev1.Node) corev1.ConditionStatus {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status
 		}
-		return *svc.Spec.LoadBalancerClass == loadBalancerClass
-	})
+	}
+	return ""
+}
+
+func hasLabel(obj client.Object, key string) bool {
+	_, ok := obj.GetLabels()[key]
+	return ok
 }
@@ -92,6 +92,42 @@ func TestServiceLoadBalancerClass(t *testing.T) {
 		}
 	})
 
+	t.Run("Update away from our class or type returns true", func(t *testing.T) {
+		ours := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "lb"},
+			Spec: corev1.ServiceSpec{
+				Type:              corev1.ServiceTypeLoadBalancer,
+				LoadBalancerClass: ptrString(class),
+			},
+		}
+		otherClass := ours.DeepCopy()
+		otherClass.Spec.LoadBalancerClass = ptrString("other.org/lb")
+		if !pred.Update(event.UpdateEvent{ObjectOld: ours, ObjectNew: otherClass}) {
+			t.Error("Update: expected true when loadBalancerClass changes away from ours")
+		}
+		clusterIP := ours.DeepCopy()
+		clusterIP.Spec.Type = corev1.ServiceTypeClusterIP
+		clusterIP.Spec.LoadBalancerClass = nil
+		if !pred.Update(event.UpdateEvent{ObjectOld: ours, ObjectNew: clusterIP}) {
+			t.Error("Update: expected true when type changes from LoadBalancer to ClusterIP")
+		}
+		if !pred.Update(event.UpdateEvent{ObjectOld: clusterIP, ObjectNew: ours}) {
+			t.Error("Update: expected true when type changes to LoadBalancer of our class")
+		}
+	})
+
+	t.Run("Update between foreign Services returns false", func(t *testing.T) {
+		oldSvc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foreign"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		}
+		newSvc := oldSvc.DeepCopy()
+		newSvc.Labels = map[string]string{"changed": "true"}
+		if pred.Update(event.UpdateEvent{ObjectOld: oldSvc, ObjectNew: newSvc}) {
+			t.Error("Update: expected false when neither old nor new Service is ours")
+		}
+	})
+
 	t.Run("nil object returns false", func(t *testing.T) {
 		e := event.CreateEvent{Object: nil}
 		if pred.Create(e) {
@@ -101,3 +137,47 @@ func TestServiceLoadBalancerClass(t *testing.T) {
 }
 
 func ptrString(s string) *string { return &s }
+
+func TestNodeBackendChanged(t *testing.T) {
+	pred := NodeBackendChanged()
+	node := func(mutate func(*corev1.Node)) *corev1.Node {
+		n := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1", ResourceVersion: "1"},
+			Status: corev1.NodeStatus{
+				Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.1.10"}},
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		if mutate != nil {
+			mutate(n)
+		}
+		return n
+	}
+	tests := []struct {
+		name   string
+		mutate func(*corev1.Node)
+		want   bool
+	}{
+		{"heartbeat", func(n *corev1.Node) {
+			n.ResourceVersion = "2"
+			n.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+		}, false},
+		{"unrelated label", func(n *corev1.Node) { n.Labels = map[string]string{"team": "a"} }, false},
+		{"address", func(n *corev1.Node) { n.Status.Addresses[0].Address = "192.168.1.11" }, true},
+		{"readiness", func(n *corev1.Node) { n.Status.Conditions[0].Status = corev1.ConditionFalse }, true},
+		{"cordon", func(n *corev1.Node) { n.Spec.Unschedulable = true }, false},
+		{"exclusion label", func(n *corev1.Node) {
+			n.Labels = map[string]string{LabelExcludeFromExternalLB: ""}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pred.Update(event.UpdateEvent{ObjectOld: node(nil), ObjectNew: node(tt.mutate)}); got != tt.want {
+				t.Errorf("Update: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !pred.Create(event.CreateEvent{Object: node(nil)}) || !pred.Delete(event.DeleteEvent{Object: node(nil)}) {
+		t.Error("Node create and delete events must pass")
+	}
+}
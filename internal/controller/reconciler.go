@@ -17,21 +17,50 @@ limitations under the License.
 package controller
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/scheuk/opnsense-lb-controller/internal/config"
 	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
 )
 
+// tracer creates the Reconcile span and its children. It is a no-op unless tracing is set up
+// (see package tracing); the OPNsense client adds a span per API request below it.
+var tracer = otel.Tracer("github.com/scheuk/opnsense-lb-controller/internal/controller")
+
+// Span attributes. They never include credentials or Service annotations.
+const (
+	attrService     = "opnsense_lb.service"
+	attrVIP         = "opnsense_lb.vip"
+	attrNATRules    = "opnsense_lb.nat_rules"
+	attrFilterRules = "opnsense_lb.filter_rules"
+	attrPorts       = "opnsense_lb.ports"
+	attrOutcome     = "opnsense_lb.outcome"
+)
+
 // Reconciler reconciles LoadBalancer Services with the configured LoadBalancerClass
 // by syncing desired NAT state to OPNsense and updating Service status.
 type Reconciler struct {
@@ -42,6 +71,31 @@ type Reconciler struct {
 	LoadBalancerClass string
 	ManagedBy         string
 	FinalizerName     string
+	// ClusterID is embedded in every object description so several clusters can share one
+	// firewall; empty for a single-cluster setup.
+	ClusterID string
+	// AdoptUnclustered lets a controller with a ClusterID take over objects it tagged before it
+	// had one; see opnsense.Identity.
+	AdoptUnclustered bool
+	// DriftCheckInterval is how often a synced Service is requeued to compare OPNsense with
+	// what was last applied; 0 disables periodic drift checks.
+	DriftCheckInterval time.Duration
+	// DriftPolicy selects whether detected drift is repaired or only reported.
+	DriftPolicy DriftPolicy
+	// PassRules controls the filter pass rule created for every NAT rule.
+	PassRules PassRuleOptions
+	// Interface is the OPNsense interface of Services without AnnotationInterface; empty for
+	// opnsense.DefaultInterface. Use SetInterface once the Reconciler runs.
+	Interface string
+	// Paused pauses reconciliation of every Service, like AnnotationPaused on each: changes
+	// are reported in the Paused condition but not applied. Use SetPaused once the Reconciler runs.
+	Paused bool
+
+	mu     sync.Mutex
+	synced map[string]*syncRecord
+	now    func() time.Time
+	// resync carries the Services enqueued by Resync.
+	resync chan event.GenericEvent
 }
 
 // NewReconciler returns a Reconciler with the given dependencies.
@@ -62,13 +116,56 @@ func NewReconciler(
 		LoadBalancerClass: loadBalancerClass,
 		ManagedBy:         managedBy,
 		FinalizerName:     finalizerName,
+		DriftPolicy:       DriftPolicyRepair,
+		synced:            make(map[string]*syncRecord),
+		now:               time.Now,
+		resync:            make(chan event.GenericEvent),
 	}
 }
 
+// SetupWithManager registers the Reconciler with mgr. Services are filtered with
+// ServiceLoadBalancerClass; Endpoints and Node changes enqueue the Services they back, with
+// Node events filtered by NodeBackendChanged. Resync enqueues Services directly.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Endpoints{}, //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+		endpointsNodeNameField, endpointsNodeNames); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Service{}, builder.WithPredicates(ServiceLoadBalancerClass(r.LoadBalancerClass))).
+		Watches(&corev1.Endpoints{}, //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+			handler.EnqueueRequestsFromMapFunc(r.serviceForEndpoints)).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.servicesForNode),
+			builder.WithPredicates(NodeBackendChanged())).
+		WatchesRawSource(source.Channel(r.resync, &handler.EnqueueRequestForObject{})).
+		Complete(r)
+}
+
 // Reconcile handles a Service key (namespace/name). It ensures NAT rules and VIP
 // on OPNsense match the desired state and updates Service status. When a Service
-// is deleted, cleanup runs and the finalizer is removed so the object can be deleted.
+// is deleted, cleanup runs and the finalizer is removed once OPNsense confirmed it.
+// Every outcome is counted by reason in opnsense_lb_reconcile_total.
 func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracer.Start(ctx, "Reconcile",
+		trace.WithAttributes(attribute.String(attrService, req.Namespace+"/"+req.Name)))
+	defer span.End()
+	res, reason, err := r.reconcile(ctx, req)
+	result := reconcileResult(res, err)
+	reconcileTotal.WithLabelValues(result, reason).Inc()
+	setPoolVIPs(r.VIPAlloc.Usage())
+	span.SetAttributes(attribute.String(attrOutcome, reason))
+	if err != nil {
+		span.RecordError(err)
+	}
+	if result != "success" {
+		span.SetStatus(codes.Error, reason)
+	}
+	return res, err
+}
+
+// reconcile implements Reconcile and returns the reason of its outcome, e.g. "Synced" or the
+// reason of the Warning Event it emitted.
+func (r *Reconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, string, error) {
 	logger := log.FromContext(ctx)
 	key := req.Namespace + "/" + req.Name
 	logger.Info("Reconciling Service", "key", key)
@@ -76,98 +173,367 @@ func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 	var svc corev1.Service
 	if err := r.Client.Get(ctx, req.NamespacedName, &svc); err != nil {
 		if apierrors.IsNotFound(err) {
-			r.cleanup(ctx, key)
-			return ctrl.Result{}, nil
+			if r.IsPaused() {
+				// Left to the garbage collector once reconciliation is resumed.
+				logger.Info("Cleanup skipped while paused", "key", key)
+				return ctrl.Result{}, "Paused", nil
+			}
+			if _, dryRun := r.OPNsense.(*opnsense.DryRun); dryRun {
+				return r.deferPlannedDeleted(ctx, r.owner(req.Namespace, req.Name, ""))
+			}
+			if err := r.cleanup(ctx, r.owner(req.Namespace, req.Name, "")); err != nil {
+				logger.Error(err, "Cleanup failed for deleted Service; will retry", "key", key)
+				return ctrl.Result{Requeue: true}, "CleanupFailed", nil
+			}
+			return ctrl.Result{}, "CleanedUp", nil
 		}
-		return ctrl.Result{}, err
+		return ctrl.Result{}, "Error", err
 	}
 
 	if svc.DeletionTimestamp != nil {
-		r.cleanup(ctx, key)
-		if err := r.removeFinalizer(ctx, &svc); err != nil {
-			return ctrl.Result{}, err
-		}
-		return ctrl.Result{}, nil
+		return r.finalize(ctx, &svc, key)
 	}
 
 	if !r.isOurService(&svc) {
-		r.cleanup(ctx, key)
-		return ctrl.Result{}, nil
+		if !slices.Contains(svc.Finalizers, r.FinalizerName) {
+			return ctrl.Result{}, "NotManaged", nil
+		}
+		// The Service was ours but no longer qualifies (loadBalancerClass or type changed):
+		// release everything we created for it and drop our finalizer and status.
+		return r.finalize(ctx, &svc, key)
 	}
 
 	if added, err := r.addFinalizerIfMissing(ctx, &svc); err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, "Error", err
 	} else if added {
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{Requeue: true}, "FinalizerAdded", nil
 	}
 
-	vip := r.VIPAlloc.Allocate(key)
-	if vip == "" {
-		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "NoVIP", "no VIP available for %s", key)
-		r.clearServiceStatus(ctx, req.NamespacedName)
-		return ctrl.Result{}, nil
+	opts, err := ParseServiceOptions(svc.Annotations)
+	if err != nil {
+		return r.rejectOptions(ctx, &svc, err)
+	}
+	if err := checkBlockRules(opts, r.PassRules); err != nil {
+		return r.rejectOptions(ctx, &svc, err)
+	}
+	opts.Interface = cmp.Or(opts.Interface, r.defaultInterface())
+	pauseReason, paused := r.pauseReason(&svc)
+	prevVIP := r.VIPAlloc.GetVIP(key)
+	var vip string
+	if paused {
+		// Allocating would move the Service in the allocator and free its VIP, which is still
+		// live on OPNsense: compare with the VIP it asks for, else the one in its status, which
+		// unlike the allocator survives a restart, else the allocated one.
+		vip = cmp.Or(opts.VIP.IP, ingressIP(&svc), prevVIP)
+	} else {
+		vip, err = r.allocate(ctx, key, opts.VIP)
+		if err != nil && !errors.Is(err, config.ErrNoVIPAvailable) {
+			return r.rejectOptions(ctx, &svc, fmt.Errorf("invalid VIP request: %w", err))
+		}
+		if vip == "" {
+			r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "NoVIP", "no VIP available for %s", key)
+			r.clearServiceStatus(ctx, req.NamespacedName,
+				condition(ConditionVIPAssigned, false, "NoVIPAvailable", "no VIP available in the configured pool"),
+				condition(ConditionLoadBalancerSynced, false, "NoVIPAvailable", "no VIP assigned"))
+			return ctrl.Result{}, "NoVIPAvailable", nil
+		}
 	}
 
 	var endpoints corev1.Endpoints //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
 	_ = r.Client.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: req.Name}, &endpoints)
 
-	getNodeIP := func(nodeName string) (string, bool) {
+	getNode := func(nodeName string) (*corev1.Node, bool) {
 		var node corev1.Node
 		if err := r.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &node); err != nil {
-			return "", false
-		}
-		for _, a := range node.Status.Addresses {
-			if a.Type == corev1.NodeInternalIP {
-				return a.Address, true
-			}
+			return nil, false
 		}
-		return "", false
+		return &node, true
 	}
 
-	state, err := ComputeDesiredState(vip, &svc, &endpoints, 0, getNodeIP)
+	_, computeSpan := tracer.Start(ctx, "ComputeDesiredState")
+	state, err := ComputeDesiredState(vip, &svc, &endpoints, 0, getNode, opts)
+	if state != nil {
+		computeSpan.SetAttributes(attribute.Int(attrPorts, len(state.Rules)))
+	}
+	computeSpan.End()
 	if err != nil {
 		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ComputeDesiredStateFailed", "ComputeDesiredState: %v", err)
-		r.clearServiceStatus(ctx, req.NamespacedName)
-		return ctrl.Result{Requeue: true}, nil
+		r.clearServiceStatus(ctx, req.NamespacedName,
+			condition(ConditionLoadBalancerSynced, false, "InvalidDesiredState", err.Error()))
+		return ctrl.Result{Requeue: true}, "InvalidDesiredState", nil
 	}
 	if state == nil {
-		return ctrl.Result{}, nil
+		return ctrl.Result{}, "NotManaged", nil
+	}
+
+	owner := r.owner(svc.Namespace, svc.Name, svc.UID)
+	desiredRules := desiredStateToOPNsenseRules(state, owner)
+	if paused {
+		return r.reportPaused(ctx, &svc, pauseReason, owner, state.VIP, desiredRules,
+			desiredFilterRules(state, desiredRules, owner, r.PassRules))
+	}
+
+	// Node heartbeats and unrelated Endpoints updates land here too: when nothing changed since
+	// the last full apply, only talk to OPNsense once the periodic drift check is due.
+	hash := desiredStateHash(state)
+	if wait, skip := r.skipUnchanged(key, hash); skip {
+		logger.V(1).Info("Desired state unchanged since last sync", "key", key)
+		return ctrl.Result{RequeueAfter: wait}, "Unchanged", nil
 	}
+	unchanged := r.appliedHash(key) == hash
 
-	if err := r.OPNsense.EnsureVIP(ctx, state.VIP); err != nil {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(attrVIP, vip), attribute.Int(attrNATRules, len(desiredRules)))
+	aliases := desiredAliases(state, owner)
+	drift, err := r.detectDrift(ctx, owner)
+	switch {
+	case err != nil:
+		logger.Error(err, "Drift check failed; re-applying", "key", key)
+	case len(drift) > 0:
+		for kind, diffs := range drift {
+			driftDetected.WithLabelValues(kind).Add(float64(len(diffs)))
+		}
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "DriftDetected",
+			"OPNsense differs from the last applied state (policy %s): %s", r.DriftPolicy, driftSummary(drift))
+		if r.DriftPolicy == DriftPolicyReport && unchanged {
+			r.markDrifted(key)
+			return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "DriftDetected", nil
+		}
+	case unchanged:
+		r.markChecked(key)
+		return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "InSync", nil
+	}
+
+	// Block rules for an alias that does not exist would make the whole filter apply fail.
+	missing, err := r.missingAliases(ctx, state.BlockAliases)
+	if err != nil {
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ListAliasesFailed", "OPNsense ListAliases: %v", err)
+		r.setDegraded(ctx, req.NamespacedName, "ListAliasesFailed", err)
+		return ctrl.Result{Requeue: true}, "ListAliasesFailed", nil
+	}
+	if len(missing) > 0 {
+		// The aliases may be created on OPNsense later, without any change to the Service.
+		result, reason, err := r.rejectOptions(ctx, &svc, fmt.Errorf("%s: aliases %s do not exist on OPNsense",
+			AnnotationBlockLists, strings.Join(missing, ", ")))
+		result.RequeueAfter = r.DriftCheckInterval
+		return result, reason, err
+	}
+
+	if err := r.OPNsense.EnsureVIP(ctx, state.VIP, state.Interface, r.identity()); err != nil {
 		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "EnsureVIPFailed", "OPNsense EnsureVIP: %v", err)
-		r.clearServiceStatus(ctx, req.NamespacedName)
-		return ctrl.Result{Requeue: true}, nil
+		r.setDegraded(ctx, req.NamespacedName, "EnsureVIPFailed", err)
+		return ctrl.Result{Requeue: true}, "EnsureVIPFailed", nil
+	}
+
+	// Aliases must exist before rules reference them, and are pruned only once no rule does.
+	if err := r.OPNsense.EnsureAliases(ctx, aliases, owner); err != nil {
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "EnsureAliasesFailed", "OPNsense EnsureAliases: %v", err)
+		r.setDegraded(ctx, req.NamespacedName, "EnsureAliasesFailed", err)
+		return ctrl.Result{Requeue: true}, "EnsureAliasesFailed", nil
+	}
+
+	// Pass rules go in before the NAT rules that need them and come out only after those NAT
+	// rules are gone, so OPNsense never forwards traffic that its filter then drops.
+	transition, err := r.transitionFilterRules(ctx, state, desiredRules, owner)
+	if err == nil {
+		err = r.OPNsense.ApplyFilterRules(ctx, transition, owner)
+	}
+	if err != nil {
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ApplyFilterRulesFailed", "OPNsense ApplyFilterRules: %v", err)
+		r.setDegraded(ctx, req.NamespacedName, "ApplyFilterRulesFailed", err)
+		return ctrl.Result{Requeue: true}, "ApplyFilterRulesFailed", nil
 	}
 
-	desiredRules := desiredStateToOPNsenseRules(state, r.ManagedBy, key)
-	if err := r.OPNsense.ApplyNATRules(ctx, desiredRules, r.ManagedBy, key); err != nil {
-		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ApplyNATRulesFailed", "OPNsense ApplyNATRules: %v", err)
-		r.clearServiceStatus(ctx, req.NamespacedName)
-		return ctrl.Result{Requeue: true}, nil
+	applyErr := r.OPNsense.ApplyNATRules(ctx, desiredRules, owner)
+	var partial *opnsense.ApplyError
+	if applyErr != nil && !errors.As(applyErr, &partial) {
+		r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ApplyNATRulesFailed", "OPNsense ApplyNATRules: %v", applyErr)
+		r.setDegraded(ctx, req.NamespacedName, "ApplyNATRulesFailed", applyErr)
+		return ctrl.Result{Requeue: true}, "ApplyNATRulesFailed", nil
+	}
+
+	// Pass rules are created only for the NAT rules OPNsense accepted.
+	applied := desiredRules
+	if partial != nil {
+		applied = nil
+		for _, rule := range desiredRules {
+			if !slices.ContainsFunc(partial.Failed, func(f opnsense.RuleError) bool { return f.Rule == rule }) {
+				applied = append(applied, rule)
+			}
+		}
+	}
+	filters := desiredFilterRules(state, applied, owner, r.PassRules)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int(attrFilterRules, len(filters)))
+	// The pass rules of NAT rules that are gone, or that OPNsense refused, come out last.
+	if !slices.Equal(filters, transition) {
+		if err := r.OPNsense.ApplyFilterRules(ctx, filters, owner); err != nil {
+			r.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ApplyFilterRulesFailed", "OPNsense ApplyFilterRules: %v", err)
+			r.setDegraded(ctx, req.NamespacedName, "ApplyFilterRulesFailed", err)
+			return ctrl.Result{Requeue: true}, "ApplyFilterRulesFailed", nil
+		}
+	}
+	keepAliases := make([]string, 0, len(aliases))
+	for _, a := range aliases {
+		keepAliases = append(keepAliases, a.Name)
+	}
+	if err := r.OPNsense.RemoveAliases(ctx, owner, keepAliases); err != nil {
+		// A stale alias is harmless: no rule references it any more. Retried on the next sync.
+		logger.Error(err, "Failed to remove unused aliases", "key", key)
+	}
+
+	portErrors := make(map[string]string)
+	for _, rule := range state.Rules {
+		if len(rule.Backends) == 0 {
+			portErrors[portKey(rule.Protocol, rule.ExternalPort)] = PortErrorNoBackends
+		}
+	}
+	if _, dryRun := r.OPNsense.(*opnsense.DryRun); dryRun {
+		return r.finishPlanned(ctx, req.NamespacedName, vip, prevVIP)
+	}
+	appliedHash := hash
+	synced := condition(ConditionLoadBalancerSynced, true, "Synced", "all NAT rules applied on OPNsense")
+	if partial != nil {
+		appliedHash = ""
+		for _, f := range partial.Failed {
+			portErrors[portKey(f.Rule.Protocol, int32(f.Rule.ExternalPort))] = PortErrorNATRuleFailed
+		}
+		synced = condition(ConditionLoadBalancerSynced, false, "PartiallySynced", partial.Error())
+	}
+	r.recordApplied(key, appliedHash, state.VIP, applied, filters)
+	if prevVIP != "" && prevVIP != vip {
+		// The annotations moved the Service to another VIP. A VIP left behind here is removed by
+		// the garbage collector, as it is no longer allocated.
+		if err := r.OPNsense.RemoveVIP(ctx, prevVIP, r.identity()); err != nil {
+			logger.Error(err, "Failed to remove previous VIP", "key", key, "vip", prevVIP)
+		}
 	}
 
 	var svcLatest corev1.Service
 	if err := r.Client.Get(ctx, req.NamespacedName, &svcLatest); err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, "Error", err
 	}
-	if err := UpdateServiceLoadBalancerIngress(ctx, r.Client, &svcLatest, vip); err != nil {
+	ingress := LoadBalancerIngress(vip, &svcLatest, portErrors)
+	if err := UpdateServiceLoadBalancerStatus(ctx, r.Client, &svcLatest, []corev1.LoadBalancerIngress{ingress},
+		synced,
+		condition(ConditionVIPAssigned, true, "Allocated", "assigned VIP "+vip),
+		condition(ConditionFirewallReachable, true, "APIReachable", "OPNsense API calls succeeded"),
+		condition(ConditionDegraded, false, "Synced", "status reflects the firewall state"),
+		condition(ConditionAnnotationsValid, true, "Valid", "all opnsense.org/ annotations are valid"),
+		condition(ConditionPaused, false, "Active", "reconciliation is active"),
+	); err != nil {
 		r.EventRecorder.Eventf(&svcLatest, corev1.EventTypeWarning, "StatusPatchFailed", "patch Service status: %v", err)
-		return ctrl.Result{Requeue: true}, nil
+		return ctrl.Result{Requeue: true}, "StatusPatchFailed", nil
+	}
+	if partial != nil {
+		r.EventRecorder.Eventf(&svcLatest, corev1.EventTypeWarning, "PartialSync", "OPNsense ApplyNATRules: %v", partial)
+		return ctrl.Result{Requeue: true}, "PartialSync", nil
 	}
 	r.EventRecorder.Eventf(&svcLatest, corev1.EventTypeNormal, "Synced", "assigned VIP %s and synced NAT rules to OPNsense", state.VIP)
 	logger.Info("Synced NAT and status for Service", "key", key)
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: r.DriftCheckInterval}, "Synced", nil
 }
 
-func (r *Reconciler) isOurService(svc *corev1.Service) bool {
-	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-		return false
+// allocate calls VIPAlloc.Allocate in a child span of ctx.
+func (r *Reconciler) allocate(ctx context.Context, key string, req config.VIPRequest) (string, error) {
+	_, span := tracer.Start(ctx, "VIPAllocator.Allocate", trace.WithAttributes(
+		attribute.String("opnsense_lb.vip_pool", req.Pool), attribute.String("opnsense_lb.requested_vip", req.IP)))
+	defer span.End()
+	vip, err := r.VIPAlloc.Allocate(key, req)
+	span.SetAttributes(attribute.String(attrVIP, vip))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 	}
-	if svc.Spec.LoadBalancerClass == nil {
-		return false
+	return vip, err
+}
+
+// missingAliases returns the names in names that are not aliases on OPNsense.
+func (r *Reconciler) missingAliases(ctx context.Context, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	existing, err := r.OPNsense.ListAliases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range names {
+		if !slices.ContainsFunc(existing, func(a opnsense.Alias) bool { return a.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// transitionFilterRules returns the filter rules of owner while its NAT rules change to desired:
+// those desired needs, plus those the owned NAT rules still on OPNsense need.
+func (r *Reconciler) transitionFilterRules(ctx context.Context, state *DesiredState, desired []opnsense.NATRule,
+	owner opnsense.Owner) ([]opnsense.FilterRule, error) {
+	all, err := r.OPNsense.ListNATRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var current []opnsense.NATRule
+	for _, rule := range all {
+		if tag, ok := opnsense.ParseTag(rule.Description); ok && tag.OwnedBy(owner) && !rule.Disabled {
+			rule.PortName = tag.Port
+			current = append(current, rule)
+		}
+	}
+	out := desiredFilterRules(state, desired, owner, r.PassRules)
+	for _, f := range desiredFilterRules(state, current, owner, r.PassRules) {
+		if !slices.ContainsFunc(out, func(o opnsense.FilterRule) bool {
+			return o.Action == f.Action && strings.EqualFold(o.Protocol, f.Protocol) && o.SourceNet == f.SourceNet &&
+				o.SourceNot == f.SourceNot && o.DestinationIP == f.DestinationIP && o.DestinationPort == f.DestinationPort &&
+				cmp.Or(o.Interface, opnsense.DefaultInterface) == cmp.Or(f.Interface, opnsense.DefaultInterface)
+		}) {
+			out = append(out, f)
+		}
 	}
-	return *svc.Spec.LoadBalancerClass == r.LoadBalancerClass
+	return out, nil
+}
+
+// release calls VIPAlloc.Release in a child span of ctx.
+func (r *Reconciler) release(ctx context.Context, key string) {
+	_, span := tracer.Start(ctx, "VIPAllocator.Release")
+	defer span.End()
+	r.VIPAlloc.Release(key)
+}
+
+// nodeInternalIP returns the node's first InternalIP address.
+func nodeInternalIP(node *corev1.Node) (string, bool) {
+	for _, a := range node.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP {
+			return a.Address, true
+		}
+	}
+	return "", false
+}
+
+// SetInterface changes the interface of Services without AnnotationInterface. Call Resync to
+// move the rules of existing Services.
+func (r *Reconciler) SetInterface(iface string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Interface = iface
+}
+
+func (r *Reconciler) defaultInterface() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Interface
+}
+
+func (r *Reconciler) isOurService(svc *corev1.Service) bool {
+	return isLoadBalancerOfClass(svc, r.LoadBalancerClass)
+}
+
+// identity returns the opnsense.Identity this controller tags firewall objects with.
+func (r *Reconciler) identity() opnsense.Identity {
+	return opnsense.Identity{ManagedBy: r.ManagedBy, ClusterID: r.ClusterID, AdoptUnclustered: r.AdoptUnclustered}
+}
+
+// owner returns the opnsense.Owner that tags the firewall objects of the Service namespace/name.
+func (r *Reconciler) owner(namespace, name string, uid types.UID) opnsense.Owner {
+	return opnsense.Owner{Identity: r.identity(), Namespace: namespace, Name: name, UID: string(uid)}
 }
 
 // addFinalizerIfMissing adds r.FinalizerName to svc.Finalizers if not present,
@@ -200,28 +566,137 @@ func (r *Reconciler) removeFinalizer(ctx context.Context, svc *corev1.Service) e
 	return r.Client.Patch(ctx, modified, client.MergeFrom(svc))
 }
 
-// clearServiceStatus re-fetches the Service and sets status.loadBalancer.ingress to [].
-func (r *Reconciler) clearServiceStatus(ctx context.Context, nn types.NamespacedName) {
+// setDegraded re-fetches the Service and records a transient OPNsense failure in its conditions.
+// status.loadBalancer.ingress is left untouched: the rules already on the firewall keep serving
+// traffic, and clearing the ingress would make consumers such as external-dns drop records.
+// Rejected API credentials are reported as FirewallReachable reason AuthenticationFailed.
+func (r *Reconciler) setDegraded(ctx context.Context, nn types.NamespacedName, reason string, cause error) {
 	var latest corev1.Service
 	if err := r.Client.Get(ctx, nn, &latest); err != nil {
 		return
 	}
-	_ = UpdateServiceLoadBalancerIngress(ctx, r.Client, &latest, "")
+	reachableReason := reason
+	if opnsense.IsAuthError(cause) {
+		reachableReason = "AuthenticationFailed"
+	}
+	_ = SetServiceConditions(ctx, r.Client, &latest,
+		condition(ConditionDegraded, true, reason, fmt.Sprintf("keeping last-known-good status: %v", cause)),
+		condition(ConditionFirewallReachable, false, reachableReason, cause.Error()),
+		condition(ConditionLoadBalancerSynced, false, reason, cause.Error()),
+	)
+}
+
+// rejectOptions reports invalid opnsense.org/ annotations on svc with a Warning Event and the
+// AnnotationsValid condition. OPNsense is not touched, so the firewall and
+// status.loadBalancer.ingress keep the last valid configuration; the Service is reconciled
+// again when it is edited.
+func (r *Reconciler) rejectOptions(ctx context.Context, svc *corev1.Service, err error) (ctrl.Result, string, error) {
+	const reason = "InvalidAnnotations"
+	r.EventRecorder.Eventf(svc, corev1.EventTypeWarning, reason, "%v", err)
+	var latest corev1.Service
+	if getErr := r.Client.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, &latest); getErr != nil {
+		return ctrl.Result{}, reason, getErr
+	}
+	if err := SetServiceConditions(ctx, r.Client, &latest,
+		condition(ConditionAnnotationsValid, false, reason, err.Error()),
+		condition(ConditionLoadBalancerSynced, false, reason, "waiting for valid opnsense.org/ annotations"),
+	); err != nil {
+		return ctrl.Result{}, reason, err
+	}
+	return ctrl.Result{}, reason, nil
+}
+
+// clearServiceStatus re-fetches the Service, sets status.loadBalancer.ingress to [] and sets conds.
+// It is used only when the VIP is released or the desired state is invalid.
+func (r *Reconciler) clearServiceStatus(ctx context.Context, nn types.NamespacedName, conds ...metav1.Condition) {
+	var latest corev1.Service
+	if err := r.Client.Get(ctx, nn, &latest); err != nil {
+		return
+	}
+	_ = UpdateServiceLoadBalancerStatus(ctx, r.Client, &latest, []corev1.LoadBalancerIngress{}, conds...)
 }
 
-// cleanup removes this service's NAT rules from OPNsense, releases the VIP (for pool), and releases the allocator key.
-// It does not remove the finalizer; the caller removes it when handling delete. Cleanup is idempotent.
-func (r *Reconciler) cleanup(ctx context.Context, key string) {
+// condition builds a metav1.Condition; ObservedGeneration is stamped by the status helpers.
+func condition(condType string, status bool, reason, message string) metav1.Condition {
+	c := metav1.Condition{Type: condType, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+	if status {
+		c.Status = metav1.ConditionTrue
+	}
+	return c
+}
+
+// finalize runs cleanup for svc and, once OPNsense has confirmed removal, clears the Service
+// status and removes our finalizer. While cleanup fails the finalizer is kept and the key is
+// requeued with backoff, unless the Service carries AnnotationForceDelete.
+func (r *Reconciler) finalize(ctx context.Context, svc *corev1.Service, key string) (ctrl.Result, string, error) {
+	if !slices.Contains(svc.Finalizers, r.FinalizerName) {
+		return ctrl.Result{}, "NotManaged", nil
+	}
+	if pauseReason, paused := r.pauseReason(svc); paused {
+		return r.deferPaused(ctx, svc, pauseReason)
+	}
+	if _, dryRun := r.OPNsense.(*opnsense.DryRun); dryRun {
+		return r.deferPlanned(ctx, svc, key)
+	}
+	reason := "CleanedUp"
+	if err := r.cleanup(ctx, r.owner(svc.Namespace, svc.Name, svc.UID)); err != nil {
+		if svc.Annotations[AnnotationForceDelete] != "true" {
+			r.EventRecorder.Eventf(svc, corev1.EventTypeWarning, "CleanupFailed",
+				"waiting for OPNsense to confirm removal of NAT rules and VIP, will retry: %v", err)
+			return ctrl.Result{Requeue: true}, "CleanupFailed", nil
+		}
+		reason = "CleanupForced"
+		log.FromContext(ctx).Error(err, "Forcing finalizer removal after failed cleanup", "key", key)
+		r.EventRecorder.Eventf(svc, corev1.EventTypeWarning, "CleanupForced",
+			"%s is set; removing finalizer although OPNsense cleanup failed: %v", AnnotationForceDelete, err)
+		r.release(ctx, key)
+		r.forget(key)
+	} else {
+		r.EventRecorder.Eventf(svc, corev1.EventTypeNormal, "CleanedUp", "removed NAT rules and VIP from OPNsense")
+	}
+	var latest corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, &latest); err == nil {
+		_ = ClearServiceLoadBalancerStatus(ctx, r.Client, &latest)
+	}
+	if err := r.removeFinalizer(ctx, svc); err != nil {
+		return ctrl.Result{}, reason, err
+	}
+	return ctrl.Result{}, reason, nil
+}
+
+// cleanup removes this service's NAT rules, filter rules and aliases from OPNsense, removes the VIP
+// (for pool), and releases the
+// allocator key. The allocator key is released only after OPNsense confirmed both removals, so a VIP
+// that may still be live is never handed to another Service. It does not remove the finalizer.
+// Cleanup is idempotent.
+func (r *Reconciler) cleanup(ctx context.Context, owner opnsense.Owner) error {
 	logger := log.FromContext(ctx)
+	key := owner.ServiceKey()
 	logger.Info("Cleaning up NAT/VIP for key", "key", key)
-	vip := r.VIPAlloc.GetVIP(key)
-	if err := r.OPNsense.ApplyNATRules(ctx, nil, r.ManagedBy, key); err != nil {
-		logger.Error(err, "Cleanup ApplyNATRules failed", "key", key)
+	if err := r.removeOwned(ctx, owner, r.VIPAlloc.GetVIP(key)); err != nil {
+		return err
+	}
+	r.release(ctx, key)
+	r.forget(key)
+	return nil
+}
+
+// removeOwned removes the NAT rules, filter rules and aliases of owner and, unless it is empty,
+// vip from OPNsense.
+func (r *Reconciler) removeOwned(ctx context.Context, owner opnsense.Owner, vip string) error {
+	if err := r.OPNsense.ApplyNATRules(ctx, nil, owner); err != nil {
+		return fmt.Errorf("remove NAT rules: %w", err)
+	}
+	if err := r.OPNsense.ApplyFilterRules(ctx, nil, owner); err != nil {
+		return fmt.Errorf("remove filter rules: %w", err)
+	}
+	if err := r.OPNsense.RemoveAliases(ctx, owner, nil); err != nil {
+		return fmt.Errorf("remove aliases: %w", err)
 	}
 	if vip != "" {
-		if err := r.OPNsense.RemoveVIP(ctx, vip); err != nil {
-			logger.Error(err, "Cleanup RemoveVIP failed", "key", key, "vip", vip)
+		if err := r.OPNsense.RemoveVIP(ctx, vip, owner.Identity); err != nil {
+			return fmt.Errorf("remove VIP %s: %w", vip, err)
 		}
 	}
-	r.VIPAlloc.Release(key)
+	return nil
 }
@@ -0,0 +1,1016 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+const (
+	testClass     = "opnsense.org/opnsense-lb"
+	testManagedBy = "opnsense-lb-controller"
+	testFinalizer = "opnsense.org/opnsense-lb"
+)
+
+var testIdentity = opnsense.Identity{ManagedBy: testManagedBy}
+
+// newTestReconciler returns a Reconciler backed by a fake client seeded with objs,
+// a FakeOPNsense and a pool allocator over pool.
+func newTestReconciler(t *testing.T, pool []string, objs ...client.Object) (*Reconciler, *FakeOPNsense, *record.FakeRecorder) {
+	t.Helper()
+	c := fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&corev1.Service{}).
+		WithIndex(&corev1.Endpoints{}, endpointsNodeNameField, endpointsNodeNames). //nolint:staticcheck // SA1019
+		Build()
+	mock := NewFakeOPNsense()
+	recorder := record.NewFakeRecorder(100)
+	vipAlloc := config.NewVIPAllocator(&config.Config{VIPPool: pool})
+	r := NewReconciler(c, recorder, mock, vipAlloc, testClass, testManagedBy, testFinalizer)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	return r, mock, recorder
+}
+
+func reconcileKey(ctx context.Context, t *testing.T, r *Reconciler, ns, name string) ctrl.Result {
+	t.Helper()
+	res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: ns, Name: name}})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	return res
+}
+
+// testOwner returns the owner the test reconciler tags the firewall objects of key with.
+func testOwner(key string) opnsense.Owner {
+	ns, name, _ := strings.Cut(key, "/")
+	return opnsense.Owner{Identity: testIdentity, Namespace: ns, Name: name}
+}
+
+func TestReconcile_TypeChangeCleansUp(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:  "default",
+			Name:       "web",
+			Finalizers: []string{testFinalizer},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "192.0.2.1"}}},
+		},
+	}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, svc)
+	key := "default/web"
+	vip, _ := r.VIPAlloc.Allocate(key, config.VIPRequest{})
+	_ = mock.EnsureVIP(ctx, vip, "", testIdentity)
+	_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, testOwner(key))
+
+	reconcileKey(ctx, t, r, "default", "web")
+
+	if rules := mock.NATRulesFor(key); len(rules) != 0 {
+		t.Errorf("NAT rules: got %d, want 0 after type change", len(rules))
+	}
+	if slices.Contains(mock.VIPs(), vip) {
+		t.Errorf("VIP %s still present after type change", vip)
+	}
+	var updated corev1.Service
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &updated); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if slices.Contains(updated.Finalizers, testFinalizer) {
+		t.Error("finalizer still present after type change")
+	}
+	if len(updated.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("LoadBalancer.Ingress: got %v, want empty", updated.Status.LoadBalancer.Ingress)
+	}
+}
+
+func TestReconcile_ForeignServiceIsIgnored(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, svc)
+	_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, testOwner("default/web"))
+
+	reconcileKey(ctx, t, r, "default", "web")
+
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 {
+		t.Errorf("NAT rules: got %d, want 1 (foreign Service without finalizer must not be touched)", len(rules))
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("unexpected event for foreign Service: %s", <-recorder.Events)
+	}
+}
+
+func deletingService(annotations map[string]string) *corev1.Service {
+	now := metav1.Now()
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "web",
+			Finalizers:        []string{testFinalizer},
+			DeletionTimestamp: &now,
+			Annotations:       annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: ptrString(testClass),
+			Ports:             []corev1.ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+}
+
+func TestReconcile_DeleteKeepsFinalizerWhileCleanupFails(t *testing.T) {
+	ctx := context.Background()
+	svc := deletingService(nil)
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, svc)
+	key := "default/web"
+	vip, _ := r.VIPAlloc.Allocate(key, config.VIPRequest{})
+	_ = mock.EnsureVIP(ctx, vip, "", testIdentity)
+	_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP"}}, testOwner(key))
+	mock.SetError(errors.New("connection refused"))
+
+	res := reconcileKey(ctx, t, r, "default", "web")
+	if !res.Requeue { //nolint:staticcheck // SA1019: Requeue is what Reconcile returns
+		t.Error("expected requeue while cleanup fails")
+	}
+	var updated corev1.Service
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &updated); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if !slices.Contains(updated.Finalizers, testFinalizer) {
+		t.Error("finalizer removed although OPNsense cleanup failed")
+	}
+	if got := r.VIPAlloc.GetVIP(key); got != vip {
+		t.Errorf("VIP released for reuse while still live: GetVIP = %q, want %q", got, vip)
+	}
+	if ev := <-recorder.Events; !strings.Contains(ev, "CleanupFailed") {
+		t.Errorf("event: got %q, want CleanupFailed", ev)
+	}
+
+	mock.SetError(nil)
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &updated); !apierrors.IsNotFound(err) {
+		t.Errorf("expected Service to be gone after successful cleanup, Get returned %v", err)
+	}
+	if rules := mock.NATRulesFor(key); len(rules) != 0 {
+		t.Errorf("NAT rules: got %d, want 0", len(rules))
+	}
+	if r.VIPAlloc.GetVIP(key) != "" {
+		t.Error("VIP not released after successful cleanup")
+	}
+}
+
+func TestReconcile_ForceDeleteRemovesFinalizer(t *testing.T) {
+	ctx := context.Background()
+	svc := deletingService(map[string]string{AnnotationForceDelete: "true"})
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, svc)
+	key := "default/web"
+	r.VIPAlloc.Allocate(key, config.VIPRequest{})
+	mock.SetError(errors.New("no route to host"))
+
+	reconcileKey(ctx, t, r, "default", "web")
+
+	var updated corev1.Service
+	if err := r.Client.Get(ctx, client.ObjectKeyFromObject(svc), &updated); !apierrors.IsNotFound(err) {
+		t.Errorf("expected Service to be gone after forced delete, Get returned %v", err)
+	}
+	if ev := <-recorder.Events; !strings.Contains(ev, "CleanupForced") {
+		t.Errorf("event: got %q, want CleanupForced", ev)
+	}
+}
+
+// syncedService returns a LoadBalancer Service of our class with one port, plus the Endpoints
+// and Node backing it.
+func syncedService() []client.Object {
+	nodeName := "node-1"
+	return []client.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: corev1.ServiceSpec{
+				Type:              corev1.ServiceTypeLoadBalancer,
+				LoadBalancerClass: ptrString(testClass),
+				Ports:             []corev1.ServicePort{{Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+			},
+		},
+		&corev1.Endpoints{ //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Subsets: []corev1.EndpointSubset{{ //nolint:staticcheck // SA1019
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1", NodeName: &nodeName}},
+			}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "192.168.1.10"}},
+			},
+		},
+	}
+}
+
+// drainEvents returns all events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var out []string
+	for {
+		select {
+		case ev := <-recorder.Events:
+			out = append(out, ev)
+		default:
+			return out
+		}
+	}
+}
+
+func hasEvent(events []string, reason string) bool {
+	return slices.ContainsFunc(events, func(ev string) bool { return strings.Contains(ev, " "+reason+" ") })
+}
+
+// advance moves r's clock forward by d.
+func advance(r *Reconciler, d time.Duration) {
+	now := r.now().Add(d)
+	r.now = func() time.Time { return now }
+}
+
+// setEndpointIPs replaces the addresses of the default/web Endpoints, all on node-1.
+func setEndpointIPs(ctx context.Context, t *testing.T, r *Reconciler, ips ...string) {
+	t.Helper()
+	var ep corev1.Endpoints //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &ep); err != nil {
+		t.Fatalf("Get Endpoints: %v", err)
+	}
+	nodeName := "node-1"
+	ep.Subsets[0].Addresses = nil
+	for _, ip := range ips {
+		ep.Subsets[0].Addresses = append(ep.Subsets[0].Addresses, corev1.EndpointAddress{IP: ip, NodeName: &nodeName})
+	}
+	if err := r.Client.Update(ctx, &ep); err != nil {
+		t.Fatalf("Update Endpoints: %v", err)
+	}
+}
+
+func TestReconcile_DriftDetection(t *testing.T) {
+	ctx := context.Background()
+	key := "default/web"
+
+	t.Run("repair re-applies manually disabled rules", func(t *testing.T) {
+		r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+		r.DriftCheckInterval = time.Minute
+		reconcileKey(ctx, t, r, "default", "web")
+		reconcileKey(ctx, t, r, "default", "web")
+		drainEvents(recorder)
+
+		mock.DisableNATRules(key)
+		advance(r, 2*time.Minute)
+		reconcileKey(ctx, t, r, "default", "web")
+
+		if !hasEvent(drainEvents(recorder), "DriftDetected") {
+			t.Error("expected DriftDetected event")
+		}
+		rules := mock.NATRulesFor(key)
+		if len(rules) != 1 || rules[0].Disabled {
+			t.Errorf("expected 1 enabled rule after repair, got %+v", rules)
+		}
+	})
+
+	t.Run("report leaves the firewall alone", func(t *testing.T) {
+		r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+		r.DriftPolicy = DriftPolicyReport
+		r.DriftCheckInterval = time.Minute
+		reconcileKey(ctx, t, r, "default", "web")
+		reconcileKey(ctx, t, r, "default", "web")
+		drainEvents(recorder)
+
+		mock.DisableNATRules(key)
+		advance(r, 2*time.Minute)
+		reconcileKey(ctx, t, r, "default", "web")
+
+		if !hasEvent(drainEvents(recorder), "DriftDetected") {
+			t.Error("expected DriftDetected event")
+		}
+		rules := mock.NATRulesFor(key)
+		if len(rules) != 1 || !rules[0].Disabled {
+			t.Errorf("expected the disabled rule to be left in place, got %+v", rules)
+		}
+	})
+
+	t.Run("no drift without manual edits", func(t *testing.T) {
+		r, _, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+		r.DriftCheckInterval = time.Minute
+		reconcileKey(ctx, t, r, "default", "web")
+		reconcileKey(ctx, t, r, "default", "web")
+		drainEvents(recorder)
+		advance(r, 2*time.Minute)
+		reconcileKey(ctx, t, r, "default", "web")
+		if events := drainEvents(recorder); len(events) != 0 {
+			t.Errorf("unexpected events after a clean drift check: %v", events)
+		}
+	})
+}
+
+func TestReconcile_OPNsenseOutageKeepsIngress(t *testing.T) {
+	ctx := context.Background()
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	drainEvents(recorder)
+
+	mock.SetError(errors.New("context deadline exceeded"))
+	setEndpointIPs(ctx, t, r, "10.0.0.1", "10.0.0.2")
+	res := reconcileKey(ctx, t, r, "default", "web")
+	if !res.Requeue { //nolint:staticcheck // SA1019: Requeue is what Reconcile returns
+		t.Error("expected requeue after OPNsense failure")
+	}
+
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if len(svc.Status.LoadBalancer.Ingress) != 1 || svc.Status.LoadBalancer.Ingress[0].IP != "192.0.2.1" {
+		t.Errorf("LoadBalancer.Ingress: got %v, want last-known-good 192.0.2.1", svc.Status.LoadBalancer.Ingress)
+	}
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, ConditionDegraded) {
+		t.Errorf("expected %s condition to be True, got %v", ConditionDegraded, svc.Status.Conditions)
+	}
+	if !hasEvent(drainEvents(recorder), "EnsureVIPFailed") {
+		t.Error("expected EnsureVIPFailed event")
+	}
+
+	mock.SetError(nil)
+	reconcileKey(ctx, t, r, "default", "web")
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if !meta.IsStatusConditionFalse(svc.Status.Conditions, ConditionDegraded) {
+		t.Errorf("expected %s condition to be False after recovery, got %v", ConditionDegraded, svc.Status.Conditions)
+	}
+}
+
+func TestReconcile_AuthenticationFailure(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	mock.SetError(&opnsense.APIError{Endpoint: "interfaces/vip_settings addItem", StatusCode: 401, Status: "401 Unauthorized"})
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	cond := meta.FindStatusCondition(svc.Status.Conditions, ConditionFirewallReachable)
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "AuthenticationFailed" {
+		t.Errorf("%s condition: got %+v, want False with reason AuthenticationFailed", ConditionFirewallReachable, cond)
+	}
+}
+
+func TestReconcile_PartialSyncReportsPortErrors(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	objs[0].(*corev1.Service).Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+		{Name: "dns", Port: 53, NodePort: 30053, Protocol: corev1.ProtocolUDP},
+	}
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	mock.RejectNATRulePort(53)
+	reconcileKey(ctx, t, r, "default", "web")
+	res := reconcileKey(ctx, t, r, "default", "web")
+	if !res.Requeue { //nolint:staticcheck // SA1019: Requeue is what Reconcile returns
+		t.Error("expected requeue after partial sync")
+	}
+
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if len(svc.Status.LoadBalancer.Ingress) != 1 {
+		t.Fatalf("LoadBalancer.Ingress: got %v, want one entry", svc.Status.LoadBalancer.Ingress)
+	}
+	ing := svc.Status.LoadBalancer.Ingress[0]
+	if ing.IPMode == nil || *ing.IPMode != corev1.LoadBalancerIPModeVIP {
+		t.Errorf("IPMode: got %v, want VIP", ing.IPMode)
+	}
+	if len(ing.Ports) != 2 {
+		t.Fatalf("Ports: got %v, want 2", ing.Ports)
+	}
+	for _, p := range ing.Ports {
+		switch p.Port {
+		case 80:
+			if p.Error != nil {
+				t.Errorf("TCP/80 error: got %q, want none", *p.Error)
+			}
+		case 53:
+			if p.Protocol != corev1.ProtocolUDP || p.Error == nil || *p.Error != PortErrorNATRuleFailed {
+				t.Errorf("UDP/53: got %+v, want protocol UDP with error %s", p, PortErrorNATRuleFailed)
+			}
+		}
+	}
+	synced := meta.FindStatusCondition(svc.Status.Conditions, ConditionLoadBalancerSynced)
+	if synced == nil || synced.Status != metav1.ConditionFalse || synced.Reason != "PartiallySynced" {
+		t.Errorf("%s: got %+v, want False/PartiallySynced", ConditionLoadBalancerSynced, synced)
+	}
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, ConditionVIPAssigned) {
+		t.Errorf("expected %s condition to be True", ConditionVIPAssigned)
+	}
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, ConditionFirewallReachable) {
+		t.Errorf("expected %s condition to be True", ConditionFirewallReachable)
+	}
+	if !hasEvent(drainEvents(recorder), "PartialSync") {
+		t.Error("expected PartialSync event")
+	}
+}
+
+func TestReconcile_MigratesLegacyRulesAndSparesPrefixNames(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	mock.AddNATRule(opnsense.NATRule{ExternalPort: 80, Protocol: "TCP", TargetIP: "192.168.1.10", TargetPort: 30080,
+		Description: testManagedBy + " default/web 192.0.2.1"})
+	_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 8080, Protocol: "TCP"}}, testOwner("default/web-admin"))
+	_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 9443, Protocol: "TCP"}}, testOwner("default/webhook"))
+
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	rules := mock.NATRulesFor("default/web")
+	if len(rules) != 1 {
+		t.Fatalf("default/web rules: got %v, want 1", rules)
+	}
+	if tag, ok := opnsense.ParseTag(rules[0].Description); !ok || tag.Legacy {
+		t.Errorf("default/web rule description %q not migrated to the current tag format", rules[0].Description)
+	}
+	for _, key := range []string{"default/web-admin", "default/webhook"} {
+		if n := len(mock.NATRulesFor(key)); n != 1 {
+			t.Errorf("%s rules: got %d, want 1", key, n)
+		}
+	}
+}
+
+func TestReconcile_ClusterIDAdoptsUnclusteredObjectsOnlyWhenAsked(t *testing.T) {
+	ctx := context.Background()
+	for _, adopt := range []bool{false, true} {
+		t.Run(fmt.Sprintf("adopt=%v", adopt), func(t *testing.T) {
+			r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+			r.ClusterID = "prod"
+			r.AdoptUnclustered = adopt
+			// A cluster without an ID shares the firewall and has a Service of the same name.
+			unclustered := testOwner("default/web")
+			_ = mock.ApplyNATRules(ctx, []opnsense.NATRule{{ExternalPort: 80, Protocol: "TCP", TargetIP: "192.168.1.20",
+				TargetPort: 31080}}, unclustered)
+			_ = mock.ApplyFilterRules(ctx, []opnsense.FilterRule{{Protocol: "TCP", DestinationIP: "192.168.1.20",
+				DestinationPort: 31080}}, unclustered)
+
+			reconcileKey(ctx, t, r, "default", "web")
+			reconcileKey(ctx, t, r, "default", "web")
+
+			var natUnclustered, filterUnclustered int
+			for _, rule := range mock.NATRulesFor("default/web") {
+				if tag, _ := opnsense.ParseTag(rule.Description); tag.ClusterID == "" {
+					natUnclustered++
+				}
+			}
+			for _, rule := range mock.FilterRulesFor("default/web") {
+				if tag, _ := opnsense.ParseTag(rule.Description); tag.ClusterID == "" {
+					filterUnclustered++
+				}
+			}
+			want := 1
+			if adopt {
+				want = 0
+			}
+			if natUnclustered != want || filterUnclustered != want {
+				t.Errorf("rules without a cluster ID: got %d NAT and %d filter rules, want %d of each",
+					natUnclustered, filterUnclustered, want)
+			}
+			if n := len(mock.NATRulesFor("default/web")); n != want+1 {
+				t.Errorf("default/web NAT rules: got %d, want %d", n, want+1)
+			}
+		})
+	}
+}
+
+func TestReconcile_SkipsUnchangedState(t *testing.T) {
+	ctx := context.Background()
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	r.DriftCheckInterval = 5 * time.Minute
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	drainEvents(recorder)
+
+	calls := mock.Calls()
+	advance(r, time.Minute)
+	res := reconcileKey(ctx, t, r, "default", "web")
+	if n := mock.Calls() - calls; n != 0 {
+		t.Errorf("unchanged reconcile made %d OPNsense calls, want 0", n)
+	}
+	if res.RequeueAfter != 4*time.Minute {
+		t.Errorf("RequeueAfter: got %v, want the 4m left until the drift check", res.RequeueAfter)
+	}
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("unchanged reconcile emitted events: %v", events)
+	}
+
+	// A second backend on the same node resolves to the same node IP: still no change.
+	setEndpointIPs(ctx, t, r, "10.0.0.2")
+	reconcileKey(ctx, t, r, "default", "web")
+	if n := mock.Calls() - calls; n != 0 {
+		t.Errorf("reconcile with equivalent backends made %d OPNsense calls, want 0", n)
+	}
+
+	// The service port changes: apply and report once.
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	svc.Spec.Ports[0].Port = 8080
+	if err := r.Client.Update(ctx, &svc); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || rules[0].ExternalPort != 8080 {
+		t.Errorf("rules after port change: got %+v, want one rule for port 8080", rules)
+	}
+	if !hasEvent(drainEvents(recorder), "Synced") {
+		t.Error("expected Synced event after a real change")
+	}
+}
+
+// setAnnotations replaces the annotations of the default/web Service.
+func setAnnotations(ctx context.Context, t *testing.T, r *Reconciler, annotations map[string]string) {
+	t.Helper()
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	svc.Annotations = annotations
+	if err := r.Client.Update(ctx, &svc); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+}
+
+func TestReconcile_AppliesServiceOptions(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1", "192.0.2.2"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "192.0.2.1" {
+		t.Fatalf("initial VIP: got %q, want 192.0.2.1", vip)
+	}
+
+	setAnnotations(ctx, t, r, map[string]string{
+		AnnotationInterface:      "opt1",
+		AnnotationLoadBalancerIP: "192.0.2.2",
+		AnnotationLog:            "true",
+		AnnotationSourceRanges:   "198.51.100.0/24, 203.0.113.7/32",
+		AnnotationDescription:    "Public website",
+	})
+	reconcileKey(ctx, t, r, "default", "web")
+
+	rules := mock.NATRulesFor("default/web")
+	if len(rules) != 1 {
+		t.Fatalf("rules: got %+v, want 1", rules)
+	}
+	if rule := rules[0]; rule.Interface != "opt1" || !rule.Log || rule.Note != "Public website" {
+		t.Errorf("rule %+v: want interface opt1, logging and the note", rule)
+	}
+	aliases := mock.AliasesFor("default/web")
+	if len(aliases) != 1 || !slices.Equal(aliases[0].Content, []string{"198.51.100.0/24", "203.0.113.7/32"}) {
+		t.Errorf("aliases: got %+v, want one with both source ranges", aliases)
+	}
+	if vips := mock.VIPs(); !slices.Equal(vips, []string{"192.0.2.2"}) {
+		t.Errorf("VIPs: got %v, want only the requested 192.0.2.2", vips)
+	}
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	if len(svc.Status.LoadBalancer.Ingress) != 1 || svc.Status.LoadBalancer.Ingress[0].IP != "192.0.2.2" {
+		t.Errorf("LoadBalancer.Ingress: got %v, want 192.0.2.2", svc.Status.LoadBalancer.Ingress)
+	}
+	if !meta.IsStatusConditionTrue(svc.Status.Conditions, ConditionAnnotationsValid) {
+		t.Errorf("expected %s condition to be True", ConditionAnnotationsValid)
+	}
+}
+
+func TestReconcile_DefaultInterface(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	r.Interface = "opt2"
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || rules[0].Interface != "opt2" {
+		t.Errorf("rules: got %+v, want one on the default interface opt2", rules)
+	}
+
+	setAnnotations(ctx, t, r, map[string]string{AnnotationInterface: "opt1"})
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || rules[0].Interface != "opt1" {
+		t.Errorf("rules: got %+v, want one on the annotated interface opt1", rules)
+	}
+}
+
+func TestReconcile_InvalidAnnotationsLeaveFirewallUnchanged(t *testing.T) {
+	ctx := context.Background()
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	drainEvents(recorder)
+	before := mock.NATRulesFor("default/web")
+
+	for name, annotations := range map[string]map[string]string{
+		"invalid value": {AnnotationInterface: "opt1", AnnotationSourceRanges: "198.51.100.0/24,not-a-cidr"},
+		"unknown pool":  {AnnotationVIPPool: "dmz"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setAnnotations(ctx, t, r, annotations)
+			calls := mock.Calls()
+			reconcileKey(ctx, t, r, "default", "web")
+
+			if n := mock.Calls() - calls; n != 0 {
+				t.Errorf("made %d OPNsense calls, want 0", n)
+			}
+			if rules := mock.NATRulesFor("default/web"); !slices.Equal(rules, before) {
+				t.Errorf("rules changed: got %+v, want %+v", rules, before)
+			}
+			if !hasEvent(drainEvents(recorder), "InvalidAnnotations") {
+				t.Error("expected InvalidAnnotations event")
+			}
+			var svc corev1.Service
+			if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+				t.Fatalf("Get Service: %v", err)
+			}
+			valid := meta.FindStatusCondition(svc.Status.Conditions, ConditionAnnotationsValid)
+			if valid == nil || valid.Status != metav1.ConditionFalse || valid.Reason != "InvalidAnnotations" {
+				t.Errorf("%s: got %+v, want False/InvalidAnnotations", ConditionAnnotationsValid, valid)
+			}
+			if len(svc.Status.LoadBalancer.Ingress) != 1 || svc.Status.LoadBalancer.Ingress[0].IP != "192.0.2.1" {
+				t.Errorf("LoadBalancer.Ingress: got %v, want last-known-good 192.0.2.1", svc.Status.LoadBalancer.Ingress)
+			}
+		})
+	}
+}
+
+func TestReconcile_SourceRangesUseAliasAndFilterRule(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	svc := objs[0].(*corev1.Service)
+	svc.Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24", "198.51.100.7/32"}
+	svc.Annotations = map[string]string{AnnotationSourceRanges: "192.0.2.0/24"}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	r.OPNsense = passRuleAuditor{FakeOPNsense: mock, t: t}
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	alias := testOwner("default/web").AliasName(aliasSourceRanges)
+	aliases := mock.AliasesFor("default/web")
+	if len(aliases) != 1 || aliases[0].Name != alias ||
+		!slices.Equal(aliases[0].Content, []string{"198.51.100.7/32", "203.0.113.0/24"}) {
+		t.Fatalf("aliases: got %+v, want %s with the spec ranges (not the annotation)", aliases, alias)
+	}
+	rules := mock.NATRulesFor("default/web")
+	if len(rules) != 1 || rules[0].SourceNet != alias || !rules[0].NoAutoPass {
+		t.Errorf("NAT rules: got %+v, want one matching %s without auto-pass", rules, alias)
+	}
+	filters := mock.FilterRulesFor("default/web")
+	if len(filters) != 1 {
+		t.Fatalf("filter rules: got %+v, want one", filters)
+	}
+	want := opnsense.FilterRule{UUID: filters[0].UUID, Action: opnsense.ActionPass, Interface: opnsense.DefaultInterface, Protocol: "TCP",
+		SourceNet: alias, DestinationIP: "192.168.1.10", DestinationPort: 30080, Note: "Pass default/web TCP/80",
+		Description: filters[0].Description}
+	if filters[0] != want {
+		t.Errorf("filter rule: got %+v, want %+v", filters[0], want)
+	}
+
+	// Dropping the ranges opens the Service again and removes the alias.
+	var latest corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &latest); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	latest.Spec.LoadBalancerSourceRanges = nil
+	latest.Annotations = nil
+	if err := r.Client.Update(ctx, &latest); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if rules := mock.NATRulesFor("default/web"); len(rules) != 1 || rules[0].SourceNet != "" {
+		t.Errorf("NAT rules without ranges: got %+v, want one for any source", rules)
+	}
+	if filters := mock.FilterRulesFor("default/web"); len(filters) != 1 || filters[0].SourceNet != "" {
+		t.Errorf("filter rules without ranges: got %+v, want one for any source", filters)
+	}
+	if aliases := mock.AliasesFor("default/web"); len(aliases) != 0 {
+		t.Errorf("aliases after the ranges were removed: got %+v, want none", aliases)
+	}
+
+	// Restricting it again swaps the pass rule without a gap.
+	setAnnotations(ctx, t, r, map[string]string{AnnotationSourceRanges: "192.0.2.0/24"})
+	reconcileKey(ctx, t, r, "default", "web")
+	if filters := mock.FilterRulesFor("default/web"); len(filters) != 1 || filters[0].SourceNet != alias {
+		t.Errorf("filter rules with ranges again: got %+v, want one matching %s", filters, alias)
+	}
+}
+
+func TestReconcile_CleanupRemovesAliasesAndFilterRules(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	objs[0].(*corev1.Service).Spec.LoadBalancerSourceRanges = []string{"203.0.113.0/24"}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if len(mock.FilterRulesFor("default/web")) != 1 || len(mock.AliasesFor("default/web")) != 1 {
+		t.Fatal("expected a filter rule and an alias after sync")
+	}
+
+	if err := r.Client.Delete(ctx, objs[0]); err != nil {
+		t.Fatalf("Delete Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if n := len(mock.NATRulesFor("default/web")) + len(mock.FilterRulesFor("default/web")) +
+		len(mock.AliasesFor("default/web")); n != 0 {
+		t.Errorf("got %d objects left after cleanup, want 0", n)
+	}
+}
+
+func TestReconcile_PassRulesFollowNATRules(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	objs[0].(*corev1.Service).Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+		{Name: "dns", Port: 53, NodePort: 30053, Protocol: corev1.ProtocolUDP},
+	}
+	objs[0].(*corev1.Service).Annotations = map[string]string{AnnotationDescription: "Public website"}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	r.PassRules = PassRuleOptions{Log: true, Sequence: 500}
+	mock.RejectNATRulePort(53)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	for _, rule := range mock.NATRulesFor("default/web") {
+		if !rule.NoAutoPass {
+			t.Errorf("NAT rule %+v relies on the firewall's filter rule association", rule)
+		}
+	}
+	filters := mock.FilterRulesFor("default/web")
+	if len(filters) != 1 {
+		t.Fatalf("filter rules: got %+v, want one for the accepted TCP/80 NAT rule only", filters)
+	}
+	if f := filters[0]; f.DestinationPort != 30080 || !f.Log || f.Sequence != 500 || f.Note != "Pass default/web TCP/80: Public website" {
+		t.Errorf("filter rule: got %+v, want logged at sequence 500 with a readable note", f)
+	}
+}
+
+// passRuleAuditor fails the test when a change leaves a NAT rule on the FakeOPNsense without a
+// filter rule passing its traffic, or a rule matching a source alias that does not exist.
+type passRuleAuditor struct {
+	*FakeOPNsense
+	t *testing.T
+}
+
+func (a passRuleAuditor) ApplyNATRules(ctx context.Context, desired []opnsense.NATRule, owner opnsense.Owner) error {
+	err := a.FakeOPNsense.ApplyNATRules(ctx, desired, owner)
+	a.check("ApplyNATRules", owner)
+	return err
+}
+
+func (a passRuleAuditor) ApplyFilterRules(ctx context.Context, desired []opnsense.FilterRule, owner opnsense.Owner) error {
+	err := a.FakeOPNsense.ApplyFilterRules(ctx, desired, owner)
+	a.check("ApplyFilterRules", owner)
+	return err
+}
+
+func (a passRuleAuditor) RemoveAliases(ctx context.Context, owner opnsense.Owner, keep []string) error {
+	err := a.FakeOPNsense.RemoveAliases(ctx, owner, keep)
+	a.check("RemoveAliases", owner)
+	return err
+}
+
+func (a passRuleAuditor) check(call string, owner opnsense.Owner) {
+	filters := a.FilterRulesFor(owner.ServiceKey())
+	aliases := a.AliasesFor(owner.ServiceKey())
+	sources := make(map[string]bool)
+	for _, f := range filters {
+		sources[f.SourceNet] = true
+	}
+	for _, n := range a.NATRulesFor(owner.ServiceKey()) {
+		sources[n.SourceNet] = true
+		if !slices.ContainsFunc(filters, func(f opnsense.FilterRule) bool {
+			return f.Action == opnsense.ActionPass && f.SourceNet == n.SourceNet && f.DestinationIP == n.TargetIP &&
+				f.DestinationPort == n.TargetPort
+		}) {
+			a.t.Errorf("after %s: NAT rule %+v has no pass rule; filter rules %+v", call, n, filters)
+		}
+	}
+	for source := range sources {
+		if strings.HasPrefix(source, "lbc_") && !slices.ContainsFunc(aliases, func(al opnsense.Alias) bool { return al.Name == source }) {
+			a.t.Errorf("after %s: rules match source %s, which is not an alias", call, source)
+		}
+	}
+}
+
+func TestReconcile_NATRulesNeverLackPassRules(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	objs[0].(*corev1.Service).Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+		{Name: "dns", Port: 53, NodePort: 30053, Protocol: corev1.ProtocolUDP},
+	}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	r.OPNsense = passRuleAuditor{FakeOPNsense: mock, t: t}
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	before := mock.FilterRulesFor("default/web")
+	if len(before) != 2 {
+		t.Fatalf("filter rules: got %+v, want two", before)
+	}
+
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	svc.Spec.Ports[1].NodePort = 30054
+	if err := r.Client.Update(ctx, &svc); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	after := mock.FilterRulesFor("default/web")
+	if len(after) != 2 || !slices.Contains(after, before[0]) || after[1].DestinationPort != 30054 {
+		t.Errorf("filter rules after the NodePort change: got %+v, want %+v kept and one for 30054", after, before[0])
+	}
+}
+
+func TestReconcile_CountriesAndBlockListsAddBlockRules(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	objs[0].(*corev1.Service).Annotations = map[string]string{
+		AnnotationAllowCountries: "DE,AT",
+		AnnotationBlockLists:     "https://lists.example.com/drop.txt,spamhaus_edrop",
+	}
+	r, mock, recorder := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+
+	// Block rules need a lower sequence than the pass rules.
+	r.PassRules = PassRuleOptions{Sequence: 1}
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if events := drainEvents(recorder); !hasEvent(events, "InvalidAnnotations") || len(mock.AliasesFor("default/web")) != 0 {
+		t.Fatalf("pass rule sequence 1: got events %v, want InvalidAnnotations and no aliases", events)
+	}
+
+	// Referenced aliases must exist before anything is changed.
+	r.PassRules = PassRuleOptions{Sequence: 500}
+	reconcileKey(ctx, t, r, "default", "web")
+	if events := drainEvents(recorder); !hasEvent(events, "InvalidAnnotations") || !strings.Contains(events[0], "spamhaus_edrop") ||
+		len(mock.VIPs()) != 0 || len(mock.AliasesFor("default/web")) != 0 {
+		t.Fatalf("missing alias: got events %v, VIPs %v; want InvalidAnnotations naming spamhaus_edrop and no changes",
+			events, mock.VIPs())
+	}
+	mock.AddAlias(opnsense.Alias{Name: "spamhaus_edrop", Type: "urltable", Description: "Spamhaus EDROP"})
+	reconcileKey(ctx, t, r, "default", "web")
+
+	owner := testOwner("default/web")
+	geo, block := owner.AliasName(aliasCountries), owner.AliasName(aliasBlockLists)
+	aliases := mock.AliasesFor("default/web")
+	slices.SortFunc(aliases, func(a, b opnsense.Alias) int { return strings.Compare(a.Type, b.Type) })
+	if len(aliases) != 2 || aliases[0].Name != geo || !slices.Equal(aliases[0].Content, []string{"AT", "DE"}) ||
+		aliases[1].Name != block || aliases[1].Type != "urltable" {
+		t.Fatalf("aliases: got %+v, want a GeoIP alias %s and a URL table alias %s", aliases, geo, block)
+	}
+	var got []string
+	for _, f := range mock.FilterRulesFor("default/web") {
+		source := f.SourceNet
+		if f.SourceNot {
+			source = "!" + source
+		}
+		got = append(got, fmt.Sprintf("%s %s %d", f.Action, source, f.Sequence))
+	}
+	want := []string{"block " + block + " 499", "block spamhaus_edrop 499", "block !" + geo + " 499", "pass  500"}
+	if !slices.Equal(got, want) {
+		t.Errorf("filter rules: got %q, want %q", got, want)
+	}
+
+	// Removing the annotations removes the block rules and aliases.
+	var latest corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &latest); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	latest.Annotations = nil
+	if err := r.Client.Update(ctx, &latest); err != nil {
+		t.Fatalf("Update Service: %v", err)
+	}
+	reconcileKey(ctx, t, r, "default", "web")
+	if n, m := len(mock.FilterRulesFor("default/web")), len(mock.AliasesFor("default/web")); n != 1 || m != 0 {
+		t.Errorf("got %d filter rules and %d aliases, want only the pass rule", n, m)
+	}
+}
+
+func TestReconcile_RecordsMetrics(t *testing.T) {
+	ctx := context.Background()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1", "192.0.2.2"}, syncedService()...)
+	synced := testutil.ToFloat64(reconcileTotal.WithLabelValues("success", "Synced"))
+	failed := testutil.ToFloat64(reconcileTotal.WithLabelValues("requeue", "EnsureVIPFailed"))
+
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("success", "Synced")); got != synced+1 {
+		t.Errorf("Synced reconciles: got %v, want %v", got, synced+1)
+	}
+	if got := testutil.ToFloat64(serviceRules.WithLabelValues("default/web", kindNATRule)); got != 1 {
+		t.Errorf("NAT rules of default/web: got %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(serviceLastSync.WithLabelValues("default/web")); got != float64(r.now().Unix()) {
+		t.Errorf("last sync of default/web: got %v, want %d", got, r.now().Unix())
+	}
+	if a, f := testutil.ToFloat64(poolVIPs.WithLabelValues("default", "allocated")),
+		testutil.ToFloat64(poolVIPs.WithLabelValues("default", "free")); a != 1 || f != 1 {
+		t.Errorf("default pool VIPs: got %v allocated and %v free, want 1 and 1", a, f)
+	}
+
+	mock.SetError(errors.New("connection refused"))
+	r.forget("default/web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if got := testutil.ToFloat64(reconcileTotal.WithLabelValues("requeue", "EnsureVIPFailed")); got != failed+1 {
+		t.Errorf("EnsureVIPFailed reconciles: got %v, want %v", got, failed+1)
+	}
+	if serviceLastSync.DeleteLabelValues("default/web") {
+		t.Error("last sync of default/web still reported after forget")
+	}
+}
+
+func TestReconcile_Traces(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	ctx := context.Background()
+	r, _, _ := newTestReconciler(t, []string{"192.0.2.1"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans.Ended() {
+		byName[span.Name()] = span
+	}
+	root, ok := byName["Reconcile"]
+	if !ok {
+		t.Fatalf("no Reconcile span in %v", slices.Collect(maps.Keys(byName)))
+	}
+	attrs := make(map[string]string)
+	for _, kv := range root.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs[attrService] != "default/web" || attrs[attrVIP] != "192.0.2.1" || attrs[attrNATRules] != "1" ||
+		attrs[attrFilterRules] != "1" || attrs[attrOutcome] != "Synced" {
+		t.Errorf("Reconcile span attributes: got %v", attrs)
+	}
+	for _, name := range []string{"ComputeDesiredState", "VIPAllocator.Allocate"} {
+		if span, ok := byName[name]; !ok || span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s: want a child span of Reconcile", name)
+		}
+	}
+}
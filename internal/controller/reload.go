@@ -0,0 +1,261 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+)
+
+// ConfigMapKey is the key of the config file in a config ConfigMap.
+const ConfigMapKey = "config.yaml"
+
+// DefaultConfigPollInterval is how often ConfigReloader checks its config file for changes.
+const DefaultConfigPollInterval = 10 * time.Second
+
+// ConfigReloader applies changes of the config file while the controller runs. The file is
+// either polled at Path, which catches the symlink swap of a mounted ConfigMap, or read from
+// the ConfigMap itself, which is then the object of the reload Events.
+//
+// Only settings marked reloadable in package config are applied: VIP pools, the default
+// interface, the global pause and the log level. A change to any other setting, an invalid
+// file, or new pools that drop a VIP assigned to a Service are refused with a Warning Event,
+// and the running configuration stays in use. Once changed pools, a new default interface or a
+// pause change are applied, every Service of our class is reconciled again.
+type ConfigReloader struct {
+	// Load builds the configuration from the content of the config file, e.g. Flags.LoadData.
+	Load       func(name string, data []byte) (*config.Config, error)
+	Reconciler *Reconciler
+	// SetLogLevel applies the logLevel setting; nil ignores it.
+	SetLogLevel func(level string)
+
+	// Path is the config file to poll every PollInterval.
+	Path         string
+	PollInterval time.Duration
+	// Clientset and ConfigMap select the ConfigMap to watch instead of Path.
+	Clientset kubernetes.Interface
+	ConfigMap types.NamespacedName
+	// Elected is closed once this replica leads (manager.Elected); Services are only resynced
+	// then, as the controller does not run on standby replicas. nil means always.
+	Elected <-chan struct{}
+
+	mu      sync.Mutex
+	current *config.Config
+}
+
+// NewConfigReloader returns a ConfigReloader for a controller started with current. Set Path or
+// Clientset and ConfigMap before adding it to the manager.
+func NewConfigReloader(
+	load func(name string, data []byte) (*config.Config, error),
+	current *config.Config,
+	r *Reconciler,
+	setLogLevel func(level string),
+) *ConfigReloader {
+	return &ConfigReloader{
+		Load:         load,
+		Reconciler:   r,
+		SetLogLevel:  setLogLevel,
+		PollInterval: DefaultConfigPollInterval,
+		current:      current,
+	}
+}
+
+// Start watches the config file until ctx is done. Implements manager.Runnable.
+func (c *ConfigReloader) Start(ctx context.Context) error {
+	if c.Clientset != nil {
+		return c.watchConfigMap(ctx)
+	}
+	return c.pollFile(ctx)
+}
+
+// NeedLeaderElection returns false: standby replicas keep their allocator and settings current
+// so they take over with the latest configuration. Implements manager.LeaderElectionRunnable.
+func (c *ConfigReloader) NeedLeaderElection() bool { return false }
+
+func (c *ConfigReloader) pollFile(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("config").WithValues("path", c.Path)
+	last, _ := os.ReadFile(c.Path)
+	ticker := time.NewTicker(c.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(c.Path)
+		if err != nil {
+			logger.Error(err, "keeping the current configuration")
+			continue
+		}
+		if !bytes.Equal(data, last) {
+			last = data
+			c.Apply(ctx, c.Path, data, nil)
+		}
+	}
+}
+
+func (c *ConfigReloader) watchConfigMap(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("config").WithValues("configMap", c.ConfigMap.String())
+	factory := informers.NewSharedInformerFactoryWithOptions(c.Clientset, 0,
+		informers.WithNamespace(c.ConfigMap.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", c.ConfigMap.Name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	var last string
+	onChange := func(obj any) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Data[ConfigMapKey] == last {
+			return
+		}
+		last = cm.Data[ConfigMapKey]
+		c.Apply(ctx, "configmap "+c.ConfigMap.String(), []byte(last), cm)
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    onChange,
+		UpdateFunc: func(_, obj any) { onChange(obj) },
+		DeleteFunc: func(any) {
+			logger.Info("ConfigMap deleted; keeping the current configuration")
+		},
+	}); err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !toolscache.WaitForCacheSync(ctx.Done(), informer.HasSynced) && ctx.Err() == nil {
+		return errors.New("configmap informer did not sync")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// Apply loads the config file content data, named name in errors, and applies its reloadable
+// changes. obj is the ConfigMap the data came from, or nil for a file; Events about the reload
+// are recorded on it. It reports whether the new configuration is in use.
+func (c *ConfigReloader) Apply(ctx context.Context, name string, data []byte, obj runtime.Object) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logger := log.FromContext(ctx).WithName("config").WithValues("source", name)
+	refuse := func(result, reason, format string, args ...any) bool {
+		msg := fmt.Sprintf(format, args...)
+		logger.Info("Config reload refused", "reason", reason, "message", msg)
+		if obj != nil {
+			c.Reconciler.EventRecorder.Event(obj, corev1.EventTypeWarning, reason, msg)
+		}
+		configReloads.WithLabelValues(result).Inc()
+		return false
+	}
+
+	next, err := c.Load(name, data)
+	if err != nil {
+		return refuse("invalid", "InvalidConfig", "keeping the current configuration: %v", err)
+	}
+	reloadable, restart := config.Changed(c.current, next)
+	if len(restart) > 0 {
+		return refuse("refused", "ConfigReloadRefused",
+			"keeping the current configuration: changing %s requires a restart", strings.Join(restart, ", "))
+	}
+	if len(reloadable) == 0 {
+		return true
+	}
+
+	poolsChanged := slices.Contains(reloadable, "vip.pool") || slices.Contains(reloadable, "vip.pools")
+	if poolsChanged {
+		if err := c.Reconciler.VIPAlloc.SetPools(config.Pools(next)); err != nil {
+			var inUse *config.VIPsInUseError
+			if errors.As(err, &inUse) {
+				c.eventInUse(ctx, inUse)
+			}
+			return refuse("refused", "ConfigReloadRefused", "keeping the current configuration: %v", err)
+		}
+		setPoolVIPs(c.Reconciler.VIPAlloc.Usage())
+	}
+	if next.DefaultInterface != c.current.DefaultInterface {
+		c.Reconciler.SetInterface(next.DefaultInterface)
+	}
+	if next.Paused != c.current.Paused {
+		c.Reconciler.SetPaused(next.Paused)
+	}
+	if next.LogLevel != c.current.LogLevel && c.SetLogLevel != nil {
+		c.SetLogLevel(next.LogLevel)
+	}
+	c.current = next
+	configReloads.WithLabelValues("applied").Inc()
+	msg := "applied changes to " + strings.Join(reloadable, ", ")
+	logger.Info("Config reloaded", "changed", reloadable)
+	if obj != nil {
+		c.Reconciler.EventRecorder.Event(obj, corev1.EventTypeNormal, "ConfigReloaded", msg)
+	}
+
+	if poolsChanged || slices.Contains(reloadable, "defaultInterface") || slices.Contains(reloadable, "paused") {
+		c.resync(ctx)
+	}
+	return true
+}
+
+// eventInUse records a Warning Event on every Service whose VIP the refused pools drop.
+func (c *ConfigReloader) eventInUse(ctx context.Context, inUse *config.VIPsInUseError) {
+	for vip, key := range inUse.InUse {
+		namespace, name, _ := strings.Cut(key, "/")
+		var svc corev1.Service
+		if err := c.Reconciler.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &svc); err != nil {
+			continue
+		}
+		c.Reconciler.EventRecorder.Eventf(&svc, corev1.EventTypeWarning, "ConfigReloadRefused",
+			"new configuration removes VIP %s, assigned to this Service, from its pool; keeping the current configuration", vip)
+	}
+}
+
+// resync enqueues every Service of our class once this replica leads. It runs in the
+// background, as the controller may only start leading much later.
+func (c *ConfigReloader) resync(ctx context.Context) {
+	go func() {
+		if c.Elected != nil {
+			select {
+			case <-c.Elected:
+			case <-ctx.Done():
+				return
+			}
+		}
+		n, err := c.Reconciler.Resync(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.FromContext(ctx).Error(err, "Failed to resync Services after config reload")
+			return
+		}
+		log.FromContext(ctx).Info("Resynced Services after config reload", "services", n)
+	}()
+}
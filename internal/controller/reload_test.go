@@ -0,0 +1,139 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+)
+
+// testConfigFile returns a config file with the given VIP pool and extra settings.
+func testConfigFile(pool string, extra string) []byte {
+	return []byte(`apiVersion: opnsense-lb-controller/v1
+opnsense:
+  url: https://firewall.example.com
+  secretName: opnsense
+  secretNamespace: opnsense-lb-controller
+vip:
+  pool: [` + pool + `]
+` + extra)
+}
+
+func TestConfigReloader_Apply(t *testing.T) {
+	ctx := context.Background()
+	r, _, recorder := newTestReconciler(t, []string{"192.0.2.10", "192.0.2.11"}, syncedService()...)
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+	if vip := r.VIPAlloc.GetVIP("default/web"); vip != "192.0.2.10" {
+		t.Fatalf("VIP = %q, want 192.0.2.10", vip)
+	}
+	drainEvents(recorder)
+
+	flags := config.RegisterFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	current, err := flags.LoadData("config.yaml", testConfigFile("192.0.2.10, 192.0.2.11", ""))
+	if err != nil {
+		t.Fatalf("LoadData: %v", err)
+	}
+	var level string
+	reloader := NewConfigReloader(flags.LoadData, current, r, func(l string) { level = l })
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "opnsense-lb-controller", Name: "config"}}
+
+	// Adding a VIP, changing the default interface and the log level applies in place and
+	// resyncs the Services.
+	if !reloader.Apply(ctx, "config.yaml", testConfigFile("192.0.2.10, 192.0.2.11, 192.0.2.12",
+		"defaultInterface: opt1\nlogLevel: debug\n"), cm) {
+		t.Fatal("Apply refused a pool addition")
+	}
+	if !hasEvent(drainEvents(recorder), "ConfigReloaded") {
+		t.Error("no ConfigReloaded Event")
+	}
+	if level != "debug" || r.defaultInterface() != "opt1" {
+		t.Errorf("log level = %q, interface = %q; want debug, opt1", level, r.defaultInterface())
+	}
+	select {
+	case ev := <-r.resync:
+		if ev.Object.GetName() != "web" {
+			t.Errorf("resynced %s, want web", ev.Object.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Services were not resynced")
+	}
+	if usage := r.VIPAlloc.Usage(); len(usage) != 1 || usage[0].Free != 2 {
+		t.Errorf("Usage = %+v, want 2 free VIPs", usage)
+	}
+
+	// Removing a pool drops its metric series.
+	withPool := strings.Replace(string(testConfigFile("192.0.2.10, 192.0.2.11, 192.0.2.12", "defaultInterface: opt1\nlogLevel: debug\n")),
+		"vip:\n", "vip:\n  pools:\n    dmz: [192.0.2.20]\n", 1)
+	if !reloader.Apply(ctx, "config.yaml", []byte(withPool), cm) {
+		t.Fatal("Apply refused a pool addition")
+	}
+	if got := testutil.ToFloat64(poolVIPs.WithLabelValues("dmz", "free")); got != 1 {
+		t.Errorf("dmz free VIPs = %v, want 1", got)
+	}
+	if !reloader.Apply(ctx, "config.yaml", testConfigFile("192.0.2.10, 192.0.2.11, 192.0.2.12",
+		"defaultInterface: opt1\nlogLevel: debug\n"), cm) {
+		t.Fatal("Apply refused a pool removal")
+	}
+	if n := testutil.CollectAndCount(poolVIPs); n != 2 {
+		t.Errorf("opnsense_lb_pool_vips series = %d, want 2 for the default pool only", n)
+	}
+	drainEvents(recorder)
+
+	// Removing the VIP of default/web is refused, on the ConfigMap and on the Service.
+	if reloader.Apply(ctx, "config.yaml", testConfigFile("192.0.2.11", ""), cm) {
+		t.Fatal("Apply removed a VIP in use")
+	}
+	events := drainEvents(recorder)
+	if n := countEvents(events, "ConfigReloadRefused"); n != 2 {
+		t.Errorf("ConfigReloadRefused Events = %d, want 2 (ConfigMap and Service): %v", n, events)
+	}
+	if _, err := r.VIPAlloc.Allocate("default/other", config.VIPRequest{IP: "192.0.2.12"}); err != nil {
+		t.Errorf("refused reload changed the pool: %v", err)
+	}
+
+	// Settings that need a restart and invalid files are refused too.
+	if reloader.Apply(ctx, "config.yaml", testConfigFile("192.0.2.10, 192.0.2.11, 192.0.2.12", "clusterID: prod\n"), cm) {
+		t.Error("Apply changed clusterID")
+	}
+	if reloader.Apply(ctx, "config.yaml", testConfigFile("192.0.2.300", ""), cm) {
+		t.Error("Apply accepted an invalid file")
+	}
+	events = drainEvents(recorder)
+	if !hasEvent(events, "ConfigReloadRefused") || !hasEvent(events, "InvalidConfig") {
+		t.Errorf("events = %v, want ConfigReloadRefused and InvalidConfig", events)
+	}
+}
+
+func countEvents(events []string, reason string) int {
+	n := 0
+	for _, ev := range events {
+		if hasEvent([]string{ev}, reason) {
+			n++
+		}
+	}
+	return n
+}
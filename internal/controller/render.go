@@ -0,0 +1,221 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// RenderOptions are the controller settings Render applies, as the config file would.
+type RenderOptions struct {
+	// ManagedBy and ClusterID form the identity in every description.
+	ManagedBy string
+	ClusterID string
+	// LoadBalancerClass is the spec.loadBalancerClass of the Services the controller manages.
+	LoadBalancerClass string
+	// VIP is the address the Service gets; empty takes the address requested by AnnotationLoadBalancerIP,
+	// then the Service's status.loadBalancer.ingress.
+	VIP string
+	// Interface is the default interface; empty for opnsense.DefaultInterface.
+	Interface string
+	PassRules PassRuleOptions
+}
+
+// NewRenderOptions returns the RenderOptions of a controller running with cfg, without
+// ManagedBy and VIP.
+func NewRenderOptions(cfg *config.Config) RenderOptions {
+	return RenderOptions{
+		ClusterID:         cfg.ClusterID,
+		LoadBalancerClass: cfg.LoadBalancerClass,
+		Interface:         cfg.DefaultInterface,
+		PassRules:         PassRuleOptions{Log: cfg.PassRuleLog, Sequence: cfg.PassRuleSequence},
+	}
+}
+
+// Rendered lists the OPNsense objects a sync creates for a Service.
+type Rendered struct {
+	Service     string               `json:"service"`
+	VIP         RenderedVIP          `json:"vip"`
+	Aliases     []RenderedAlias      `json:"aliases"`
+	NATRules    []RenderedNATRule    `json:"natRules"`
+	FilterRules []RenderedFilterRule `json:"filterRules"`
+}
+
+// RenderedVIP is the virtual IP of a Rendered Service.
+type RenderedVIP struct {
+	Subnet      string `json:"subnet"`
+	Interface   string `json:"interface"`
+	Description string `json:"description"`
+}
+
+// RenderedAlias is an alias of a Rendered Service.
+type RenderedAlias struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Content     []string `json:"content"`
+	Description string   `json:"description"`
+}
+
+// RenderedNATRule is a port forward of a Rendered Service; NoAutoPass leaves passing the
+// traffic to the filter rules.
+type RenderedNATRule struct {
+	Interface    string `json:"interface"`
+	Protocol     string `json:"protocol"`
+	Source       string `json:"source"`
+	ExternalPort int    `json:"externalPort"`
+	Target       string `json:"target"`
+	Log          bool   `json:"log"`
+	NoAutoPass   bool   `json:"noAutoPass"`
+	Description  string `json:"description"`
+}
+
+// RenderedFilterRule is a filter rule of a Rendered Service; Sequence 0 appends it.
+type RenderedFilterRule struct {
+	Action      string `json:"action"`
+	Interface   string `json:"interface"`
+	Protocol    string `json:"protocol"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Log         bool   `json:"log"`
+	Sequence    int    `json:"sequence"`
+	Description string `json:"description"`
+}
+
+// Render computes the OPNsense objects a sync of svc creates, from its Endpoints and nodes,
+// without a cluster or a firewall. endpoints may be nil, for rules without backends. The
+// descriptions carry svc's metadata.uid, which is empty for a Service that was never created.
+func Render(svc *corev1.Service, endpoints *corev1.Endpoints, nodes []corev1.Node, opts RenderOptions) (*Rendered, error) { //nolint:staticcheck // SA1019: migrate to discoveryv1.EndpointSlice
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil, fmt.Errorf("service %s/%s is of type %q, not LoadBalancer", svc.Namespace, svc.Name, svc.Spec.Type)
+	}
+	if !isLoadBalancerOfClass(svc, opts.LoadBalancerClass) {
+		return nil, fmt.Errorf("service %s/%s is not of loadBalancerClass %q", svc.Namespace, svc.Name, opts.LoadBalancerClass)
+	}
+	svcOpts, err := ParseServiceOptions(svc.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlockRules(svcOpts, opts.PassRules); err != nil {
+		return nil, err
+	}
+	svcOpts.Interface = cmp.Or(svcOpts.Interface, opts.Interface)
+	vip := cmp.Or(opts.VIP, svcOpts.VIP.IP)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		vip = cmp.Or(vip, ingress.IP)
+	}
+	if vip == "" {
+		return nil, fmt.Errorf("service %s/%s has no VIP: set one, or request one with %s", svc.Namespace, svc.Name, AnnotationLoadBalancerIP)
+	}
+	getNode := func(nodeName string) (*corev1.Node, bool) {
+		for i := range nodes {
+			if nodes[i].Name == nodeName {
+				return &nodes[i], true
+			}
+		}
+		return nil, false
+	}
+	state, err := ComputeDesiredState(vip, svc, endpoints, 0, getNode, svcOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := opnsense.Owner{
+		Identity:  opnsense.Identity{ManagedBy: opts.ManagedBy, ClusterID: opts.ClusterID},
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+		UID:       string(svc.UID),
+	}
+	out := &Rendered{
+		Service:     owner.ServiceKey(),
+		VIP:         RenderedVIP{Subnet: vip + "/32", Interface: state.Interface, Description: owner.VIPDescription(vip)},
+		Aliases:     []RenderedAlias{},
+		NATRules:    []RenderedNATRule{},
+		FilterRules: []RenderedFilterRule{},
+	}
+	for _, a := range desiredAliases(state, owner) {
+		out.Aliases = append(out.Aliases, RenderedAlias{Name: a.Name, Type: a.Type, Content: a.Content, Description: owner.Description("")})
+	}
+	rules := desiredStateToOPNsenseRules(state, owner)
+	for _, r := range rules {
+		out.NATRules = append(out.NATRules, RenderedNATRule{
+			Interface:    cmp.Or(r.Interface, opnsense.DefaultInterface),
+			Protocol:     strings.ToUpper(r.Protocol),
+			Source:       cmp.Or(r.SourceNet, "any"),
+			ExternalPort: r.ExternalPort,
+			Target:       r.TargetIP + ":" + strconv.Itoa(r.TargetPort),
+			Log:          r.Log,
+			NoAutoPass:   r.NoAutoPass,
+			Description:  opnsense.WithNote(owner.Description(r.PortName), r.Note),
+		})
+	}
+	for _, r := range desiredFilterRules(state, rules, owner, opts.PassRules) {
+		source := cmp.Or(r.SourceNet, "any")
+		if r.SourceNot {
+			source = "!" + source
+		}
+		out.FilterRules = append(out.FilterRules, RenderedFilterRule{
+			Action:      cmp.Or(r.Action, opnsense.ActionPass),
+			Interface:   cmp.Or(r.Interface, opnsense.DefaultInterface),
+			Protocol:    strings.ToUpper(r.Protocol),
+			Source:      source,
+			Destination: r.DestinationIP + ":" + strconv.Itoa(r.DestinationPort),
+			Log:         r.Log,
+			Sequence:    r.Sequence,
+			Description: opnsense.WithNote(owner.Description(r.PortName), r.Note),
+		})
+	}
+	return out, nil
+}
+
+// WriteTable writes r as one table per object kind.
+func (r *Rendered) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	fmt.Fprintf(tw, "SERVICE %s\n\n", r.Service)
+	fmt.Fprintln(tw, "VIP\tINTERFACE\tDESCRIPTION")
+	fmt.Fprintf(tw, "%s\t%s\t%s\n", r.VIP.Subnet, r.VIP.Interface, r.VIP.Description)
+	if len(r.Aliases) > 0 {
+		fmt.Fprintln(tw, "\nALIAS\tTYPE\tCONTENT\tDESCRIPTION")
+		for _, a := range r.Aliases {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Name, a.Type, strings.Join(a.Content, ","), a.Description)
+		}
+	}
+	fmt.Fprintln(tw, "\nNAT RULE\tINTERFACE\tSOURCE\tPORT\tTARGET\tLOG\tDESCRIPTION")
+	for _, n := range r.NATRules {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", n.Protocol, n.Interface, n.Source, n.ExternalPort, n.Target, yesNo(n.Log), n.Description)
+	}
+	fmt.Fprintln(tw, "\nFILTER RULE\tINTERFACE\tSOURCE\tDESTINATION\tLOG\tSEQUENCE\tDESCRIPTION")
+	for _, f := range r.FilterRules {
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\t%s\t%d\t%s\n", f.Action, f.Protocol, f.Interface, f.Source, f.Destination, yesNo(f.Log), f.Sequence, f.Description)
+	}
+	return tw.Flush()
+}
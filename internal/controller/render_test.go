@@ -0,0 +1,168 @@
+/*
+Copyright 2026.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"cmp"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/config"
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+func TestRender_MatchesSync(t *testing.T) {
+	ctx := context.Background()
+	objs := syncedService()
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	setAnnotations(ctx, t, r, map[string]string{
+		AnnotationSourceRanges: "10.0.0.0/8",
+		AnnotationDescription:  "Public website",
+	})
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	var svc corev1.Service
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "web"}, &svc); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	got, err := Render(&svc, objs[1].(*corev1.Endpoints), []corev1.Node{*objs[2].(*corev1.Node)}, //nolint:staticcheck // SA1019
+		RenderOptions{ManagedBy: testManagedBy, LoadBalancerClass: testClass, VIP: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	if got.VIP.Subnet != "192.0.2.1/32" || got.VIP.Interface != "wan" {
+		t.Errorf("VIP: got %+v", got.VIP)
+	}
+	rules := mock.NATRulesFor("default/web")
+	if len(got.NATRules) != len(rules) {
+		t.Fatalf("NAT rules: got %+v, want %+v", got.NATRules, rules)
+	}
+	for i, want := range rules {
+		n := got.NATRules[i]
+		if n.Description != want.Description || n.Source != want.SourceNet || n.ExternalPort != want.ExternalPort ||
+			n.Target != want.TargetIP+":"+strconv.Itoa(want.TargetPort) {
+			t.Errorf("NAT rule %d: got %+v, want %+v", i, n, want)
+		}
+	}
+	filters := mock.FilterRulesFor("default/web")
+	if len(got.FilterRules) != len(filters) {
+		t.Fatalf("filter rules: got %+v, want %+v", got.FilterRules, filters)
+	}
+	for i, want := range filters {
+		if f := got.FilterRules[i]; f.Description != want.Description || f.Action != want.Action {
+			t.Errorf("filter rule %d: got %+v, want %+v", i, f, want)
+		}
+	}
+	aliases := mock.AliasesFor("default/web")
+	if len(got.Aliases) != 1 || len(aliases) != 1 || got.Aliases[0].Name != aliases[0].Name ||
+		got.Aliases[0].Description != aliases[0].Description {
+		t.Errorf("aliases: got %+v, want %+v", got.Aliases, aliases)
+	}
+
+	var table strings.Builder
+	if err := got.WriteTable(&table); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"SERVICE default/web", "192.0.2.1/32", "192.168.1.10:30080", "| Public website"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("table %q: want %q", table.String(), want)
+		}
+	}
+}
+
+// filterRecorder records the filter rules last passed to ApplyFilterRules.
+type filterRecorder struct {
+	*FakeOPNsense
+	last []opnsense.FilterRule
+}
+
+func (f *filterRecorder) ApplyFilterRules(ctx context.Context, desired []opnsense.FilterRule, owner opnsense.Owner) error {
+	f.last = desired
+	return f.FakeOPNsense.ApplyFilterRules(ctx, desired, owner)
+}
+
+func TestRender_MatchesReconcilerDefaults(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	objs := syncedService()
+	svc := objs[0].(*corev1.Service)
+	svc.Spec.LoadBalancerClass = &cfg.LoadBalancerClass
+	svc.Annotations = map[string]string{AnnotationAllowCountries: "DE", AnnotationDescription: "Public website"}
+	r, mock, _ := newTestReconciler(t, []string{"192.0.2.1"}, objs...)
+	// Configured from cfg as main does.
+	r.LoadBalancerClass = cfg.LoadBalancerClass
+	r.Interface = cfg.DefaultInterface
+	r.PassRules = PassRuleOptions{Log: cfg.PassRuleLog, Sequence: cfg.PassRuleSequence}
+	recorder := &filterRecorder{FakeOPNsense: mock}
+	r.OPNsense = recorder
+	reconcileKey(ctx, t, r, "default", "web")
+	reconcileKey(ctx, t, r, "default", "web")
+
+	opts := NewRenderOptions(cfg)
+	opts.ManagedBy, opts.VIP = testManagedBy, "192.0.2.1"
+	got, err := Render(svc, objs[1].(*corev1.Endpoints), []corev1.Node{*objs[2].(*corev1.Node)}, opts) //nolint:staticcheck // SA1019
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if len(recorder.last) != 2 || len(got.FilterRules) != len(recorder.last) {
+		t.Fatalf("filter rules: rendered %+v, applied %+v", got.FilterRules, recorder.last)
+	}
+	owner := testOwner("default/web")
+	for i, want := range recorder.last {
+		f := got.FilterRules[i]
+		if f.Action != cmp.Or(want.Action, opnsense.ActionPass) || f.Interface != want.Interface || f.Log != want.Log ||
+			f.Sequence != want.Sequence || f.Description != opnsense.WithNote(owner.Description(want.PortName), want.Note) {
+			t.Errorf("filter rule %d: rendered %+v, applied %+v", i, f, want)
+		}
+	}
+	if got.FilterRules[1].Sequence != cfg.PassRuleSequence {
+		t.Errorf("pass rule sequence: got %d, want the default %d", got.FilterRules[1].Sequence, cfg.PassRuleSequence)
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	svc := syncedService()[0].(*corev1.Service)
+	if _, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: testClass}); err == nil || !strings.Contains(err.Error(), "no VIP") {
+		t.Errorf("without VIP: got %v, want no VIP error", err)
+	}
+	svc.Annotations = map[string]string{AnnotationLoadBalancerIP: "192.0.2.7"}
+	if got, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: testClass}); err != nil || got.VIP.Subnet != "192.0.2.7/32" || len(got.NATRules) != 0 {
+		t.Errorf("with annotation, no Endpoints: got %+v, %v", got, err)
+	}
+	svc.Annotations = map[string]string{AnnotationLog: "maybe"}
+	if _, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: testClass, VIP: "192.0.2.1"}); err == nil {
+		t.Error("invalid annotation: want error")
+	}
+	if _, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: "other", VIP: "192.0.2.1"}); err == nil {
+		t.Error("Service of another loadBalancerClass: want error")
+	}
+	svc.Annotations = map[string]string{AnnotationAllowCountries: "DE"}
+	if _, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: testClass, VIP: "192.0.2.1"}); err == nil {
+		t.Error("block rules at pass rule sequence 0: want error")
+	}
+	svc.Spec.Type = corev1.ServiceTypeClusterIP
+	if _, err := Render(svc, nil, nil, RenderOptions{LoadBalancerClass: testClass, VIP: "192.0.2.1"}); err == nil {
+		t.Error("ClusterIP Service: want error")
+	}
+}
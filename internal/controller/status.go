@@ -18,20 +18,125 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Condition types the controller maintains in a Service's status.conditions.
+const (
+	// ConditionLoadBalancerSynced is True when every NAT rule of the Service was applied on OPNsense.
+	ConditionLoadBalancerSynced = "LoadBalancerSynced"
+	// ConditionVIPAssigned is True when a VIP was allocated for the Service.
+	ConditionVIPAssigned = "VIPAssigned"
+	// ConditionFirewallReachable is False when the last OPNsense API call failed outright.
+	ConditionFirewallReachable = "FirewallReachable"
+	// ConditionDegraded is True while the Service keeps its last-known-good
+	// status.loadBalancer because OPNsense could not be updated (e.g. an API timeout).
+	ConditionDegraded = "Degraded"
+	// ConditionAnnotationsValid is False while the Service's opnsense.org/ annotations are
+	// invalid; the controller leaves OPNsense unchanged until they are fixed.
+	ConditionAnnotationsValid = "AnnotationsValid"
+	// ConditionPaused is True while reconciliation of the Service is paused; its message lists
+	// the changes a sync would make on OPNsense.
+	ConditionPaused = "Paused"
+)
+
+// ourConditionTypes lists every condition type owned by the controller, for removal on cleanup.
+var ourConditionTypes = []string{
+	ConditionLoadBalancerSynced, ConditionVIPAssigned, ConditionFirewallReachable, ConditionDegraded,
+	ConditionAnnotationsValid, ConditionPaused,
+}
+
+// Values recorded in status.loadBalancer.ingress[].ports[].error. The API requires
+// "domain/CamelCase" names; details go into the LoadBalancerSynced condition message.
+const (
+	// PortErrorNATRuleFailed means OPNsense rejected the NAT rule(s) for the port.
+	PortErrorNATRuleFailed = "opnsense.org/NATRuleFailed"
+	// PortErrorNoBackends means the port has no ready backends, so no NAT rule exists for it.
+	PortErrorNoBackends = "opnsense.org/NoBackends"
+)
+
+// portKey identifies a Service port by protocol and port number, e.g. "TCP/80".
+func portKey(protocol string, port int32) string {
+	return fmt.Sprintf("%s/%d", strings.ToUpper(protocol), port)
+}
+
+// LoadBalancerIngress returns the ingress entry for vip with ipMode VIP and one PortStatus per
+// Service port. portErrors maps portKey values to one of the PortError values.
+func LoadBalancerIngress(vip string, svc *corev1.Service, portErrors map[string]string) corev1.LoadBalancerIngress {
+	ipMode := corev1.LoadBalancerIPModeVIP
+	ing := corev1.LoadBalancerIngress{IP: vip, IPMode: &ipMode}
+	for _, p := range svc.Spec.Ports {
+		ps := corev1.PortStatus{Port: p.Port, Protocol: p.Protocol}
+		if e, ok := portErrors[portKey(string(p.Protocol), p.Port)]; ok {
+			ps.Error = &e
+		}
+		ing.Ports = append(ing.Ports, ps)
+	}
+	return ing
+}
+
+// ingressIP returns the IP of the Service's first status.loadBalancer.ingress entry, or "".
+func ingressIP(svc *corev1.Service) string {
+	if ingress := svc.Status.LoadBalancer.Ingress; len(ingress) > 0 {
+		return ingress[0].IP
+	}
+	return ""
+}
+
 // UpdateServiceLoadBalancerIngress patches the Service's status.loadBalancer.ingress
 // to a single entry with the given VIP when vip is non-empty, or to an empty slice
 // when vip is empty. Only .status.loadBalancer is changed.
 func UpdateServiceLoadBalancerIngress(ctx context.Context, c client.Client, svc *corev1.Service, vip string) error {
-	modified := svc.DeepCopy()
+	ingress := []corev1.LoadBalancerIngress{}
 	if vip != "" {
-		modified.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: vip}}
-	} else {
-		modified.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{}
+		ingress = append(ingress, corev1.LoadBalancerIngress{IP: vip})
+	}
+	return UpdateServiceLoadBalancerStatus(ctx, c, svc, ingress)
+}
+
+// UpdateServiceLoadBalancerStatus patches status.loadBalancer.ingress to ingress and sets conds
+// in status.conditions (stamped with the Service's generation) in a single status patch.
+func UpdateServiceLoadBalancerStatus(ctx context.Context, c client.Client, svc *corev1.Service,
+	ingress []corev1.LoadBalancerIngress, conds ...metav1.Condition) error {
+	modified := svc.DeepCopy()
+	modified.Status.LoadBalancer.Ingress = ingress
+	for _, cond := range conds {
+		cond.ObservedGeneration = svc.Generation
+		meta.SetStatusCondition(&modified.Status.Conditions, cond)
+	}
+	return c.Status().Patch(ctx, modified, client.MergeFrom(svc))
+}
+
+// SetServiceConditions sets conds in the Service's status.conditions, stamping them with the
+// Service's generation, and patches the status only when a condition actually changed.
+func SetServiceConditions(ctx context.Context, c client.Client, svc *corev1.Service, conds ...metav1.Condition) error {
+	modified := svc.DeepCopy()
+	var changed bool
+	for _, cond := range conds {
+		cond.ObservedGeneration = svc.Generation
+		if meta.SetStatusCondition(&modified.Status.Conditions, cond) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return c.Status().Patch(ctx, modified, client.MergeFrom(svc))
+}
+
+// ClearServiceLoadBalancerStatus empties status.loadBalancer.ingress and removes the
+// controller's conditions, for Services the controller no longer manages.
+func ClearServiceLoadBalancerStatus(ctx context.Context, c client.Client, svc *corev1.Service) error {
+	modified := svc.DeepCopy()
+	modified.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{}
+	for _, t := range ourConditionTypes {
+		meta.RemoveStatusCondition(&modified.Status.Conditions, t)
 	}
 	return c.Status().Patch(ctx, modified, client.MergeFrom(svc))
 }
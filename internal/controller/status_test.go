@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -92,3 +93,63 @@ func TestUpdateServiceLoadBalancerIngress(t *testing.T) {
 		}
 	})
 }
+
+func TestSetServiceConditions(t *testing.T) {
+	ctx := context.Background()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-svc", Generation: 3},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "192.0.2.1"}},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjects(svc).
+		WithStatusSubresource(svc).
+		Build()
+
+	err := SetServiceConditions(ctx, c, svc, metav1.Condition{
+		Type:    ConditionDegraded,
+		Status:  metav1.ConditionTrue,
+		Reason:  "EnsureVIPFailed",
+		Message: "timeout",
+	})
+	if err != nil {
+		t.Fatalf("SetServiceConditions: %v", err)
+	}
+
+	var updated corev1.Service
+	if err := c.Get(ctx, client.ObjectKeyFromObject(svc), &updated); err != nil {
+		t.Fatalf("Get Service: %v", err)
+	}
+	cond := meta.FindStatusCondition(updated.Status.Conditions, ConditionDegraded)
+	if cond == nil || cond.Status != metav1.ConditionTrue || cond.ObservedGeneration != 3 {
+		t.Fatalf("Degraded condition: got %+v, want True with observedGeneration 3", cond)
+	}
+	if len(updated.Status.LoadBalancer.Ingress) != 1 {
+		t.Errorf("LoadBalancer.Ingress: got %v, want it preserved", updated.Status.LoadBalancer.Ingress)
+	}
+}
+
+func TestLoadBalancerIngress(t *testing.T) {
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+		{Port: 80, Protocol: corev1.ProtocolTCP},
+		{Port: 53, Protocol: corev1.ProtocolUDP},
+	}}}
+	ing := LoadBalancerIngress("192.0.2.1", svc, map[string]string{"UDP/53": PortErrorNoBackends})
+	if ing.IP != "192.0.2.1" || ing.IPMode == nil || *ing.IPMode != corev1.LoadBalancerIPModeVIP {
+		t.Errorf("ingress: got IP %q IPMode %v, want 192.0.2.1/VIP", ing.IP, ing.IPMode)
+	}
+	if len(ing.Ports) != 2 {
+		t.Fatalf("Ports: got %v, want 2", ing.Ports)
+	}
+	if ing.Ports[0].Error != nil {
+		t.Errorf("TCP/80 error: got %q, want none", *ing.Ports[0].Error)
+	}
+	if ing.Ports[1].Protocol != corev1.ProtocolUDP || ing.Ports[1].Error == nil || *ing.Ports[1].Error != PortErrorNoBackends {
+		t.Errorf("UDP/53: got %+v, want error %s", ing.Ports[1], PortErrorNoBackends)
+	}
+}
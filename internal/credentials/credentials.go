@@ -0,0 +1,122 @@
+// Package credentials supplies the OPNsense API URL and credentials from environment variables,
+// a Kubernetes Secret, files or an exec plugin, and keeps an opnsense.Client current as they
+// rotate.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+// Sources accepted by the controller's OPNSENSE_CREDENTIALS_SOURCE setting.
+const (
+	SourceEnv    = "env"
+	SourceSecret = "secret"
+	SourceFile   = "file"
+	SourceExec   = "exec"
+)
+
+// Values are the OPNsense connection settings a Provider supplies. URL is empty when the source
+// does not set it.
+type Values struct {
+	URL string
+	opnsense.Credentials
+}
+
+// Provider is a source of OPNsense connection settings.
+type Provider interface {
+	// Load returns the current values, or an error when they are missing or incomplete.
+	Load(ctx context.Context) (Values, error)
+	// Watch calls update with the values every time they change, until ctx is done. Invalid
+	// values are logged and skipped, so the last valid ones stay in use. Providers whose values
+	// cannot change return nil right away.
+	Watch(ctx context.Context, update func(Values)) error
+}
+
+// Env supplies fixed values, read from OPNSENSE_API_KEY and OPNSENSE_API_SECRET at startup. They
+// never change.
+type Env struct {
+	Values Values
+}
+
+func (e Env) Load(context.Context) (Values, error) {
+	if e.Values.Key == "" || e.Values.Secret == "" {
+		return Values{}, errors.New("OPNSENSE_API_KEY and OPNSENSE_API_SECRET must both be set")
+	}
+	return e.Values, nil
+}
+
+func (Env) Watch(context.Context, func(Values)) error { return nil }
+
+// fromData reads values from the keys of a Secret, a credentials directory or a JSON document:
+// url, and either apiKey and apiSecret or key and secret (the layout of an OPNsense API key
+// download). apiKey and apiSecret win when both layouts are set. Values are trimmed, so files
+// may end in a newline.
+func fromData(source string, data map[string]string) (Values, error) {
+	get := func(key string) string { return strings.TrimSpace(data[key]) }
+	for _, keys := range [][2]string{{"apiKey", "apiSecret"}, {"key", "secret"}} {
+		key, secret := get(keys[0]), get(keys[1])
+		if key == "" && secret == "" {
+			continue
+		}
+		if key == "" || secret == "" {
+			return Values{}, fmt.Errorf("%s: %s and %s must both be set", source, keys[0], keys[1])
+		}
+		return Values{URL: get("url"), Credentials: opnsense.Credentials{Key: key, Secret: secret}}, nil
+	}
+	return Values{}, fmt.Errorf("%s has neither apiKey/apiSecret nor key/secret", source)
+}
+
+// Syncer keeps the credentials of an opnsense.Client current with a Provider. The client keeps
+// its base URL, so a URL the provider supplies later is only logged: it takes effect after a
+// restart, unless URLPinned.
+type Syncer struct {
+	Provider Provider
+	OPNsense opnsense.Client
+	// URLPinned is set when the client's base URL comes from the controller's configuration,
+	// which wins over any URL of the provider.
+	URLPinned bool
+
+	mu      sync.Mutex
+	current Values
+}
+
+// NewSyncer returns a Syncer for a client that uses the initial values: its base URL and the
+// credentials loaded from provider when the client was created.
+func NewSyncer(provider Provider, opnsenseClient opnsense.Client, initial Values) *Syncer {
+	return &Syncer{Provider: provider, OPNsense: opnsenseClient, current: initial}
+}
+
+// Start watches the provider until ctx is done. Implements manager.Runnable.
+func (s *Syncer) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("credentials")
+	return s.Provider.Watch(ctx, func(v Values) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if v.URL != "" && v.URL != s.current.URL {
+			if s.URLPinned {
+				logger.Info("Ignoring the OPNsense URL of the credentials source; opnsense.url is set", "url", v.URL)
+			} else {
+				logger.Info("OPNsense URL changed; restart the controller to use it", "url", v.URL)
+			}
+			s.current.URL = v.URL
+		}
+		if v.Credentials == s.current.Credentials {
+			return
+		}
+		s.current.Credentials = v.Credentials
+		s.OPNsense.SetCredentials(v.Credentials)
+		logger.Info("OPNsense credentials rotated")
+	})
+}
+
+// NeedLeaderElection returns false: standby replicas need current credentials for the
+// readiness probe and to take over. Implements manager.LeaderElectionRunnable.
+func (s *Syncer) NeedLeaderElection() bool { return false }
@@ -0,0 +1,95 @@
+package credentials
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/scheuk/opnsense-lb-controller/internal/opnsense"
+)
+
+func TestFromData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    Values
+		wantErr bool
+	}{
+		{name: "apiKey layout", data: map[string]string{"url": "https://fw", "apiKey": "k1", "apiSecret": "s1"},
+			want: Values{URL: "https://fw", Credentials: opnsense.Credentials{Key: "k1", Secret: "s1"}}},
+		{name: "key layout", data: map[string]string{"key": "k2\n", "secret": "s2\n"},
+			want: Values{Credentials: opnsense.Credentials{Key: "k2", Secret: "s2"}}},
+		{name: "apiKey layout wins", data: map[string]string{"apiKey": "k1", "apiSecret": "s1", "key": "k2", "secret": "s2"},
+			want: Values{Credentials: opnsense.Credentials{Key: "k1", Secret: "s1"}}},
+		{name: "incomplete", data: map[string]string{"apiKey": "k1"}, wantErr: true},
+		{name: "empty", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fromData("test", tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fromData: err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fromData: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// staticUpdates is a Provider whose Watch sends a fixed list of values.
+type staticUpdates []Values
+
+func (s staticUpdates) Load(context.Context) (Values, error) { return s[0], nil }
+
+func (s staticUpdates) Watch(_ context.Context, update func(Values)) error {
+	for _, v := range s {
+		update(v)
+	}
+	return nil
+}
+
+// recordingClient records the credentials set on it.
+type recordingClient struct {
+	opnsense.Client
+	set []opnsense.Credentials
+}
+
+func (c *recordingClient) SetCredentials(creds opnsense.Credentials) { c.set = append(c.set, creds) }
+
+func TestSyncer_SetsChangedCredentials(t *testing.T) {
+	v1 := Values{URL: "https://fw", Credentials: opnsense.Credentials{Key: "k1", Secret: "s1"}}
+	v2 := Values{URL: "https://fw", Credentials: opnsense.Credentials{Key: "k2", Secret: "s2"}}
+	oc := &recordingClient{}
+	s := NewSyncer(staticUpdates{v1, v1, v2, v2}, oc, v1)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(oc.set) != 1 || oc.set[0] != v2.Credentials {
+		t.Errorf("SetCredentials calls: got %+v, want only %+v", oc.set, v2.Credentials)
+	}
+}
+
+func TestSyncer_LogsURLFromProvider(t *testing.T) {
+	creds := opnsense.Credentials{Key: "k1", Secret: "s1"}
+	updates := staticUpdates{{Credentials: creds}, {URL: "https://fw2", Credentials: creds}, {URL: "https://fw2", Credentials: creds}}
+	for _, pinned := range []bool{false, true} {
+		var logs []string
+		ctx := log.IntoContext(context.Background(), funcr.New(func(_, args string) { logs = append(logs, args) }, funcr.Options{}))
+		s := NewSyncer(updates, &recordingClient{}, Values{URL: "https://fw1", Credentials: creds})
+		s.URLPinned = pinned
+		if err := s.Start(ctx); err != nil {
+			t.Fatalf("Start: %v", err)
+		}
+		want := "restart the controller"
+		if pinned {
+			want = "opnsense.url is set"
+		}
+		if len(logs) != 1 || !strings.Contains(logs[0], want) || !strings.Contains(logs[0], "https://fw2") {
+			t.Errorf("pinned=%v: got logs %q, want one about https://fw2 containing %q", pinned, logs, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// execRetryInterval is how long Exec waits before running a failed command again.
+const execRetryInterval = 30 * time.Second
+
+// execRefreshBefore is how long before expirationTimestamp Exec runs the command again.
+const execRefreshBefore = time.Minute
+
+// Exec runs a command, like a kubeconfig exec plugin, that prints the values as a JSON object
+// on stdout; see fromData for the keys, and execOutput for their types. When the object has an
+// RFC 3339 expirationTimestamp, the command runs again shortly before it; otherwise its output
+// is used until a restart. The command's stderr is included in errors.
+type Exec struct {
+	Command string
+	Args    []string
+
+	mu sync.Mutex
+	// loaded is set by a successful Load, with the expiration of its values, so that Watch
+	// starts from them instead of running the command again.
+	loaded  bool
+	expires time.Time
+}
+
+// execOutput is the JSON object an Exec command prints. Other fields are ignored, whatever
+// their type.
+type execOutput struct {
+	URL                 string `json:"url"`
+	APIKey              string `json:"apiKey"`
+	APISecret           string `json:"apiSecret"`
+	Key                 string `json:"key"`
+	Secret              string `json:"secret"`
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+}
+
+// NewExec returns an Exec provider for command and args.
+func NewExec(command string, args ...string) *Exec {
+	return &Exec{Command: command, Args: args}
+}
+
+func (e *Exec) Load(ctx context.Context) (Values, error) {
+	v, expires, err := e.run(ctx)
+	if err == nil {
+		e.mu.Lock()
+		e.loaded, e.expires = true, expires
+		e.mu.Unlock()
+	}
+	return v, err
+}
+
+func (e *Exec) Watch(ctx context.Context, update func(Values)) error {
+	logger := log.FromContext(ctx).WithValues("command", e.Command)
+	e.mu.Lock()
+	loaded, expires := e.loaded, e.expires
+	e.mu.Unlock()
+	var err error
+	for first := true; ; first = false {
+		if !first || !loaded {
+			var v Values
+			if v, expires, err = e.run(ctx); err == nil {
+				update(v)
+			}
+		}
+		var wait time.Duration
+		switch {
+		case err != nil:
+			logger.Error(err, "keeping current OPNsense credentials")
+			wait = execRetryInterval
+		case expires.IsZero():
+			return nil
+		default:
+			wait = max(time.Until(expires)-execRefreshBefore, time.Second)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// run executes the command and returns its values and expiration (zero when none).
+func (e *Exec) run(ctx context.Context) (Values, time.Time, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return Values{}, time.Time{}, fmt.Errorf("credentials command %s: %w: %s", e.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	var out execOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Values{}, time.Time{}, fmt.Errorf("credentials command %s: %w", e.Command, err)
+	}
+	v, err := fromData("credentials command "+e.Command, map[string]string{
+		"url": out.URL, "apiKey": out.APIKey, "apiSecret": out.APISecret, "key": out.Key, "secret": out.Secret,
+	})
+	if err != nil {
+		return Values{}, time.Time{}, err
+	}
+	var expires time.Time
+	if ts := out.ExpirationTimestamp; ts != "" {
+		if expires, err = time.Parse(time.RFC3339, ts); err != nil {
+			return Values{}, time.Time{}, fmt.Errorf("credentials command %s: expirationTimestamp: %w", e.Command, err)
+		}
+	}
+	return v, expires, nil
+}
@@ -0,0 +1,80 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_Load(t *testing.T) {
+	e := NewExec("sh", "-c", `echo '{"url": "https://fw", "key": "k1", "secret": "s1"}'`)
+	v, err := e.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if v.URL != "https://fw" || v.Key != "k1" || v.Secret != "s1" {
+		t.Errorf("Load: got %+v", v)
+	}
+
+	// Only the known fields must be strings.
+	v, err = NewExec("sh", "-c", `echo '{"apiVersion": 1, "apiKey": "k2", "apiSecret": "s2", "status": {"ttl": 3600}}'`).
+		Load(context.Background())
+	if err != nil || v.Key != "k2" {
+		t.Errorf("Load with other fields: got %+v, %v", v, err)
+	}
+
+	_, err = NewExec("sh", "-c", "echo vault is sealed >&2; exit 1").Load(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "vault is sealed") {
+		t.Errorf("Load failing command: got %v, want an error with its stderr", err)
+	}
+}
+
+func TestExec_WatchRefreshesBeforeExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The credentials are already within execRefreshBefore of expiring, so the command runs again
+	// after a second.
+	expires := time.Now().Add(execRefreshBefore).UTC().Format(time.RFC3339)
+	e := NewExec("sh", "-c", `echo '{"apiKey": "k1", "apiSecret": "s1", "expirationTimestamp": "`+expires+`"}'`)
+
+	updates := make(chan Values, 10)
+	go func() { _ = e.Watch(ctx, func(v Values) { updates <- v }) }()
+	for i := range 2 {
+		select {
+		case <-updates:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d: no update", i+1)
+		}
+	}
+
+	static := NewExec("sh", "-c", `echo '{"apiKey": "k1", "apiSecret": "s1"}'`)
+	if err := static.Watch(ctx, func(Values) {}); err != nil {
+		t.Errorf("Watch without expiration: %v", err)
+	}
+}
+
+func TestExec_WatchStartsFromLoad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := filepath.Join(t.TempDir(), "runs")
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	e := NewExec("sh", "-c", `echo run >> "$0"; echo '{"apiKey": "k1", "apiSecret": "s1", "expirationTimestamp": "`+expires+`"}'`, runs)
+	if _, err := e.Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- e.Watch(ctx, func(Values) { t.Error("Watch ran the command again before the expiry") })
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch: %v", err)
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 1 {
+		t.Errorf("command ran %d times, want once", strings.Count(string(data), "run"))
+	}
+}
@@ -0,0 +1,83 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultPollInterval is how often File checks its path for changes.
+const DefaultPollInterval = 10 * time.Second
+
+// File reads the values from Path and reloads them when they change. Path is either a directory
+// with one file per key, like a mounted Secret, or a JSON object with the same keys, like a Vault
+// agent template; see fromData for the keys. The path is polled rather than watched, which also
+// catches the symlink swap Kubernetes uses to update mounted Secrets.
+type File struct {
+	Path         string
+	PollInterval time.Duration
+}
+
+// NewFile returns a File provider polling path every DefaultPollInterval.
+func NewFile(path string) *File {
+	return &File{Path: path, PollInterval: DefaultPollInterval}
+}
+
+func (f *File) Load(context.Context) (Values, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return Values{}, err
+	}
+	data := make(map[string]string)
+	if info.IsDir() {
+		for _, key := range []string{"url", "apiKey", "apiSecret", "key", "secret"} {
+			b, err := os.ReadFile(filepath.Join(f.Path, key))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return Values{}, err
+			}
+			data[key] = string(b)
+		}
+	} else {
+		b, err := os.ReadFile(f.Path)
+		if err != nil {
+			return Values{}, err
+		}
+		if err := json.Unmarshal(b, &data); err != nil {
+			return Values{}, fmt.Errorf("credentials file %s: %w", f.Path, err)
+		}
+	}
+	return fromData("credentials "+f.Path, data)
+}
+
+func (f *File) Watch(ctx context.Context, update func(Values)) error {
+	logger := log.FromContext(ctx).WithValues("path", f.Path)
+	last, _ := f.Load(ctx)
+	ticker := time.NewTicker(f.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		v, err := f.Load(ctx)
+		if err != nil {
+			logger.Error(err, "keeping current OPNsense credentials")
+			continue
+		}
+		if v != last {
+			last = v
+			update(v)
+		}
+	}
+}
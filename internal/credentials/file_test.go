@@ -0,0 +1,71 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile_Load(t *testing.T) {
+	dir := t.TempDir()
+	for k, v := range map[string]string{"url": "https://fw\n", "key": "k1\n", "secret": "s1\n"} {
+		if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := NewFile(dir).Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load directory: %v", err)
+	}
+	if v.URL != "https://fw" || v.Key != "k1" || v.Secret != "s1" {
+		t.Errorf("Load directory: got %+v", v)
+	}
+
+	path := filepath.Join(t.TempDir(), "opnsense.json")
+	if err := os.WriteFile(path, []byte(`{"apiKey": "k2", "apiSecret": "s2"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err = NewFile(path).Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load JSON file: %v", err)
+	}
+	if v.URL != "" || v.Key != "k2" || v.Secret != "s2" {
+		t.Errorf("Load JSON file: got %+v", v)
+	}
+}
+
+func TestFile_WatchReloadsOnChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "opnsense.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"apiKey": "k1", "apiSecret": "s1"}`)
+	f := NewFile(path)
+	f.PollInterval = 10 * time.Millisecond
+
+	updates := make(chan Values, 10)
+	go func() { _ = f.Watch(ctx, func(v Values) { updates <- v }) }()
+	time.Sleep(50 * time.Millisecond)
+	write(`{"apiKey": "k2"}`)
+	time.Sleep(50 * time.Millisecond)
+	write(`{"apiKey": "k2", "apiSecret": "s2"}`)
+
+	select {
+	case v := <-updates:
+		if v.Key != "k2" || v.Secret != "s2" {
+			t.Errorf("update: got %+v, want k2/s2", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after the file changed")
+	}
+	if len(updates) != 0 {
+		t.Errorf("got %d extra updates, want none", len(updates))
+	}
+}
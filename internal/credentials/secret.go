@@ -0,0 +1,82 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Secret reads the values from a Kubernetes Secret and watches it for rotations. Only that
+// Secret is listed and watched, which needs get, list and watch on Secrets in its namespace.
+type Secret struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// NewSecret returns a Secret provider with the given dependencies.
+func NewSecret(clientset kubernetes.Interface, namespace, name string) *Secret {
+	return &Secret{Clientset: clientset, Namespace: namespace, Name: name}
+}
+
+func (s *Secret) Load(ctx context.Context) (Values, error) {
+	sec, err := s.Clientset.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		return Values{}, fmt.Errorf("get secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	return fromSecret(sec)
+}
+
+func (s *Secret) Watch(ctx context.Context, update func(Values)) error {
+	logger := log.FromContext(ctx).WithValues("secret", s.Namespace+"/"+s.Name)
+	factory := informers.NewSharedInformerFactoryWithOptions(s.Clientset, 0,
+		informers.WithNamespace(s.Namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.Name).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	onChange := func(obj any) {
+		sec, ok := obj.(*corev1.Secret)
+		if !ok {
+			return
+		}
+		v, err := fromSecret(sec)
+		if err != nil {
+			logger.Error(err, "keeping current OPNsense credentials")
+			return
+		}
+		update(v)
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    onChange,
+		UpdateFunc: func(_, obj any) { onChange(obj) },
+		DeleteFunc: func(any) {
+			logger.Info("secret deleted; keeping current OPNsense credentials")
+		},
+	}); err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	if !toolscache.WaitForCacheSync(ctx.Done(), informer.HasSynced) && ctx.Err() == nil {
+		return errors.New("secret informer did not sync")
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func fromSecret(sec *corev1.Secret) (Values, error) {
+	data := make(map[string]string, len(sec.Data))
+	for k, v := range sec.Data {
+		data[k] = string(v)
+	}
+	return fromData(fmt.Sprintf("secret %s/%s", sec.Namespace, sec.Name), data)
+}
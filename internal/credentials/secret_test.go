@@ -0,0 +1,71 @@
+package credentials
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func opnsenseSecret(data map[string]string) *corev1.Secret {
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "opnsense"},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		sec.Data[k] = []byte(v)
+	}
+	return sec
+}
+
+func TestSecret_LoadAndWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset := kubefake.NewClientset(opnsenseSecret(map[string]string{"url": "https://fw", "apiKey": "k1", "apiSecret": "s1"}))
+	p := NewSecret(clientset, "kube-system", "opnsense")
+
+	v, err := p.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if v.URL != "https://fw" || v.Key != "k1" {
+		t.Errorf("Load: got %+v, want https://fw and k1", v)
+	}
+
+	updates := make(chan Values, 10)
+	done := make(chan error, 1)
+	go func() { done <- p.Watch(ctx, func(v Values) { updates <- v }) }()
+	next := func() Values {
+		t.Helper()
+		select {
+		case v := <-updates:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update from Watch")
+			return Values{}
+		}
+	}
+	if v := next(); v.Key != "k1" {
+		t.Errorf("initial update: got key %q, want k1", v.Key)
+	}
+
+	secrets := clientset.CoreV1().Secrets("kube-system")
+	// Invalid data is skipped; the next valid rotation is delivered.
+	if _, err := secrets.Update(ctx, opnsenseSecret(map[string]string{"key": "k2"}), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := secrets.Update(ctx, opnsenseSecret(map[string]string{"key": "k3", "secret": "s3"}), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if v := next(); v.Key != "k3" || v.Secret != "s3" {
+		t.Errorf("after rotation: got %+v, want k3/s3", v)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch: %v", err)
+	}
+}
@@ -1,35 +1,146 @@
 package opnsense
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // NATRule represents one OPNsense DNAT rule (external port → target IP:port).
 // UUID is set when the rule is returned from the API (for updates/deletes).
+// Disabled is only meaningful for listed rules; rules are always added enabled.
+// PortName is the Service port the rule serves; it is encoded in the description on add,
+// which is always derived from the rule's Owner (see Owner.Description) plus Note, if any.
+// Interface is the interface the rule listens on (empty means DefaultInterface) and SourceNet
+// the source network or alias it matches (empty means any source). NoAutoPass creates the rule
+// without filter rule association, so only an explicit FilterRule passes its traffic; otherwise
+// the firewall's default association applies, which differs between OPNsense versions.
 type NATRule struct {
 	UUID         string `json:"uuid,omitempty"`
 	ExternalPort int    `json:"-"`
 	Protocol     string `json:"-"`
 	TargetIP     string `json:"-"`
 	TargetPort   int    `json:"-"`
+	PortName     string `json:"-"`
+	Interface    string `json:"-"`
+	SourceNet    string `json:"-"`
+	Log          bool   `json:"-"`
+	Note         string `json:"-"`
+	NoAutoPass   bool   `json:"-"`
 	Description  string `json:"-"`
+	Disabled     bool   `json:"-"`
+}
+
+// DefaultInterface is the interface NAT rules and VIPs are created on unless one is given.
+const DefaultInterface = "wan"
+
+// interfaceName matches OPNsense interface identifiers such as "wan", "lan" or "opt1".
+var interfaceName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// IsInterfaceName reports whether s is a valid OPNsense interface identifier.
+func IsInterfaceName(s string) bool {
+	return interfaceName.MatchString(s)
+}
+
+// VIP represents one OPNsense virtual IP (interfaces/vip_settings item).
+type VIP struct {
+	UUID        string
+	Subnet      string
+	Interface   string
+	Mode        string
+	Description string
+}
+
+// Address returns the VIP address without its prefix length (e.g. "192.0.2.1" for "192.0.2.1/32").
+func (v VIP) Address() string {
+	addr, _, _ := strings.Cut(v.Subnet, "/")
+	return addr
+}
+
+// APIError is returned when OPNsense answered a request with a non-200 status.
+type APIError struct {
+	// Endpoint is the API controller and action, e.g. "d_nat add_rule".
+	Endpoint   string
+	StatusCode int
+	Status     string
+}
+
+func newAPIError(endpoint string, resp *http.Response) *APIError {
+	return &APIError{Endpoint: endpoint, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("opnsense %s: %s", e.Endpoint, e.Status)
+}
+
+// RuleError records a desired NAT rule that OPNsense refused to add.
+type RuleError struct {
+	Rule NATRule
+	Err  error
+}
+
+// ApplyError is returned by ApplyNATRules when OPNsense refused some of the desired rules.
+// All other rules were applied.
+type ApplyError struct {
+	Failed []RuleError
+}
+
+func (e *ApplyError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		msgs = append(msgs, fmt.Sprintf("%s/%d: %v", f.Rule.Protocol, f.Rule.ExternalPort, f.Err))
+	}
+	return fmt.Sprintf("%d NAT rule(s) not applied: %s", len(e.Failed), strings.Join(msgs, "; "))
 }
 
 // Client talks to the OPNsense API for NAT and VIP management.
-// serviceKey is the Service identifier (namespace/name) used to scope NAT rules per service.
+// owner scopes NAT rules per Service: ApplyNATRules replaces exactly the rules whose description
+// decodes (see ParseTag) to a Tag owned by owner.
+// ApplyNATRules returns an *ApplyError when OPNsense rejected only some of the desired rules.
+// VIPs are tagged with the creating Identity; RemoveVIP only removes VIPs that identity adopts.
+// Filter rules and aliases are scoped per Service like NAT rules: ApplyFilterRules makes the
+// owner's filter rules match desired without removing the ones that already do, EnsureAliases creates or updates aliases and RemoveAliases deletes the
+// owner's aliases except keep.
 type Client interface {
 	ListNATRules(ctx context.Context) ([]NATRule, error)
-	ApplyNATRules(ctx context.Context, desired []NATRule, managedBy, serviceKey string) error
-	EnsureVIP(ctx context.Context, vip string) error
-	RemoveVIP(ctx context.Context, vip string) error
+	ApplyNATRules(ctx context.Context, desired []NATRule, owner Owner) error
+	ListFilterRules(ctx context.Context) ([]FilterRule, error)
+	ApplyFilterRules(ctx context.Context, desired []FilterRule, owner Owner) error
+	ListAliases(ctx context.Context) ([]Alias, error)
+	EnsureAliases(ctx context.Context, aliases []Alias, owner Owner) error
+	RemoveAliases(ctx context.Context, owner Owner, keep []string) error
+	ListVIPs(ctx context.Context) ([]VIP, error)
+	EnsureVIP(ctx context.Context, vip, iface string, id Identity) error
+	RemoveVIP(ctx context.Context, vip string, id Identity) error
+	// SetCredentials replaces the API key and secret used by every later request, e.g. after
+	// the key was rotated; requests already sent keep the old ones.
+	SetCredentials(creds Credentials)
 }
 
-// Config holds OPNsense API connection settings.
+// Credentials are an OPNsense API key and secret.
+type Credentials struct {
+	Key    string
+	Secret string
+}
+
+// IsAuthError reports whether err is an *APIError for rejected credentials (401 or 403).
+func IsAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// Config holds OPNsense API connection settings. APIKey and APISecret are the initial
+// credentials; see Client.SetCredentials.
 type Config struct {
 	BaseURL   string
 	APIKey    string
@@ -44,18 +155,33 @@ func NewClient(cfg Config) Client {
 	if c.cfg.Client == nil {
 		c.cfg.Client = http.DefaultClient
 	}
+	c.SetCredentials(Credentials{Key: cfg.APIKey, Secret: cfg.APISecret})
 	return c
 }
 
 type client struct {
-	cfg Config
+	cfg   Config
+	creds atomic.Pointer[Credentials]
+}
+
+func (c *client) SetCredentials(creds Credentials) {
+	c.creds.Store(&creds)
 }
 
 // searchRuleResponse matches OPNsense search_rule JSON (rows array).
+// Fields mirror rulePayload; disabled is "1" for rules disabled in the UI.
 type searchRuleResponse struct {
 	Rows []struct {
 		UUID        string `json:"uuid"`
 		Description string `json:"description"`
+		Interface   string `json:"interface"`
+		Protocol    string `json:"protocol"`
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		Target      string `json:"target"`
+		Log         string `json:"log"`
+		Association string `json:"association"`
+		Disabled    string `json:"disabled"`
 	} `json:"rows"`
 }
 
@@ -63,10 +189,16 @@ type searchRuleResponse struct {
 type rulePayload struct {
 	Rule struct {
 		Description string `json:"description"`
+		Interface   string `json:"interface"`
 		Protocol    string `json:"protocol"`
+		// Source is a network or "any".
+		Source string `json:"source"`
 		// Destination and target: OPNsense uses interface/dest port and target host/port.
 		Destination string `json:"destination"`
 		Target      string `json:"target"`
+		Log         string `json:"log"`
+		// Association is the filter rule association; omitted to use the firewall's default.
+		Association string `json:"association,omitempty"`
 	} `json:"rule"`
 }
 
@@ -77,19 +209,18 @@ func (c *client) ListNATRules(ctx context.Context) ([]NATRule, error) {
 	if err != nil {
 		return nil, err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
 	q := req.URL.Query()
 	q.Set("current", "1")
 	q.Set("rowCount", "10000")
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "d_nat search_rule")
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("opnsense d_nat search_rule: %s", resp.Status)
+		return nil, newAPIError("d_nat search_rule", resp)
 	}
 	var out searchRuleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
@@ -97,13 +228,34 @@ func (c *client) ListNATRules(ctx context.Context) ([]NATRule, error) {
 	}
 	var rules []NATRule
 	for _, row := range out.Rows {
-		rules = append(rules, NATRule{UUID: row.UUID, Description: row.Description})
+		rule := NATRule{
+			UUID:        row.UUID,
+			Description: row.Description,
+			Interface:   row.Interface,
+			Protocol:    row.Protocol,
+			Log:         row.Log == "1",
+			NoAutoPass:  row.Association == "none",
+			Disabled:    row.Disabled == "1",
+		}
+		if row.Source != "any" {
+			rule.SourceNet = row.Source
+		}
+		_, rule.Note = splitNote(row.Description)
+		// Destination is "<network>/<port>" and target "<ip>:<port>", as written by addRule.
+		if i := strings.LastIndex(row.Destination, "/"); i >= 0 {
+			rule.ExternalPort, _ = strconv.Atoi(row.Destination[i+1:])
+		}
+		if host, port, err := net.SplitHostPort(row.Target); err == nil {
+			rule.TargetIP = host
+			rule.TargetPort, _ = strconv.Atoi(port)
+		}
+		rules = append(rules, rule)
 	}
 	return rules, nil
 }
 
-func (c *client) ApplyNATRules(ctx context.Context, desired []NATRule, managedBy, serviceKey string) error {
-	current, err := c.listManagedRulesForService(ctx, managedBy, serviceKey)
+func (c *client) ApplyNATRules(ctx context.Context, desired []NATRule, owner Owner) error {
+	current, err := c.listManagedRulesForService(ctx, owner)
 	if err != nil {
 		return err
 	}
@@ -113,9 +265,15 @@ func (c *client) ApplyNATRules(ctx context.Context, desired []NATRule, managedBy
 			return err
 		}
 	}
+	// A rule rejected by the API does not stop the others; transport errors abort.
+	var failed []RuleError
 	for _, r := range desired {
-		if err := c.addRule(ctx, r, managedBy, serviceKey); err != nil {
-			return err
+		if err := c.addRule(ctx, r, owner); err != nil {
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				return err
+			}
+			failed = append(failed, RuleError{Rule: r, Err: err})
 		}
 	}
 	if len(current) > 0 || len(desired) > 0 {
@@ -123,50 +281,58 @@ func (c *client) ApplyNATRules(ctx context.Context, desired []NATRule, managedBy
 			return err
 		}
 	}
+	if len(failed) > 0 {
+		return &ApplyError{Failed: failed}
+	}
 	return nil
 }
 
-// listManagedRulesForService returns NAT rules whose description contains both managedBy and serviceKey.
-func (c *client) listManagedRulesForService(ctx context.Context, managedBy, serviceKey string) ([]NATRule, error) {
+// listManagedRulesForService returns NAT rules whose description decodes to a Tag owned by owner,
+// including rules written in the legacy description format.
+func (c *client) listManagedRulesForService(ctx context.Context, owner Owner) ([]NATRule, error) {
 	all, err := c.ListNATRules(ctx)
 	if err != nil {
 		return nil, err
 	}
 	var out []NATRule
 	for _, r := range all {
-		if strings.Contains(r.Description, managedBy) && strings.Contains(r.Description, serviceKey) {
+		if ownsObject(ctx, KindNATRule, r.Description, owner) {
 			out = append(out, r)
 		}
 	}
 	return out, nil
 }
 
-func (c *client) addRule(ctx context.Context, r NATRule, managedBy, serviceKey string) error {
+func (c *client) addRule(ctx context.Context, r NATRule, owner Owner) error {
 	base := strings.TrimSuffix(c.cfg.BaseURL, "/")
 	u := base + "/api/firewall/d_nat/add_rule"
-	desc := r.Description
-	if desc == "" {
-		desc = fmt.Sprintf("%s %s %s:%d->%s:%d", managedBy, serviceKey, r.Protocol, r.ExternalPort, r.TargetIP, r.TargetPort)
-	}
 	payload := rulePayload{}
-	payload.Rule.Description = desc
+	payload.Rule.Description = WithNote(owner.Description(r.PortName), r.Note)
+	payload.Rule.Interface = cmp.Or(r.Interface, DefaultInterface)
 	payload.Rule.Protocol = strings.ToUpper(r.Protocol)
+	payload.Rule.Source = cmp.Or(r.SourceNet, "any")
 	payload.Rule.Destination = fmt.Sprintf("0.0.0.0/%d", r.ExternalPort)
 	payload.Rule.Target = fmt.Sprintf("%s:%d", r.TargetIP, r.TargetPort)
+	payload.Rule.Log = "0"
+	if r.Log {
+		payload.Rule.Log = "1"
+	}
+	if r.NoAutoPass {
+		payload.Rule.Association = "none"
+	}
 	body, _ := json.Marshal(payload)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(string(body)))
 	if err != nil {
 		return err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "d_nat add_rule")
 	if err != nil {
 		return err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense d_nat add_rule: %s", resp.Status)
+		return newAPIError("d_nat add_rule", resp)
 	}
 	return nil
 }
@@ -178,14 +344,13 @@ func (c *client) delRule(ctx context.Context, uuid string) error {
 	if err != nil {
 		return err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "d_nat del_rule")
 	if err != nil {
 		return err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense d_nat del_rule: %s", resp.Status)
+		return newAPIError("d_nat del_rule", resp)
 	}
 	return nil
 }
@@ -198,14 +363,13 @@ func (c *client) applyFirewall(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "filter_base savepoint")
 	if err != nil {
 		return err
 	}
 	_ = resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense filter_base savepoint: %s", resp.Status)
+		return newAPIError("filter_base savepoint", resp)
 	}
 	// Apply (commit). Use revision from savepoint if needed; some versions accept apply without param.
 	applyURL := base + "/api/firewall/filter_base/apply"
@@ -213,14 +377,13 @@ func (c *client) applyFirewall(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	req2.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp2, err := c.cfg.Client.Do(req2)
+	resp2, err := c.send(req2, "filter_base apply")
 	if err != nil {
 		return err
 	}
 	_ = resp2.Body.Close()
 	if resp2.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense filter_base apply: %s", resp2.Status)
+		return newAPIError("filter_base apply", resp2)
 	}
 	return nil
 }
@@ -228,21 +391,23 @@ func (c *client) applyFirewall(ctx context.Context) error {
 // vipSearchResponse matches OPNsense interfaces/vip_settings search_item response.
 type vipSearchResponse struct {
 	Rows []struct {
-		UUID      string `json:"uuid"`
-		Subnet    string `json:"subnet"`
-		Interface string `json:"interface"`
-		Mode      string `json:"mode"`
+		UUID        string `json:"uuid"`
+		Subnet      string `json:"subnet"`
+		Interface   string `json:"interface"`
+		Mode        string `json:"mode"`
+		Description string `json:"description"`
 	} `json:"rows"`
 }
 
-// EnsureVIP ensures the given VIP exists as an IP alias on OPNsense.
-// If the VIP is already present (e.g. pre-configured), this is a no-op.
-// The VIP is tagged via description so we can identify it for RemoveVIP.
-func (c *client) EnsureVIP(ctx context.Context, vip string) error {
+// EnsureVIP ensures the given VIP exists as an IP alias on interface iface (empty means
+// DefaultInterface) on OPNsense. If the VIP is already present (e.g. pre-configured), on any
+// interface, this is a no-op. The VIP is tagged with id via description so we can identify it
+// for RemoveVIP.
+func (c *client) EnsureVIP(ctx context.Context, vip, iface string, id Identity) error {
 	if vip == "" {
 		return nil
 	}
-	existing, err := c.listVIPs(ctx)
+	existing, err := c.ListVIPs(ctx)
 	if err != nil {
 		return err
 	}
@@ -252,68 +417,87 @@ func (c *client) EnsureVIP(ctx context.Context, vip string) error {
 			return nil
 		}
 	}
-	return c.addVIP(ctx, vip, subnet)
+	return c.addVIP(ctx, subnet, cmp.Or(iface, DefaultInterface), id.VIPDescription(vip))
 }
 
-// RemoveVIP removes the given VIP (IP alias) from OPNsense if we manage it.
-func (c *client) RemoveVIP(ctx context.Context, vip string) error {
+// RemoveVIP removes the given VIP (IP alias) from OPNsense if it is tagged with an identity id
+// adopts. Pre-configured VIPs and VIPs of other clusters are left alone.
+func (c *client) RemoveVIP(ctx context.Context, vip string, id Identity) error {
 	if vip == "" {
 		return nil
 	}
-	existing, err := c.listVIPs(ctx)
+	existing, err := c.ListVIPs(ctx)
 	if err != nil {
 		return err
 	}
-	subnet := vip + "/32"
 	for _, r := range existing {
-		if r.Subnet == subnet {
+		if ownsVIP(ctx, r, vip, id) {
 			return c.delVIP(ctx, r.UUID)
 		}
 	}
 	return nil
 }
 
-func (c *client) listVIPs(ctx context.Context) ([]struct{ UUID, Subnet, Interface, Mode string }, error) {
+// ownsVIP reports whether v is the IP alias of vip and tagged with an identity id adopts. VIPs
+// taken over through Identity.AdoptUnclustered are logged.
+func ownsVIP(ctx context.Context, v VIP, vip string, id Identity) bool {
+	tag, ok := ParseVIPTag(v.Description)
+	if !ok || v.Subnet != vip+"/32" || !id.Adopts(tag.Identity) {
+		return false
+	}
+	if id.Migrates(tag.Identity) {
+		logAdoption(ctx, KindVIP, v.Description, id)
+	}
+	return true
+}
+
+// ListVIPs returns all virtual IPs configured on OPNsense, managed or not.
+func (c *client) ListVIPs(ctx context.Context) ([]VIP, error) {
 	base := strings.TrimSuffix(c.cfg.BaseURL, "/")
 	u := base + "/api/interfaces/vip_settings/search_item"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
 	q := req.URL.Query()
 	q.Set("current", "1")
 	q.Set("rowCount", "10000")
 	req.URL.RawQuery = q.Encode()
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "vip_settings search_item")
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("opnsense vip_settings search_item: %s", resp.Status)
+		return nil, newAPIError("vip_settings search_item", resp)
 	}
 	var out vipSearchResponse
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return nil, err
 	}
-	var result []struct{ UUID, Subnet, Interface, Mode string }
+	result := make([]VIP, 0, len(out.Rows))
 	for _, r := range out.Rows {
-		result = append(result, struct{ UUID, Subnet, Interface, Mode string }{r.UUID, r.Subnet, r.Interface, r.Mode})
+		result = append(result, VIP{
+			UUID:        r.UUID,
+			Subnet:      r.Subnet,
+			Interface:   r.Interface,
+			Mode:        r.Mode,
+			Description: r.Description,
+		})
 	}
 	return result, nil
 }
 
-func (c *client) addVIP(ctx context.Context, vip, subnet string) error {
+func (c *client) addVIP(ctx context.Context, subnet, iface, description string) error {
 	base := strings.TrimSuffix(c.cfg.BaseURL, "/")
 	u := base + "/api/interfaces/vip_settings/add_item"
 	// OPNsense VIP add_item: mode=ipalias, interface (e.g. wan), subnet (e.g. 192.0.2.1/32), description
 	payload := map[string]any{
 		"vip": map[string]string{
 			"mode":        "ipalias",
-			"interface":   "wan",
+			"interface":   iface,
 			"subnet":      subnet,
-			"description": "opnsense-lb-controller " + vip,
+			"description": description,
 		},
 	}
 	body, _ := json.Marshal(payload)
@@ -321,27 +505,25 @@ func (c *client) addVIP(ctx context.Context, vip, subnet string) error {
 	if err != nil {
 		return err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "vip_settings add_item")
 	if err != nil {
 		return err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense vip_settings add_item: %s", resp.Status)
+		return newAPIError("vip_settings add_item", resp)
 	}
 	// Apply interface reconfigure so the VIP is actually applied.
 	reconfURL := base + "/api/interfaces/vip_settings/reconfigure"
 	req2, _ := http.NewRequestWithContext(ctx, http.MethodPost, reconfURL, nil)
-	req2.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp2, err := c.cfg.Client.Do(req2)
+	resp2, err := c.send(req2, "vip_settings reconfigure")
 	if err != nil {
 		return err
 	}
 	_ = resp2.Body.Close()
 	if resp2.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense vip_settings reconfigure: %s", resp2.Status)
+		return newAPIError("vip_settings reconfigure", resp2)
 	}
 	return nil
 }
@@ -353,19 +535,17 @@ func (c *client) delVIP(ctx context.Context, uuid string) error {
 	if err != nil {
 		return err
 	}
-	req.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp, err := c.cfg.Client.Do(req)
+	resp, err := c.send(req, "vip_settings del_item")
 	if err != nil {
 		return err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("opnsense vip_settings del_item: %s", resp.Status)
+		return newAPIError("vip_settings del_item", resp)
 	}
 	reconfURL := base + "/api/interfaces/vip_settings/reconfigure"
 	req2, _ := http.NewRequestWithContext(ctx, http.MethodPost, reconfURL, nil)
-	req2.SetBasicAuth(c.cfg.APIKey, c.cfg.APISecret)
-	resp2, _ := c.cfg.Client.Do(req2)
+	resp2, _ := c.send(req2, "vip_settings reconfigure")
 	if resp2 != nil {
 		_ = resp2.Body.Close()
 	}
@@ -3,10 +3,14 @@ package opnsense
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 )
 
 const apiPathDNatSearchRule = "/api/firewall/d_nat/search_rule"
@@ -38,22 +42,78 @@ func TestClient_ApplyNATRules_HTTP(t *testing.T) {
 	cli := NewClient(cfg)
 	ctx := context.Background()
 	desired := []NATRule{
-		{ExternalPort: 80, Protocol: "tcp", TargetIP: "10.0.0.1", TargetPort: 30080, Description: "managed"},
+		{ExternalPort: 80, Protocol: "tcp", TargetIP: "10.0.0.1", TargetPort: 30080, PortName: "http"},
 	}
-	err := cli.ApplyNATRules(ctx, desired, "opnsense-lb-controller", "default/my-svc")
+	err := cli.ApplyNATRules(ctx, desired, Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "my-svc"})
 	if err != nil {
 		t.Fatalf("ApplyNATRules: %v", err)
 	}
 }
 
+// TestClient_ApplyNATRules_ruleOptions verifies that interface, source, log, note and filter rule
+// association are sent on add and decoded again by ListNATRules.
+func TestClient_ApplyNATRules_ruleOptions(t *testing.T) {
+	var rows []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == apiPathDNatSearchRule && r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode(map[string]any{"rows": rows})
+		case r.URL.Path == "/api/firewall/d_nat/add_rule" && r.Method == http.MethodPost:
+			var payload rulePayload
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			rows = append(rows, map[string]string{
+				"uuid": "u1", "description": payload.Rule.Description, "interface": payload.Rule.Interface,
+				"protocol": payload.Rule.Protocol, "source": payload.Rule.Source, "destination": payload.Rule.Destination,
+				"target": payload.Rule.Target, "log": payload.Rule.Log, "association": payload.Rule.Association,
+			})
+		case strings.HasPrefix(r.URL.Path, "/api/firewall/filter_base/"):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	ctx := context.Background()
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}
+	plain := NATRule{ExternalPort: 80, Protocol: "TCP", TargetIP: "10.0.0.1", TargetPort: 30080}
+	custom := NATRule{ExternalPort: 443, Protocol: "TCP", TargetIP: "10.0.0.1", TargetPort: 30443,
+		Interface: "opt1", SourceNet: "198.51.100.0/24", Log: true, Note: "Public website", NoAutoPass: true}
+	if err := cli.ApplyNATRules(ctx, []NATRule{plain, custom}, owner); err != nil {
+		t.Fatalf("ApplyNATRules: %v", err)
+	}
+	if rows[0]["interface"] != DefaultInterface || rows[0]["source"] != "any" || rows[0]["log"] != "0" ||
+		rows[0]["association"] != "" {
+		t.Errorf("defaults: got %v", rows[0])
+	}
+	listed, err := cli.ListNATRules(ctx)
+	if err != nil {
+		t.Fatalf("ListNATRules: %v", err)
+	}
+	got := listed[1]
+	if got.Interface != "opt1" || got.SourceNet != "198.51.100.0/24" || !got.Log || got.Note != "Public website" ||
+		!got.NoAutoPass {
+		t.Errorf("listed rule: got %+v, want the options of %+v", got, custom)
+	}
+	if tag, ok := ParseTag(got.Description); !ok || !tag.OwnedBy(owner) {
+		t.Errorf("description %q does not decode to a tag owned by %+v", got.Description, owner)
+	}
+	if listed[0].SourceNet != "" || listed[0].Note != "" {
+		t.Errorf("listed default rule: got %+v", listed[0])
+	}
+}
+
 func TestClient_ListNATRules_HTTP(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		if r.URL.Path == apiPathDNatSearchRule && r.Method == http.MethodGet {
 			_ = json.NewEncoder(w).Encode(map[string]any{
 				"rows": []map[string]string{
-					{"uuid": "a1", "description": "opnsense-lb-controller rule1"},
-					{"uuid": "b2", "description": "other"},
+					{"uuid": "a1", "description": "opnsense-lb-controller rule1", "protocol": "TCP",
+						"destination": "0.0.0.0/80", "target": "10.0.0.1:30080", "disabled": "0"},
+					{"uuid": "b2", "description": "other", "disabled": "1"},
 				},
 			})
 		} else {
@@ -80,17 +140,44 @@ func TestClient_ListNATRules_HTTP(t *testing.T) {
 	if rules[0].UUID != "a1" || rules[0].Description != "opnsense-lb-controller rule1" {
 		t.Errorf("first rule: got uuid=%q desc=%q", rules[0].UUID, rules[0].Description)
 	}
+	if rules[0].Protocol != "TCP" || rules[0].ExternalPort != 80 || rules[0].TargetIP != "10.0.0.1" ||
+		rules[0].TargetPort != 30080 || rules[0].Disabled {
+		t.Errorf("first rule: got %+v, want TCP 80 -> 10.0.0.1:30080 enabled", rules[0])
+	}
+	if !rules[1].Disabled {
+		t.Error("second rule: expected Disabled to be decoded")
+	}
 }
 
 func TestClient_EnsureVIP_RemoveVIP_HTTP(t *testing.T) {
-	var addVIPCalled bool
+	var addedDesc, deleted string
+	listed := false
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		switch {
 		case r.URL.Path == "/api/interfaces/vip_settings/search_item" && r.Method == http.MethodGet:
-			_ = json.NewEncoder(w).Encode(map[string]any{"rows": []any{}})
+			if !listed {
+				listed = true
+				_ = json.NewEncoder(w).Encode(map[string]any{"rows": []any{}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"rows": []map[string]string{
+					{"uuid": "mine", "subnet": "192.0.2.1/32", "description": "lbc/v1 by=opnsense-lb-controller cluster=prod vip=192.0.2.1"},
+					{"uuid": "other", "subnet": "192.0.2.2/32", "description": "lbc/v1 by=opnsense-lb-controller cluster=staging vip=192.0.2.2"},
+					{"uuid": "manual", "subnet": "192.0.2.3/32", "description": "uplink alias"},
+					{"uuid": "legacy", "subnet": "192.0.2.4/32", "description": "opnsense-lb-controller 192.0.2.4"},
+				},
+			})
 		case r.URL.Path == "/api/interfaces/vip_settings/add_item" && r.Method == http.MethodPost:
-			addVIPCalled = true
+			var payload struct {
+				VIP map[string]string `json:"vip"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			addedDesc = payload.VIP["description"]
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/api/interfaces/vip_settings/del_item/") && r.Method == http.MethodPost:
+			deleted = strings.TrimPrefix(r.URL.Path, "/api/interfaces/vip_settings/del_item/")
 			w.WriteHeader(http.StatusOK)
 		case r.URL.Path == "/api/interfaces/vip_settings/reconfigure" && r.Method == http.MethodPost:
 			w.WriteHeader(http.StatusOK)
@@ -103,27 +190,92 @@ func TestClient_EnsureVIP_RemoveVIP_HTTP(t *testing.T) {
 	cfg := Config{BaseURL: server.URL, Client: server.Client()}
 	cli := NewClient(cfg)
 	ctx := context.Background()
-	err := cli.EnsureVIP(ctx, "192.0.2.1")
+	id := Identity{ManagedBy: "opnsense-lb-controller", ClusterID: "prod"}
+	err := cli.EnsureVIP(ctx, "192.0.2.1", "", id)
 	if err != nil {
 		t.Fatalf("EnsureVIP: %v", err)
 	}
-	if !addVIPCalled {
-		t.Error("EnsureVIP did not call add_item")
+	if addedDesc != "lbc/v1 by=opnsense-lb-controller cluster=prod vip=192.0.2.1" {
+		t.Errorf("EnsureVIP add_item description: got %q", addedDesc)
+	}
+
+	// RemoveVIP must only remove VIPs tagged with an identity it adopts: VIPs without a cluster ID
+	// only with AdoptUnclustered, which logs each of them.
+	migrating := id
+	migrating.AdoptUnclustered = true
+	for vip, wantDel := range map[string]struct{ exact, migrating string }{
+		"192.0.2.1": {"mine", "mine"},
+		"192.0.2.2": {"", ""},
+		"192.0.2.3": {"", ""},
+		"192.0.2.4": {"", "legacy"},
+	} {
+		for _, id := range []Identity{id, migrating} {
+			var logged []string
+			ctx := logr.NewContext(ctx, funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{}))
+			deleted = ""
+			if err := cli.RemoveVIP(ctx, vip, id); err != nil {
+				t.Fatalf("RemoveVIP(%s): %v", vip, err)
+			}
+			want := wantDel.exact
+			if id.AdoptUnclustered {
+				want = wantDel.migrating
+			}
+			if deleted != want {
+				t.Errorf("RemoveVIP(%s, AdoptUnclustered=%v) deleted %q, want %q", vip, id.AdoptUnclustered, deleted, want)
+			}
+			if adopted := want != wantDel.exact; adopted != (len(logged) == 1) {
+				t.Errorf("RemoveVIP(%s, AdoptUnclustered=%v) logged %q", vip, id.AdoptUnclustered, logged)
+			}
+		}
+	}
+}
+
+func TestClient_ListVIPs_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/interfaces/vip_settings/search_item" && r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"rows": []map[string]string{
+					{"uuid": "v1", "subnet": "192.0.2.1/32", "interface": "wan", "mode": "ipalias",
+						"description": "opnsense-lb-controller 192.0.2.1"},
+				},
+			})
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	vips, err := cli.ListVIPs(context.Background())
+	if err != nil {
+		t.Fatalf("ListVIPs: %v", err)
+	}
+	if len(vips) != 1 {
+		t.Fatalf("ListVIPs: got %d VIPs, want 1", len(vips))
+	}
+	if vips[0].Address() != "192.0.2.1" || vips[0].Description != "opnsense-lb-controller 192.0.2.1" {
+		t.Errorf("ListVIPs: got address=%q desc=%q", vips[0].Address(), vips[0].Description)
 	}
 }
 
 // TestClient_ApplyNATRules_perServiceScoping verifies that ApplyNATRules only deletes rules
-// for the given serviceKey (description contains both managedBy and serviceKey).
+// tagged with exactly the given owner (or its legacy description), never rules of Services
+// whose name shares a prefix, and that added rules carry the owner's tag.
 func TestClient_ApplyNATRules_perServiceScoping(t *testing.T) {
-	var delUUIDs []string
+	var delUUIDs, addedDescs []string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		switch {
 		case r.URL.Path == "/api/firewall/d_nat/search_rule" && r.Method == http.MethodGet:
 			_ = json.NewEncoder(w).Encode(map[string]any{
 				"rows": []map[string]string{
-					{"uuid": "u1", "description": "managed-by-controller ns/svc1 192.0.2.1"},
-					{"uuid": "u2", "description": "managed-by-controller ns/svc2 192.0.2.2"},
+					{"uuid": "u1", "description": "lbc/v1 by=managed-by-controller cluster= svc=ns/web uid=1 port=http"},
+					{"uuid": "u2", "description": "lbc/v1 by=managed-by-controller svc=ns/web-admin uid=2 port=http"},
+					{"uuid": "u3", "description": "managed-by-controller ns/webhook 192.0.2.3"},
+					{"uuid": "u4", "description": "managed-by-controller ns/web 192.0.2.1"},
+					{"uuid": "u5", "description": "lbc/v1 by=other-controller svc=ns/web uid=1 port=http"},
+					{"uuid": "u6", "description": "operator forward for managed-by-controller ns/web"},
 				},
 			})
 		case strings.HasPrefix(r.URL.Path, "/api/firewall/d_nat/del_rule/") && r.Method == http.MethodPost:
@@ -131,6 +283,9 @@ func TestClient_ApplyNATRules_perServiceScoping(t *testing.T) {
 			delUUIDs = append(delUUIDs, uuid)
 			w.WriteHeader(http.StatusOK)
 		case r.URL.Path == "/api/firewall/d_nat/add_rule" && r.Method == http.MethodPost:
+			var payload rulePayload
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			addedDescs = append(addedDescs, payload.Rule.Description)
 			w.WriteHeader(http.StatusOK)
 		case r.URL.Path == "/api/firewall/filter_base/savepoint" && r.Method == http.MethodPost:
 			_ = json.NewEncoder(w).Encode(map[string]any{"revision": "123"})
@@ -146,14 +301,93 @@ func TestClient_ApplyNATRules_perServiceScoping(t *testing.T) {
 	cli := NewClient(cfg)
 	ctx := context.Background()
 	desired := []NATRule{
-		{ExternalPort: 80, Protocol: "tcp", TargetIP: "10.0.0.1", TargetPort: 30080, Description: "managed-by-controller ns/svc1 192.0.2.1"},
+		{ExternalPort: 80, Protocol: "tcp", TargetIP: "10.0.0.1", TargetPort: 30080, PortName: "http"},
 	}
-	err := cli.ApplyNATRules(ctx, desired, "managed-by-controller", "ns/svc1")
+	owner := Owner{Identity: Identity{ManagedBy: "managed-by-controller"}, Namespace: "ns", Name: "web", UID: "1"}
+	err := cli.ApplyNATRules(ctx, desired, owner)
 	if err != nil {
 		t.Fatalf("ApplyNATRules: %v", err)
 	}
-	// Only u1 (ns/svc1) should be deleted, not u2 (ns/svc2).
-	if len(delUUIDs) != 1 || delUUIDs[0] != "u1" {
-		t.Errorf("ApplyNATRules deleted wrong rules: got delUUIDs=%v, want [u1]", delUUIDs)
+	// Only u1 (ns/web) and its legacy rule u4 should be deleted.
+	if strings.Join(delUUIDs, ",") != "u1,u4" {
+		t.Errorf("ApplyNATRules deleted wrong rules: got delUUIDs=%v, want [u1 u4]", delUUIDs)
+	}
+	want := "lbc/v1 by=managed-by-controller cluster= svc=ns/web uid=1 port=http"
+	if len(addedDescs) != 1 || addedDescs[0] != want {
+		t.Errorf("added descriptions: got %q, want [%q]", addedDescs, want)
+	}
+}
+
+// TestClient_ApplyNATRules_partialFailure verifies that a rule rejected by OPNsense does not
+// prevent the remaining rules from being added and applied.
+func TestClient_ApplyNATRules_partialFailure(t *testing.T) {
+	var added []string
+	var applied bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == apiPathDNatSearchRule && r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode(map[string]any{"rows": []any{}})
+		case r.URL.Path == "/api/firewall/d_nat/add_rule" && r.Method == http.MethodPost:
+			var payload rulePayload
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			if payload.Rule.Protocol == "UDP" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			added = append(added, payload.Rule.Destination)
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/api/firewall/filter_base/savepoint" && r.Method == http.MethodPost:
+			_ = json.NewEncoder(w).Encode(map[string]any{"revision": "123"})
+		case r.URL.Path == "/api/firewall/filter_base/apply" && r.Method == http.MethodPost:
+			applied = true
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	desired := []NATRule{
+		{ExternalPort: 53, Protocol: "udp", TargetIP: "10.0.0.1", TargetPort: 30053},
+		{ExternalPort: 80, Protocol: "tcp", TargetIP: "10.0.0.1", TargetPort: 30080},
+	}
+	err := cli.ApplyNATRules(context.Background(), desired, Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "my-svc"})
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("ApplyNATRules: got %v, want *ApplyError", err)
+	}
+	if len(applyErr.Failed) != 1 || applyErr.Failed[0].Rule.ExternalPort != 53 {
+		t.Errorf("ApplyError.Failed: got %+v, want the UDP/53 rule", applyErr.Failed)
+	}
+	var apiErr *APIError
+	if !errors.As(applyErr.Failed[0].Err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("RuleError.Err: got %v, want *APIError with status 400", applyErr.Failed[0].Err)
+	}
+	if len(added) != 1 || !applied {
+		t.Errorf("expected the TCP/80 rule to be added and applied, added=%v applied=%v", added, applied)
+	}
+}
+
+func TestClient_SetCredentials(t *testing.T) {
+	valid := Credentials{Key: "k2", Secret: "s2"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, secret, _ := r.BasicAuth(); key != valid.Key || secret != valid.Secret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"rows": []map[string]string{}})
+	}))
+	defer server.Close()
+
+	cli := NewClient(Config{BaseURL: server.URL, APIKey: "k1", APISecret: "s1", Client: server.Client()})
+	if _, err := cli.ListVIPs(context.Background()); !IsAuthError(err) {
+		t.Fatalf("ListVIPs with old credentials: got %v, want an auth error", err)
+	}
+	cli.SetCredentials(valid)
+	if _, err := cli.ListVIPs(context.Background()); err != nil {
+		t.Errorf("ListVIPs with rotated credentials: %v", err)
 	}
 }
@@ -0,0 +1,345 @@
+package opnsense
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Actions of a planned Change.
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// Kinds of the objects a Change applies to.
+const (
+	KindNATRule    = "nat_rule"
+	KindFilterRule = "filter_rule"
+	KindAlias      = "alias"
+	KindVIP        = "vip"
+)
+
+// Change is one change a DryRun client held back. Object describes the object as it would be
+// after the change, or as it is for a delete; Was is its current state for an update.
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	Was    string `json:"was,omitempty"`
+}
+
+func (c Change) String() string {
+	s := c.Action + " " + strings.ReplaceAll(c.Kind, "_", " ") + " " + c.Object
+	if c.Was != "" {
+		s += " (was " + c.Was + ")"
+	}
+	return s
+}
+
+// Plan is a snapshot of the changes a DryRun client held back: by Service key, and VIPs by
+// address, since VIP calls carry no Service.
+type Plan struct {
+	Services map[string][]Change `json:"services"`
+	VIPs     map[string]Change   `json:"vips"`
+}
+
+// DryRun is a Client that passes reads to the wrapped Client but makes no changes. Each
+// mutating call compares its arguments with what the wrapped Client lists and records the
+// creates, updates and deletes it would make, replacing what the previous call of the same
+// method planned for that Service or VIP. Calls that would fail for a reason other than the
+// firewall's response, e.g. taking over an alias of another owner, fail the same way.
+//
+// DryRun does not embed the wrapped Client: each method is implemented explicitly, so a
+// method added to Client cannot reach the firewall unnoticed. DryRun serves the current Plan as
+// JSON over HTTP.
+type DryRun struct {
+	reads Client
+
+	mu       sync.Mutex
+	services map[string]*servicePlan
+	vips     map[string]Change
+}
+
+// servicePlan holds the changes of one Service by the method that planned them.
+type servicePlan struct {
+	nat, filter, ensureAliases, removeAliases []Change
+}
+
+func (p *servicePlan) changes() []Change {
+	return slices.Concat(p.ensureAliases, p.nat, p.filter, p.removeAliases)
+}
+
+// NewDryRun returns a DryRun client reading from c.
+func NewDryRun(c Client) *DryRun {
+	return &DryRun{reads: c, services: make(map[string]*servicePlan), vips: make(map[string]Change)}
+}
+
+var _ Client = (*DryRun)(nil)
+
+func (d *DryRun) ListNATRules(ctx context.Context) ([]NATRule, error) {
+	return d.reads.ListNATRules(ctx)
+}
+
+func (d *DryRun) ListFilterRules(ctx context.Context) ([]FilterRule, error) {
+	return d.reads.ListFilterRules(ctx)
+}
+
+func (d *DryRun) ListAliases(ctx context.Context) ([]Alias, error) {
+	return d.reads.ListAliases(ctx)
+}
+
+func (d *DryRun) ListVIPs(ctx context.Context) ([]VIP, error) {
+	return d.reads.ListVIPs(ctx)
+}
+
+// SetCredentials passes creds to the wrapped Client, which needs them for its reads.
+func (d *DryRun) SetCredentials(creds Credentials) { d.reads.SetCredentials(creds) }
+
+// ServicePlan returns the changes held back for the Service key, "namespace/name".
+func (d *DryRun) ServicePlan(key string) []Change {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.services[key]; ok {
+		return p.changes()
+	}
+	return nil
+}
+
+// VIPPlan returns the change held back for vip, if any.
+func (d *DryRun) VIPPlan(vip string) (Change, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.vips[vip]
+	return c, ok
+}
+
+// Plan returns every change held back.
+func (d *DryRun) Plan() Plan {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := Plan{Services: make(map[string][]Change, len(d.services)), VIPs: maps.Clone(d.vips)}
+	for key, sp := range d.services {
+		p.Services[key] = sp.changes()
+	}
+	return p
+}
+
+// ServeHTTP writes the Plan as JSON.
+func (d *DryRun) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(d.Plan())
+}
+
+// record stores changes as what method set planned for the Service key.
+func (d *DryRun) record(key string, set func(*servicePlan)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.services[key]
+	if !ok {
+		p = &servicePlan{}
+		d.services[key] = p
+	}
+	set(p)
+	if len(p.changes()) == 0 {
+		delete(d.services, key)
+	}
+}
+
+func (d *DryRun) ApplyNATRules(ctx context.Context, desired []NATRule, owner Owner) error {
+	all, err := d.ListNATRules(ctx)
+	if err != nil {
+		return err
+	}
+	var current []NATRule
+	for _, r := range all {
+		if ownsObject(ctx, KindNATRule, r.Description, owner) {
+			current = append(current, r)
+		}
+	}
+	key := func(r NATRule) string {
+		return fmt.Sprintf("%s %s:%d->%s:%d", strings.ToUpper(r.Protocol), cmp.Or(r.Interface, DefaultInterface),
+			r.ExternalPort, r.TargetIP, r.TargetPort)
+	}
+	var changes []Change
+	for _, r := range desired {
+		r.Description = WithNote(owner.Description(r.PortName), r.Note)
+		i := slices.IndexFunc(current, func(c NATRule) bool { return key(c) == key(r) })
+		if i < 0 {
+			changes = append(changes, Change{Action: PlanCreate, Kind: KindNATRule, Object: natRuleString(r)})
+			continue
+		}
+		if cur := current[i]; cur.SourceNet != r.SourceNet || cur.Log != r.Log || cur.NoAutoPass != r.NoAutoPass ||
+			cur.Disabled || cur.Description != r.Description {
+			changes = append(changes, Change{Action: PlanUpdate, Kind: KindNATRule, Object: natRuleString(r), Was: natRuleString(cur)})
+		}
+		current = slices.Delete(current, i, i+1)
+	}
+	for _, r := range current {
+		changes = append(changes, Change{Action: PlanDelete, Kind: KindNATRule, Object: natRuleString(r)})
+	}
+	d.record(owner.ServiceKey(), func(p *servicePlan) { p.nat = changes })
+	return nil
+}
+
+func (d *DryRun) ApplyFilterRules(ctx context.Context, desired []FilterRule, owner Owner) error {
+	all, err := d.ListFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+	var current []FilterRule
+	for _, r := range all {
+		if ownsObject(ctx, KindFilterRule, r.Description, owner) {
+			current = append(current, r)
+		}
+	}
+	key := func(r FilterRule) string {
+		source := cmp.Or(r.SourceNet, "any")
+		if r.SourceNot {
+			source = "!" + source
+		}
+		return fmt.Sprintf("%s %s %s from %s to %s:%d", cmp.Or(r.Action, ActionPass), strings.ToUpper(r.Protocol),
+			cmp.Or(r.Interface, DefaultInterface), source, r.DestinationIP, r.DestinationPort)
+	}
+	var changes []Change
+	for _, r := range desired {
+		r.Description = WithNote(owner.Description(r.PortName), r.Note)
+		i := slices.IndexFunc(current, func(c FilterRule) bool { return key(c) == key(r) })
+		if i < 0 {
+			changes = append(changes, Change{Action: PlanCreate, Kind: KindFilterRule, Object: filterRuleString(r, key)})
+			continue
+		}
+		if cur := current[i]; cur.Log != r.Log || cur.Sequence != r.Sequence || cur.Disabled || cur.Description != r.Description {
+			changes = append(changes, Change{Action: PlanUpdate, Kind: KindFilterRule,
+				Object: filterRuleString(r, key), Was: filterRuleString(cur, key)})
+		}
+		current = slices.Delete(current, i, i+1)
+	}
+	for _, r := range current {
+		changes = append(changes, Change{Action: PlanDelete, Kind: KindFilterRule, Object: filterRuleString(r, key)})
+	}
+	d.record(owner.ServiceKey(), func(p *servicePlan) { p.filter = changes })
+	return nil
+}
+
+func (d *DryRun) EnsureAliases(ctx context.Context, aliases []Alias, owner Owner) error {
+	var changes []Change
+	if len(aliases) > 0 {
+		existing, err := d.ListAliases(ctx)
+		if err != nil {
+			return err
+		}
+		for _, a := range aliases {
+			i := slices.IndexFunc(existing, func(e Alias) bool { return e.Name == a.Name })
+			switch {
+			case i < 0:
+				changes = append(changes, Change{Action: PlanCreate, Kind: KindAlias, Object: aliasString(a)})
+			case !ownsObject(ctx, KindAlias, existing[i].Description, owner):
+				return errors.New("opnsense alias " + a.Name + " exists and is not managed by " + owner.ManagedBy)
+			case existing[i].Type != a.Type || !slices.Equal(existing[i].Content, a.Content):
+				changes = append(changes, Change{Action: PlanUpdate, Kind: KindAlias, Object: aliasString(a), Was: aliasString(existing[i])})
+			}
+		}
+	}
+	d.record(owner.ServiceKey(), func(p *servicePlan) { p.ensureAliases = changes })
+	return nil
+}
+
+func (d *DryRun) RemoveAliases(ctx context.Context, owner Owner, keep []string) error {
+	existing, err := d.ListAliases(ctx)
+	if err != nil {
+		return err
+	}
+	var changes []Change
+	for _, a := range existing {
+		if ownsObject(ctx, KindAlias, a.Description, owner) && !slices.Contains(keep, a.Name) {
+			changes = append(changes, Change{Action: PlanDelete, Kind: KindAlias, Object: aliasString(a)})
+		}
+	}
+	d.record(owner.ServiceKey(), func(p *servicePlan) { p.removeAliases = changes })
+	return nil
+}
+
+func (d *DryRun) EnsureVIP(ctx context.Context, vip, iface string, id Identity) error {
+	if vip == "" {
+		return nil
+	}
+	existing, err := d.ListVIPs(ctx)
+	if err != nil {
+		return err
+	}
+	exists := slices.ContainsFunc(existing, func(v VIP) bool { return v.Subnet == vip+"/32" })
+	d.recordVIP(vip, Change{Action: PlanCreate, Kind: KindVIP, Object: vip + "/32 on " + cmp.Or(iface, DefaultInterface)}, !exists)
+	return nil
+}
+
+func (d *DryRun) RemoveVIP(ctx context.Context, vip string, id Identity) error {
+	if vip == "" {
+		return nil
+	}
+	existing, err := d.ListVIPs(ctx)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(existing, func(v VIP) bool { return ownsVIP(ctx, v, vip, id) })
+	var object string
+	if i >= 0 {
+		object = existing[i].Subnet + " on " + existing[i].Interface
+	}
+	d.recordVIP(vip, Change{Action: PlanDelete, Kind: KindVIP, Object: object}, i >= 0)
+	return nil
+}
+
+// recordVIP stores c as the change planned for vip, or clears it when planned is false.
+func (d *DryRun) recordVIP(vip string, c Change, planned bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if planned {
+		d.vips[vip] = c
+	} else {
+		delete(d.vips, vip)
+	}
+}
+
+func natRuleString(r NATRule) string {
+	s := fmt.Sprintf("%s %s:%d->%s:%d", strings.ToUpper(r.Protocol), cmp.Or(r.Interface, DefaultInterface),
+		r.ExternalPort, r.TargetIP, r.TargetPort)
+	if r.SourceNet != "" {
+		s += " from " + r.SourceNet
+	}
+	if r.Log {
+		s += " (logged)"
+	}
+	if r.Disabled {
+		s += " (disabled)"
+	}
+	return s + fmt.Sprintf(" %q", r.Description)
+}
+
+func filterRuleString(r FilterRule, key func(FilterRule) string) string {
+	s := key(r)
+	if r.Sequence > 0 {
+		s += fmt.Sprintf(" at %d", r.Sequence)
+	}
+	if r.Log {
+		s += " (logged)"
+	}
+	if r.Disabled {
+		s += " (disabled)"
+	}
+	return s + fmt.Sprintf(" %q", r.Description)
+}
+
+func aliasString(a Alias) string {
+	return fmt.Sprintf("%s (%s: %s)", a.Name, a.Type, strings.Join(a.Content, ", "))
+}
@@ -0,0 +1,124 @@
+package opnsense
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// listOnly serves fixed List results; any other call panics on the nil Client.
+type listOnly struct {
+	Client
+	nat     []NATRule
+	filters []FilterRule
+	aliases []Alias
+	vips    []VIP
+}
+
+func (l *listOnly) ListNATRules(context.Context) ([]NATRule, error)       { return l.nat, nil }
+func (l *listOnly) ListFilterRules(context.Context) ([]FilterRule, error) { return l.filters, nil }
+func (l *listOnly) ListAliases(context.Context) ([]Alias, error)          { return l.aliases, nil }
+func (l *listOnly) ListVIPs(context.Context) ([]VIP, error)               { return l.vips, nil }
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web", UID: "u1"}
+	other := Owner{Identity: owner.Identity, Namespace: "default", Name: "other"}
+	fw := &listOnly{
+		nat: []NATRule{
+			{Interface: "wan", Protocol: "TCP", ExternalPort: 80, TargetIP: "10.0.0.1", TargetPort: 30080, Description: owner.Description("http")},
+			{Interface: "wan", Protocol: "TCP", ExternalPort: 443, TargetIP: "10.0.0.1", TargetPort: 30443, Description: owner.Description("https")},
+			{Interface: "wan", Protocol: "TCP", ExternalPort: 22, TargetIP: "10.0.0.1", TargetPort: 30022, Description: owner.Description("ssh")},
+			{Interface: "wan", Protocol: "TCP", ExternalPort: 80, TargetIP: "10.0.0.9", TargetPort: 30080, Description: other.Description("http")},
+		},
+		aliases: []Alias{
+			{Name: "lbc_x_src", Type: "network", Content: []string{"10.0.0.0/8"}, Description: owner.Description("")},
+			{Name: "lbc_x_old", Type: "network", Content: []string{"10.1.0.0/16"}, Description: owner.Description("")},
+			{Name: "foreign", Type: "host", Description: "created by hand"},
+		},
+		vips: []VIP{{Subnet: "192.0.2.2/32", Interface: "wan", Description: owner.VIPDescription("192.0.2.2")}},
+	}
+	dry := NewDryRun(fw)
+
+	err := dry.ApplyNATRules(ctx, []NATRule{
+		{Protocol: "tcp", ExternalPort: 80, TargetIP: "10.0.0.1", TargetPort: 30080, PortName: "http"},
+		{Protocol: "tcp", ExternalPort: 443, TargetIP: "10.0.0.1", TargetPort: 30443, PortName: "https", Log: true},
+		{Protocol: "tcp", ExternalPort: 8080, TargetIP: "10.0.0.1", TargetPort: 30888, PortName: "alt"},
+	}, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.EnsureAliases(ctx, []Alias{{Name: "lbc_x_src", Type: "network", Content: []string{"10.0.0.0/16"}}}, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.RemoveAliases(ctx, owner, []string{"lbc_x_src"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.EnsureAliases(ctx, []Alias{{Name: "foreign", Type: "host"}}, other); err == nil {
+		t.Error("EnsureAliases over an alias of another owner: want error")
+	}
+	if err := dry.EnsureVIP(ctx, "192.0.2.1", "", owner.Identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.RemoveVIP(ctx, "192.0.2.2", owner.Identity); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range dry.ServicePlan("default/web") {
+		got = append(got, c.Action+" "+c.Kind+" "+c.Object[:strings.Index(c.Object, " ")])
+	}
+	want := []string{
+		"update alias lbc_x_src",
+		"update nat_rule TCP",
+		"create nat_rule TCP",
+		"delete nat_rule TCP",
+		"delete alias lbc_x_old",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ServicePlan = %q, want %q", got, want)
+	}
+	if plan := dry.ServicePlan("default/other"); len(plan) != 0 {
+		t.Errorf("ServicePlan(default/other) = %v, want none", plan)
+	}
+	if c, ok := dry.VIPPlan("192.0.2.1"); !ok || c.Action != PlanCreate || c.Object != "192.0.2.1/32 on wan" {
+		t.Errorf("VIPPlan(192.0.2.1) = %v, %v", c, ok)
+	}
+	if c, ok := dry.VIPPlan("192.0.2.2"); !ok || c.Action != PlanDelete {
+		t.Errorf("VIPPlan(192.0.2.2) = %v, %v", c, ok)
+	}
+
+	rec := httptest.NewRecorder()
+	dry.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/plan", nil))
+	var served Plan
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served.Services["default/web"]) != len(want) || len(served.VIPs) != 2 {
+		t.Errorf("served plan = %+v", served)
+	}
+
+	// A call that finds nothing to do replaces the earlier plan.
+	fw.vips = append(fw.vips, VIP{Subnet: "192.0.2.1/32", Interface: "wan"})
+	if err := dry.EnsureVIP(ctx, "192.0.2.1", "", owner.Identity); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dry.VIPPlan("192.0.2.1"); ok {
+		t.Error("VIPPlan(192.0.2.1) after the VIP exists: want none")
+	}
+	fw.nat, fw.aliases = nil, nil
+	for _, err := range []error{
+		dry.ApplyNATRules(ctx, nil, owner),
+		dry.EnsureAliases(ctx, nil, owner),
+		dry.RemoveAliases(ctx, owner, nil),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if plan := dry.ServicePlan("default/web"); len(plan) != 0 {
+		t.Errorf("ServicePlan after the firewall matches = %v, want none", plan)
+	}
+}
@@ -0,0 +1,393 @@
+package opnsense
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// Alias is an OPNsense firewall alias (firewall/alias item). Aliases created by this package
+// are tagged with their Owner's description, like NAT rules.
+type Alias struct {
+	UUID string
+	// Name is referenced by rules; see Owner.AliasName.
+	Name string
+	// Type is the alias type: "network", "geoip" (Content lists ISO country codes) or
+	// "urltable" (Content lists URLs of IP lists OPNsense downloads).
+	Type        string
+	Content     []string
+	Description string
+}
+
+// Filter rule actions.
+const (
+	ActionPass  = "pass"
+	ActionBlock = "block"
+)
+
+// FilterRule is an OPNsense firewall filter rule (firewall/filter rule) passing or blocking the
+// traffic of a NAT rule. Filter rules on an interface see the translated destination, so
+// DestinationIP and DestinationPort are the NAT rule's target. SourceNet is a network or alias
+// name; empty means any source. SourceNot inverts it: the rule matches every other source.
+// Sequence orders the rule among all filter rules (lower is evaluated first); 0 lets OPNsense
+// append it.
+type FilterRule struct {
+	UUID string
+	// Action is ActionPass (or empty) or ActionBlock.
+	Action          string
+	Interface       string
+	Protocol        string
+	SourceNet       string
+	SourceNot       bool
+	DestinationIP   string
+	DestinationPort int
+	// PortName is the Service port the rule serves; it is encoded in the description on add.
+	PortName string
+	Log      bool
+	Sequence int
+	// Note is appended to the description (see WithNote).
+	Note        string
+	Description string
+	Disabled    bool
+}
+
+// AliasName returns the alias name for o's aliases of the given purpose, e.g. "src". OPNsense
+// limits alias names to 32 letters, digits and underscores, so the Service is encoded as a hash
+// of the identity and Service key.
+func (o Owner) AliasName(purpose string) string {
+	sum := sha256.Sum256([]byte(o.ManagedBy + "/" + o.ClusterID + "/" + o.ServiceKey()))
+	return "lbc_" + hex.EncodeToString(sum[:])[:16] + "_" + purpose
+}
+
+// aliasSearchResponse matches OPNsense firewall/alias search_item JSON. content lists one
+// entry per line.
+type aliasSearchResponse struct {
+	Rows []struct {
+		UUID        string `json:"uuid"`
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Content     string `json:"content"`
+		Description string `json:"description"`
+	} `json:"rows"`
+}
+
+// aliasPayload is sent to add_item and set_item.
+type aliasPayload struct {
+	Alias struct {
+		Enabled     string `json:"enabled"`
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		Content     string `json:"content"`
+		Description string `json:"description"`
+	} `json:"alias"`
+}
+
+// filterSearchResponse matches OPNsense firewall/filter search_rule JSON. Fields mirror
+// filterRulePayload; enabled is "0" for rules disabled in the UI.
+type filterSearchResponse struct {
+	Rows []struct {
+		UUID            string `json:"uuid"`
+		Enabled         string `json:"enabled"`
+		Action          string `json:"action"`
+		Interface       string `json:"interface"`
+		Protocol        string `json:"protocol"`
+		SourceNet       string `json:"source_net"`
+		SourceNot       string `json:"source_not"`
+		DestinationNet  string `json:"destination_net"`
+		DestinationPort string `json:"destination_port"`
+		Log             string `json:"log"`
+		Sequence        string `json:"sequence"`
+		Description     string `json:"description"`
+	} `json:"rows"`
+}
+
+// filterRulePayload is sent to add_rule. Rules are always quick inbound IPv4 rules.
+type filterRulePayload struct {
+	Rule struct {
+		Enabled         string `json:"enabled"`
+		Action          string `json:"action"`
+		Quick           string `json:"quick"`
+		Interface       string `json:"interface"`
+		Direction       string `json:"direction"`
+		IPProtocol      string `json:"ipprotocol"`
+		Protocol        string `json:"protocol"`
+		SourceNet       string `json:"source_net"`
+		SourceNot       string `json:"source_not"`
+		DestinationNet  string `json:"destination_net"`
+		DestinationPort string `json:"destination_port"`
+		Log             string `json:"log"`
+		Sequence        string `json:"sequence,omitempty"`
+		Description     string `json:"description"`
+	} `json:"rule"`
+}
+
+// ListAliases returns all firewall aliases configured on OPNsense, managed or not.
+func (c *client) ListAliases(ctx context.Context) ([]Alias, error) {
+	var out aliasSearchResponse
+	if err := c.do(ctx, http.MethodGet, "/api/firewall/alias/search_item", "alias search_item", nil, &out); err != nil {
+		return nil, err
+	}
+	aliases := make([]Alias, 0, len(out.Rows))
+	for _, row := range out.Rows {
+		aliases = append(aliases, Alias{
+			UUID:        row.UUID,
+			Name:        row.Name,
+			Type:        row.Type,
+			Content:     strings.FieldsFunc(row.Content, func(r rune) bool { return r == '\n' || r == ',' }),
+			Description: row.Description,
+		})
+	}
+	return aliases, nil
+}
+
+// EnsureAliases creates each alias, tagged with owner, or updates it when an alias of that name
+// owned by owner differs. An alias of that name not owned by owner is an error: it is never
+// taken over.
+func (c *client) EnsureAliases(ctx context.Context, aliases []Alias, owner Owner) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+	existing, err := c.ListAliases(ctx)
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for _, a := range aliases {
+		var payload aliasPayload
+		payload.Alias.Enabled = "1"
+		payload.Alias.Name = a.Name
+		payload.Alias.Type = a.Type
+		payload.Alias.Content = strings.Join(a.Content, "\n")
+		payload.Alias.Description = owner.Description("")
+		i := slices.IndexFunc(existing, func(e Alias) bool { return e.Name == a.Name })
+		switch {
+		case i < 0:
+			err = c.do(ctx, http.MethodPost, "/api/firewall/alias/add_item", "alias add_item", payload, nil)
+		case !ownsObject(ctx, KindAlias, existing[i].Description, owner):
+			return errors.New("opnsense alias " + a.Name + " exists and is not managed by " + owner.ManagedBy)
+		case existing[i].Type == a.Type && slices.Equal(existing[i].Content, a.Content):
+			continue
+		default:
+			err = c.do(ctx, http.MethodPost, "/api/firewall/alias/set_item/"+url.PathEscape(existing[i].UUID),
+				"alias set_item", payload, nil)
+		}
+		if err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return c.do(ctx, http.MethodPost, "/api/firewall/alias/reconfigure", "alias reconfigure", nil, nil)
+}
+
+// RemoveAliases deletes the aliases owned by owner whose names are not in keep. Rules referencing
+// them must be removed first.
+func (c *client) RemoveAliases(ctx context.Context, owner Owner, keep []string) error {
+	existing, err := c.ListAliases(ctx)
+	if err != nil {
+		return err
+	}
+	var removed bool
+	for _, a := range existing {
+		if !ownsObject(ctx, KindAlias, a.Description, owner) || slices.Contains(keep, a.Name) {
+			continue
+		}
+		if err := c.do(ctx, http.MethodPost, "/api/firewall/alias/del_item/"+url.PathEscape(a.UUID),
+			"alias del_item", nil, nil); err != nil {
+			return err
+		}
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return c.do(ctx, http.MethodPost, "/api/firewall/alias/reconfigure", "alias reconfigure", nil, nil)
+}
+
+// ListFilterRules returns all firewall filter rules on OPNsense, managed or not.
+func (c *client) ListFilterRules(ctx context.Context) ([]FilterRule, error) {
+	var out filterSearchResponse
+	if err := c.do(ctx, http.MethodGet, "/api/firewall/filter/search_rule", "filter search_rule", nil, &out); err != nil {
+		return nil, err
+	}
+	rules := make([]FilterRule, 0, len(out.Rows))
+	for _, row := range out.Rows {
+		rule := FilterRule{
+			UUID:          row.UUID,
+			Action:        row.Action,
+			Interface:     row.Interface,
+			Protocol:      row.Protocol,
+			SourceNot:     row.SourceNot == "1",
+			DestinationIP: row.DestinationNet,
+			Log:           row.Log == "1",
+			Description:   row.Description,
+			Disabled:      row.Enabled == "0",
+		}
+		if row.SourceNet != "any" {
+			rule.SourceNet = row.SourceNet
+		}
+		rule.DestinationPort, _ = strconv.Atoi(row.DestinationPort)
+		rule.Sequence, _ = strconv.Atoi(row.Sequence)
+		_, rule.Note = splitNote(row.Description)
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ApplyFilterRules makes the filter rules owned by owner match desired. Rules that already match
+// are kept, so traffic they pass is never interrupted; missing rules are added before extra ones
+// are deleted, and the changes are applied at once. When an add fails, nothing is deleted.
+func (c *client) ApplyFilterRules(ctx context.Context, desired []FilterRule, owner Owner) error {
+	all, err := c.ListFilterRules(ctx)
+	if err != nil {
+		return err
+	}
+	var current []FilterRule
+	for _, r := range all {
+		if ownsObject(ctx, KindFilterRule, r.Description, owner) {
+			current = append(current, r)
+		}
+	}
+	var changed bool
+	for _, r := range desired {
+		payload := newFilterRulePayload(r, owner)
+		if i := slices.IndexFunc(current, func(cur FilterRule) bool { return payload.matches(cur) }); i >= 0 {
+			current = slices.Delete(current, i, i+1)
+			continue
+		}
+		if err := c.do(ctx, http.MethodPost, "/api/firewall/filter/add_rule", "filter add_rule", payload, nil); err != nil {
+			return err
+		}
+		changed = true
+	}
+	for _, r := range current {
+		if err := c.do(ctx, http.MethodPost, "/api/firewall/filter/del_rule/"+url.PathEscape(r.UUID),
+			"filter del_rule", nil, nil); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return c.applyFirewall(ctx)
+}
+
+// newFilterRulePayload returns the add_rule payload of r, owned by owner.
+func newFilterRulePayload(r FilterRule, owner Owner) filterRulePayload {
+	var payload filterRulePayload
+	payload.Rule.Enabled = "1"
+	payload.Rule.Action = cmp.Or(r.Action, ActionPass)
+	payload.Rule.Quick = "1"
+	payload.Rule.Interface = cmp.Or(r.Interface, DefaultInterface)
+	payload.Rule.Direction = "in"
+	payload.Rule.IPProtocol = "inet"
+	payload.Rule.Protocol = strings.ToUpper(r.Protocol)
+	payload.Rule.SourceNet = cmp.Or(r.SourceNet, "any")
+	payload.Rule.SourceNot = "0"
+	if r.SourceNot {
+		payload.Rule.SourceNot = "1"
+	}
+	payload.Rule.DestinationNet = r.DestinationIP
+	payload.Rule.DestinationPort = strconv.Itoa(r.DestinationPort)
+	payload.Rule.Log = "0"
+	if r.Log {
+		payload.Rule.Log = "1"
+	}
+	if r.Sequence > 0 {
+		payload.Rule.Sequence = strconv.Itoa(r.Sequence)
+	}
+	payload.Rule.Description = WithNote(owner.Description(r.PortName), r.Note)
+	return payload
+}
+
+// matches reports whether the listed rule cur is enabled and already what p would create. A
+// payload without a sequence accepts the one OPNsense assigned.
+func (p filterRulePayload) matches(cur FilterRule) bool {
+	want := p.Rule
+	sourceNot := "0"
+	if cur.SourceNot {
+		sourceNot = "1"
+	}
+	log := "0"
+	if cur.Log {
+		log = "1"
+	}
+	return !cur.Disabled && cur.Action == want.Action && cur.Interface == want.Interface &&
+		strings.EqualFold(cur.Protocol, want.Protocol) && cmp.Or(cur.SourceNet, "any") == want.SourceNet &&
+		sourceNot == want.SourceNot && cur.DestinationIP == want.DestinationNet &&
+		strconv.Itoa(cur.DestinationPort) == want.DestinationPort && log == want.Log &&
+		(want.Sequence == "" || strconv.Itoa(cur.Sequence) == want.Sequence) && cur.Description == want.Description
+}
+
+// ownsObject reports whether desc, the description of an object of kind (e.g. KindAlias), decodes
+// to a tag owned by owner. Objects taken over through Identity.AdoptUnclustered are logged.
+func ownsObject(ctx context.Context, kind, desc string, owner Owner) bool {
+	tag, ok := ParseTag(desc)
+	if !ok || !tag.OwnedBy(owner) {
+		return false
+	}
+	if owner.Migrates(tag.Identity) {
+		logAdoption(ctx, kind, desc, owner.Identity)
+	}
+	return true
+}
+
+// logAdoption logs that id takes over an object of kind tagged without a cluster ID.
+func logAdoption(ctx context.Context, kind, desc string, id Identity) {
+	logr.FromContextOrDiscard(ctx).Info("Adopting an object tagged without a cluster ID",
+		"kind", kind, "description", desc, "clusterID", id.ClusterID)
+}
+
+// do sends a request to path with payload, if any, encoded as JSON and decodes the response into
+// out, if any. A non-200 status is returned as an *APIError for endpoint.
+func (c *client) do(ctx context.Context, method, path, endpoint string, payload, out any) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := strings.TrimSuffix(c.cfg.BaseURL, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if method == http.MethodGet {
+		q := req.URL.Query()
+		q.Set("current", "1")
+		q.Set("rowCount", "10000")
+		req.URL.RawQuery = q.Encode()
+	}
+	resp, err := c.send(req, endpoint)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(endpoint, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
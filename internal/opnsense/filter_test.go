@@ -0,0 +1,147 @@
+package opnsense
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClient_EnsureAliases_RemoveAliases(t *testing.T) {
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}
+	other := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web-admin"}
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/firewall/alias/search_item":
+			_ = json.NewEncoder(w).Encode(map[string]any{"rows": []map[string]string{
+				{"uuid": "a1", "name": "web_src", "type": "network", "content": "198.51.100.0/24", "description": owner.Description("")},
+				{"uuid": "a2", "name": "same", "type": "network", "content": "192.0.2.0/24\n203.0.113.0/24", "description": owner.Description("")},
+				{"uuid": "a3", "name": "stale", "type": "network", "content": "192.0.2.0/24", "description": owner.Description("")},
+				{"uuid": "a4", "name": "admin_src", "type": "network", "content": "192.0.2.0/24", "description": other.Description("")},
+				{"uuid": "a5", "name": "operator", "type": "host", "content": "192.0.2.1", "description": "office"},
+			}})
+		case r.Method == http.MethodPost:
+			var payload aliasPayload
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			calls = append(calls, strings.TrimPrefix(r.URL.Path, "/api/firewall/alias/")+" "+payload.Alias.Content)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	ctx := context.Background()
+
+	err := cli.EnsureAliases(ctx, []Alias{
+		{Name: "web_src", Type: "network", Content: []string{"198.51.100.0/24", "203.0.113.7/32"}},
+		{Name: "same", Type: "network", Content: []string{"192.0.2.0/24", "203.0.113.0/24"}},
+		{Name: "new_src", Type: "network", Content: []string{"192.0.2.0/24"}},
+	}, owner)
+	if err != nil {
+		t.Fatalf("EnsureAliases: %v", err)
+	}
+	want := []string{"set_item/a1 198.51.100.0/24\n203.0.113.7/32", "add_item 192.0.2.0/24", "reconfigure "}
+	if !slices.Equal(calls, want) {
+		t.Errorf("EnsureAliases calls: got %q, want %q", calls, want)
+	}
+
+	if err := cli.EnsureAliases(ctx, []Alias{{Name: "operator", Type: "network"}}, owner); err == nil {
+		t.Error("EnsureAliases took over an alias it does not own")
+	}
+
+	calls = nil
+	if err := cli.RemoveAliases(ctx, owner, []string{"web_src", "same"}); err != nil {
+		t.Fatalf("RemoveAliases: %v", err)
+	}
+	if want := []string{"del_item/a3 ", "reconfigure "}; !slices.Equal(calls, want) {
+		t.Errorf("RemoveAliases calls: got %q, want %q", calls, want)
+	}
+}
+
+func TestClient_ApplyFilterRules(t *testing.T) {
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}
+	var deleted, calls []string
+	var added []filterRulePayload
+	var failAdd bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/firewall/filter/search_rule":
+			_ = json.NewEncoder(w).Encode(map[string]any{"rows": []map[string]string{
+				{"uuid": "f1", "enabled": "1", "source_net": "any", "destination_port": "30080", "description": owner.Description("http")},
+				{"uuid": "f2", "enabled": "0", "description": "lbc/v1 by=opnsense-lb-controller cluster= svc=default/web-admin uid= port="},
+				{"uuid": "f3", "enabled": "1", "action": "block", "source_not": "1", "description": "block non-EU"},
+				{"uuid": "f4", "enabled": "1", "action": "pass", "interface": "wan", "protocol": "UDP", "source_net": "any",
+					"source_not": "0", "destination_net": "10.0.0.1", "destination_port": "30053", "log": "0", "sequence": "17",
+					"description": owner.Description("dns")},
+			}})
+		case strings.HasPrefix(r.URL.Path, "/api/firewall/filter/del_rule/"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/firewall/filter/del_rule/"))
+			calls = append(calls, "del_rule")
+		case r.URL.Path == "/api/firewall/filter/add_rule":
+			var payload filterRulePayload
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			calls = append(calls, "add_rule")
+			if failAdd {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			added = append(added, payload)
+		case strings.HasPrefix(r.URL.Path, "/api/firewall/filter_base/"):
+			calls = append(calls, strings.TrimPrefix(r.URL.Path, "/api/firewall/filter_base/"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	ctx := context.Background()
+
+	listed, err := cli.ListFilterRules(ctx)
+	if err != nil {
+		t.Fatalf("ListFilterRules: %v", err)
+	}
+	if listed[0].SourceNet != "" || listed[0].DestinationPort != 30080 || listed[0].Disabled || !listed[1].Disabled ||
+		listed[2].Action != ActionBlock || !listed[2].SourceNot {
+		t.Errorf("ListFilterRules: got %+v", listed)
+	}
+
+	err = cli.ApplyFilterRules(ctx, []FilterRule{{Protocol: "tcp", SourceNet: "lbc_x_src", DestinationIP: "10.0.0.1",
+		DestinationPort: 30080, PortName: "http", Log: true, Sequence: 500, Note: "Pass default/web TCP/80"}, {
+		Action: ActionBlock, Protocol: "TCP", SourceNet: "lbc_x_geo", SourceNot: true, DestinationIP: "10.0.0.1",
+		DestinationPort: 30080, PortName: "http"}, {Protocol: "udp", DestinationIP: "10.0.0.1", DestinationPort: 30053,
+		PortName: "dns"}}, owner)
+	if err != nil {
+		t.Fatalf("ApplyFilterRules: %v", err)
+	}
+	if !slices.Equal(deleted, []string{"f1"}) {
+		t.Errorf("deleted: got %v, want [f1]; the matching f4 is kept", deleted)
+	}
+	if want := []string{"add_rule", "add_rule", "del_rule", "savepoint", "apply"}; !slices.Equal(calls, want) {
+		t.Errorf("calls: got %v, want %v: adds before deletes, applied once", calls, want)
+	}
+	if len(added) != 2 {
+		t.Fatalf("added: got %d rules, want 2", len(added))
+	}
+	got := added[0].Rule
+	if got.Action != ActionPass || got.SourceNot != "0" || got.Interface != DefaultInterface || got.Protocol != "TCP" || got.SourceNet != "lbc_x_src" ||
+		got.DestinationNet != "10.0.0.1" || got.DestinationPort != "30080" || got.Log != "1" || got.Sequence != "500" ||
+		got.Description != WithNote(owner.Description("http"), "Pass default/web TCP/80") {
+		t.Errorf("added rule: got %+v", got)
+	}
+	if got := added[1].Rule; got.Action != ActionBlock || got.SourceNet != "lbc_x_geo" || got.SourceNot != "1" {
+		t.Errorf("added block rule: got %+v, want a block rule for sources outside lbc_x_geo", got)
+	}
+
+	// A failed add leaves the current rules in place.
+	calls, failAdd = nil, true
+	err = cli.ApplyFilterRules(ctx, []FilterRule{{Protocol: "tcp", DestinationIP: "10.0.0.2", DestinationPort: 30080}}, owner)
+	if err == nil || !slices.Equal(calls, []string{"add_rule"}) {
+		t.Errorf("ApplyFilterRules with a failing add: got calls %v, %v; want an error before any delete", calls, err)
+	}
+}
@@ -0,0 +1,66 @@
+package opnsense
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// API call metrics, labeled by endpoint: the API controller and action as in APIError, e.g.
+// "d_nat add_rule" or "filter_base apply".
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_api_requests_total",
+		Help: "OPNsense API requests by endpoint and HTTP status code (\"none\" when no response was received).",
+	}, []string{"endpoint", "code"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "opnsense_lb_api_request_duration_seconds",
+		Help:    "OPNsense API request latency by endpoint, until the response headers were received.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"endpoint"})
+
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "opnsense_lb_api_errors_total",
+		Help: "Failed OPNsense API requests by endpoint and reason: timeout, transport, auth or status.",
+	}, []string{"endpoint", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiRequests, apiRequestDuration, apiErrors)
+}
+
+// send authenticates req with the current credentials, performs it and records it in the API
+// call metrics and a trace span for endpoint.
+func (c *client) send(req *http.Request, endpoint string) (*http.Response, error) {
+	creds := c.creds.Load()
+	req.SetBasicAuth(creds.Key, creds.Secret)
+	span := startSpan(req, endpoint)
+	start := time.Now()
+	resp, err := c.cfg.Client.Do(req)
+	endSpan(span, resp, err)
+	apiRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		apiRequests.WithLabelValues(endpoint, "none").Inc()
+		reason := "transport"
+		if errors.Is(err, context.DeadlineExceeded) {
+			reason = "timeout"
+		}
+		apiErrors.WithLabelValues(endpoint, reason).Inc()
+		return nil, err
+	}
+	apiRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		apiErrors.WithLabelValues(endpoint, "auth").Inc()
+	default:
+		apiErrors.WithLabelValues(endpoint, "status").Inc()
+	}
+	return resp, nil
+}
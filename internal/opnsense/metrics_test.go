@@ -0,0 +1,42 @@
+package opnsense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClient_recordsAPIMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/interfaces/vip_settings/search_item" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"rows":[]}`))
+	}))
+	defer server.Close()
+	cli := NewClient(Config{BaseURL: server.URL, Client: server.Client()})
+	ctx := context.Background()
+	searched := testutil.ToFloat64(apiRequests.WithLabelValues("d_nat search_rule", "200"))
+	rejected := testutil.ToFloat64(apiErrors.WithLabelValues("vip_settings search_item", "auth"))
+
+	if _, err := cli.ListNATRules(ctx); err != nil {
+		t.Fatalf("ListNATRules: %v", err)
+	}
+	if _, err := cli.ListVIPs(ctx); err == nil {
+		t.Fatal("ListVIPs: expected an error for 401")
+	}
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues("d_nat search_rule", "200")); got != searched+1 {
+		t.Errorf("d_nat search_rule requests: got %v, want %v", got, searched+1)
+	}
+	if got := testutil.ToFloat64(apiErrors.WithLabelValues("vip_settings search_item", "auth")); got != rejected+1 {
+		t.Errorf("vip_settings search_item auth errors: got %v, want %v", got, rejected+1)
+	}
+	if n := testutil.CollectAndCount(apiRequestDuration, "opnsense_lb_api_request_duration_seconds"); n < 2 {
+		t.Errorf("latency histograms: got %d, want one per endpoint called", n)
+	}
+}
@@ -0,0 +1,209 @@
+package opnsense
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// tagVersion starts every description written by this package. Descriptions that do not
+// start with it are only recognized in the legacy format (see ParseTag and ParseVIPTag).
+const tagVersion = "lbc/v1"
+
+// noteSeparator separates a tag from the free-text note appended by WithNote.
+const noteSeparator = " | "
+
+// Identity identifies one controller deployment on a firewall that may be shared by several
+// clusters.
+type Identity struct {
+	// ManagedBy identifies the controller, e.g. "opnsense-lb-controller".
+	ManagedBy string
+	// ClusterID distinguishes clusters sharing one firewall; empty for a single-cluster setup.
+	ClusterID string
+	// AdoptUnclustered lets an identity with a ClusterID also take over objects of the same
+	// controller tagged without a cluster ID, to migrate a single-cluster setup to a cluster ID.
+	// It is an explicit opt-in: another cluster sharing the firewall may still use the empty ID.
+	// Decoded tags never set it.
+	AdoptUnclustered bool
+}
+
+// Adopts reports whether id may modify objects tagged with other: the same controller and
+// cluster, or an object without a cluster ID that id migrates (see Migrates).
+func (id Identity) Adopts(other Identity) bool {
+	return id.Same(other) || id.Migrates(other)
+}
+
+// Migrates reports whether id adopts objects tagged with other only through AdoptUnclustered.
+func (id Identity) Migrates(other Identity) bool {
+	return id.AdoptUnclustered && id.ClusterID != "" && other.ClusterID == "" && id.ManagedBy == other.ManagedBy
+}
+
+// Same reports whether id and other are the same controller and cluster, ignoring
+// AdoptUnclustered.
+func (id Identity) Same(other Identity) bool {
+	return id.ManagedBy == other.ManagedBy && id.ClusterID == other.ClusterID
+}
+
+// VIPDescription returns the encoded description for a VIP created by id, e.g.
+// "lbc/v1 by=opnsense-lb-controller cluster=prod vip=192.0.2.1".
+func (id Identity) VIPDescription(vip string) string {
+	return fmt.Sprintf("%s by=%s cluster=%s vip=%s", tagVersion, id.ManagedBy, id.ClusterID, vip)
+}
+
+// Owner identifies the controller deployment and Service a managed firewall object belongs to.
+type Owner struct {
+	Identity
+	Namespace string
+	Name      string
+	// UID is the Service's metadata.uid. It is empty when unknown, e.g. the Service is already
+	// gone, and is not used for matching: rules of a deleted and re-created Service with the
+	// same name are replaced by the new one.
+	UID string
+}
+
+// ServiceKey returns the Service key (namespace/name) of o.
+func (o Owner) ServiceKey() string {
+	return o.Namespace + "/" + o.Name
+}
+
+// Description returns the encoded description for an object of o serving the Service port
+// named port (empty for an unnamed port), e.g.
+// "lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web uid=<uid> port=http".
+func (o Owner) Description(port string) string {
+	return fmt.Sprintf("%s by=%s cluster=%s svc=%s uid=%s port=%s",
+		tagVersion, o.ManagedBy, o.ClusterID, o.ServiceKey(), o.UID, port)
+}
+
+// WithNote appends a free-text note to a description written by Owner.Description, e.g.
+// "lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web uid=<uid> port=http | Public website".
+// ParseTag ignores the note. An empty note returns desc unchanged.
+func WithNote(desc, note string) string {
+	if note == "" {
+		return desc
+	}
+	return desc + noteSeparator + note
+}
+
+// splitNote splits a versioned description into its tag and the note appended by WithNote.
+// Legacy descriptions never carry a note.
+func splitNote(desc string) (tag, note string) {
+	if !strings.HasPrefix(desc, tagVersion+" ") {
+		return desc, ""
+	}
+	tag, note, _ = strings.Cut(desc, noteSeparator)
+	return tag, note
+}
+
+// Tag is the ownership metadata decoded from a managed object's description.
+type Tag struct {
+	Owner
+	// Port is the Service port name; empty for an unnamed port or a legacy description.
+	Port string
+	// Legacy is true for descriptions written before tags were versioned
+	// ("<managedBy> <namespace/name> <vip>"). They carry no cluster, UID or port.
+	Legacy bool
+}
+
+// OwnedBy reports whether t belongs to o: an identity o adopts (see Identity.Adopts) and exactly
+// the same Service key. UIDs are not compared (see Owner.UID).
+func (t Tag) OwnedBy(o Owner) bool {
+	return o.Adopts(t.Identity) && t.Namespace == o.Namespace && t.Name == o.Name
+}
+
+// ParseTag decodes a description written by Owner.Description, optionally followed by a note
+// (see WithNote), or one in the legacy format so rules created by earlier releases are still
+// recognized and replaced on the next sync. Parsing is strict: anything else, including operator
+// rules that merely mention a Service name, returns false.
+func ParseTag(desc string) (Tag, bool) {
+	desc, _ = splitNote(desc)
+	fields := strings.Split(desc, " ")
+	if fields[0] != tagVersion {
+		return parseLegacyTag(desc)
+	}
+	v, ok := parseTagFields(fields[1:], "by", "cluster", "svc", "uid", "port")
+	if !ok {
+		// Tags written before cluster IDs were introduced.
+		if v, ok = parseTagFields(fields[1:], "by", "svc", "uid", "port"); !ok {
+			return Tag{}, false
+		}
+	}
+	ns, name, ok := splitServiceKey(v["svc"])
+	if v["by"] == "" || !ok {
+		return Tag{}, false
+	}
+	return Tag{
+		Owner: Owner{
+			Identity:  Identity{ManagedBy: v["by"], ClusterID: v["cluster"]},
+			Namespace: ns,
+			Name:      name,
+			UID:       v["uid"],
+		},
+		Port: v["port"],
+	}, true
+}
+
+// VIPTag is the ownership metadata decoded from a managed VIP's description.
+type VIPTag struct {
+	Identity
+	VIP string
+	// Legacy is true for "<managedBy> <vip>" descriptions written by earlier releases.
+	Legacy bool
+}
+
+// ParseVIPTag decodes a description written by Identity.VIPDescription, or one in the legacy
+// format. Pre-configured VIPs and VIPs of other tools return false.
+func ParseVIPTag(desc string) (VIPTag, bool) {
+	fields := strings.Split(desc, " ")
+	if fields[0] != tagVersion {
+		fields = strings.Fields(desc)
+		if len(fields) != 2 || net.ParseIP(fields[1]) == nil {
+			return VIPTag{}, false
+		}
+		return VIPTag{Identity: Identity{ManagedBy: fields[0]}, VIP: fields[1], Legacy: true}, true
+	}
+	v, ok := parseTagFields(fields[1:], "by", "cluster", "vip")
+	if !ok || v["by"] == "" || net.ParseIP(v["vip"]) == nil {
+		return VIPTag{}, false
+	}
+	return VIPTag{Identity: Identity{ManagedBy: v["by"], ClusterID: v["cluster"]}, VIP: v["vip"]}, true
+}
+
+// parseTagFields requires fields to be exactly "<key>=<value>" for keys, in order.
+func parseTagFields(fields []string, keys ...string) (map[string]string, bool) {
+	if len(fields) != len(keys) {
+		return nil, false
+	}
+	values := make(map[string]string, len(keys))
+	for i, k := range keys {
+		v, ok := strings.CutPrefix(fields[i], k+"=")
+		if !ok {
+			return nil, false
+		}
+		values[k] = v
+	}
+	return values, true
+}
+
+// parseLegacyTag accepts "<managedBy> <namespace/name> <vip>", as written by earlier releases of
+// the controller, and "<managedBy> <namespace/name> <PROTO>:<port>-><ip>:<port>", the client's
+// former fallback description.
+func parseLegacyTag(desc string) (Tag, bool) {
+	fields := strings.Fields(desc)
+	if len(fields) != 3 {
+		return Tag{}, false
+	}
+	ns, name, ok := splitServiceKey(fields[1])
+	if !ok || (net.ParseIP(fields[2]) == nil && !strings.Contains(fields[2], "->")) {
+		return Tag{}, false
+	}
+	return Tag{Owner: Owner{Identity: Identity{ManagedBy: fields[0]}, Namespace: ns, Name: name}, Legacy: true}, true
+}
+
+// splitServiceKey splits "namespace/name", requiring both parts to be non-empty.
+func splitServiceKey(key string) (namespace, name string, ok bool) {
+	namespace, name, ok = strings.Cut(key, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	return namespace, name, true
+}
@@ -0,0 +1,90 @@
+package opnsense
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web", UID: "3f2a"}
+	tests := []struct {
+		name string
+		desc string
+		want Tag
+		ok   bool
+	}{
+		{"round trip", owner.Description("http"), Tag{Owner: owner, Port: "http"}, true},
+		{"cluster", "lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web uid= port=",
+			Tag{Owner: Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller", ClusterID: "prod"}, Namespace: "default", Name: "web"}}, true},
+		{"without cluster key", "lbc/v1 by=opnsense-lb-controller svc=default/web uid= port=",
+			Tag{Owner: Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}}, true},
+		{"legacy vip", "opnsense-lb-controller default/web 192.0.2.1",
+			Tag{Owner: Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}, Legacy: true}, true},
+		{"legacy fallback", "opnsense-lb-controller default/web TCP:80->10.0.0.1:30080",
+			Tag{Owner: Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller"}, Namespace: "default", Name: "web"}, Legacy: true}, true},
+		{"missing key", "lbc/v1 by=opnsense-lb-controller cluster= svc=default/web uid=3f2a", Tag{}, false},
+		{"reordered keys", "lbc/v1 svc=default/web by=opnsense-lb-controller cluster= uid=3f2a port=http", Tag{}, false},
+		{"note", WithNote(owner.Description("http"), "Public website | v2"), Tag{Owner: owner, Port: "http"}, true},
+		{"trailing text", owner.Description("http") + " extra", Tag{}, false},
+		{"empty managedBy", "lbc/v1 by= cluster= svc=default/web uid=3f2a port=http", Tag{}, false},
+		{"bad service key", "lbc/v1 by=opnsense-lb-controller cluster= svc=web uid=3f2a port=http", Tag{}, false},
+		{"unknown version", "lbc/v2 by=opnsense-lb-controller cluster= svc=default/web uid=3f2a port=http", Tag{}, false},
+		{"operator text", "forward for default/web", Tag{}, false},
+		{"legacy with free text", "opnsense-lb-controller default/web admin", Tag{}, false},
+		{"empty", "", Tag{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTag(tt.desc)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("ParseTag(%q) = %+v, %v; want %+v, %v", tt.desc, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTag_OwnedBy(t *testing.T) {
+	owner := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller", ClusterID: "prod"}, Namespace: "default", Name: "web", UID: "new"}
+	migrating := owner
+	migrating.AdoptUnclustered = true
+	for desc, want := range map[string]struct{ exact, migrating bool }{
+		"lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web uid=old port=http":       {true, true},
+		"lbc/v1 by=opnsense-lb-controller cluster= svc=default/web uid=old port=http":           {false, true},
+		"opnsense-lb-controller default/web 192.0.2.1":                                          {false, true},
+		"lbc/v1 by=opnsense-lb-controller cluster=staging svc=default/web uid=new port=http":    {false, false},
+		"lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web-admin uid=new port=http": {false, false},
+		"lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/webhook uid=new port=http":   {false, false},
+		"lbc/v1 by=opnsense-lb-controller cluster= svc=default/web-admin uid=new port=http":     {false, false},
+		"lbc/v1 by=other cluster=prod svc=default/web uid=new port=http":                        {false, false},
+		"lbc/v1 by=other cluster= svc=default/web uid=new port=http":                            {false, false},
+	} {
+		tag, ok := ParseTag(desc)
+		if !ok {
+			t.Fatalf("ParseTag(%q) failed", desc)
+		}
+		if got := tag.OwnedBy(owner); got != want.exact {
+			t.Errorf("OwnedBy for %q: got %v, want %v", desc, got, want.exact)
+		}
+		if got := tag.OwnedBy(migrating); got != want.migrating {
+			t.Errorf("OwnedBy with AdoptUnclustered for %q: got %v, want %v", desc, got, want.migrating)
+		}
+	}
+	// A controller without a cluster ID has nothing to migrate from.
+	unclustered := Owner{Identity: Identity{ManagedBy: "opnsense-lb-controller", AdoptUnclustered: true}, Namespace: "default", Name: "web"}
+	tag, _ := ParseTag("lbc/v1 by=opnsense-lb-controller cluster=prod svc=default/web uid=old port=http")
+	if tag.OwnedBy(unclustered) {
+		t.Error("a controller without a cluster ID must not own objects of cluster prod")
+	}
+}
+
+func TestParseVIPTag(t *testing.T) {
+	id := Identity{ManagedBy: "opnsense-lb-controller", ClusterID: "prod"}
+	if got, ok := ParseVIPTag(id.VIPDescription("192.0.2.1")); !ok || got != (VIPTag{Identity: id, VIP: "192.0.2.1"}) {
+		t.Errorf("round trip: got %+v, %v", got, ok)
+	}
+	if got, ok := ParseVIPTag("opnsense-lb-controller 192.0.2.1"); !ok || !got.Legacy || got.ManagedBy != "opnsense-lb-controller" {
+		t.Errorf("legacy: got %+v, %v", got, ok)
+	}
+	for _, desc := range []string{"", "uplink alias", "lbc/v1 by=opnsense-lb-controller cluster=prod vip=web", "lbc/v1 by= cluster= vip=192.0.2.1"} {
+		if _, ok := ParseVIPTag(desc); ok {
+			t.Errorf("ParseVIPTag(%q): want false", desc)
+		}
+	}
+}
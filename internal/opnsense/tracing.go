@@ -0,0 +1,42 @@
+package opnsense
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates a client span per API request. It resolves the global tracer provider lazily,
+// so it stays a no-op unless tracing is set up (see package tracing).
+var tracer = otel.Tracer("github.com/scheuk/opnsense-lb-controller/internal/opnsense")
+
+// startSpan starts the span for req to endpoint, a child of the span in req's context. Only the
+// method and path are recorded: never the query, headers or credentials.
+func startSpan(req *http.Request, endpoint string) trace.Span {
+	_, span := tracer.Start(req.Context(), "OPNsense "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("opnsense.endpoint", endpoint),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		))
+	return span
+}
+
+// endSpan records the outcome of the request on span and ends it.
+func endSpan(span trace.Span, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode != http.StatusOK {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	span.End()
+}
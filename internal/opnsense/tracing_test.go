@@ -0,0 +1,50 @@
+package opnsense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestClient_tracesAPIRequests(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"rows":[]}`))
+	}))
+	defer server.Close()
+	cli := NewClient(Config{BaseURL: server.URL, APIKey: "key-123", APISecret: "secret-456", Client: server.Client()})
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "Reconcile")
+	if _, err := cli.ListNATRules(ctx); err != nil {
+		t.Fatalf("ListNATRules: %v", err)
+	}
+	parent.End()
+
+	var found bool
+	for _, span := range spans.Ended() {
+		for _, kv := range span.Attributes() {
+			if v := kv.Value.Emit(); strings.Contains(v, "key-123") || strings.Contains(v, "secret-456") {
+				t.Errorf("span %s attribute %s contains credentials", span.Name(), kv.Key)
+			}
+		}
+		if span.Name() == "OPNsense d_nat search_rule" {
+			found = true
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Error("API request span is not a child of the caller's span")
+			}
+		}
+	}
+	if !found {
+		t.Error("no span for the d_nat search_rule request")
+	}
+}
@@ -0,0 +1,52 @@
+// Package tracing configures OpenTelemetry tracing for the controller.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// ServiceName is the default service.name resource attribute; OTEL_SERVICE_NAME overrides it.
+const ServiceName = "opnsense-lb-controller"
+
+// Setup installs the global tracer provider for exporter and returns a function that flushes
+// and stops it. With ExporterNone (or "") tracing stays off: the global no-op provider is kept
+// and spans cost nothing. ExporterOTLP sends spans over OTLP/gRPC, configured with the standard
+// OTEL_EXPORTER_OTLP_* variables (endpoint, headers, TLS); sampling follows OTEL_TRACES_SAMPLER.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q: must be %q or %q", exporter, ExporterNone, ExporterOTLP)
+	}
+	exp, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+	}
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(ServiceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}